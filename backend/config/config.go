@@ -6,6 +6,7 @@ import (
 	"github.com/gurkankaymak/hocon"
 	"os"
 	"strings"
+	"time"
 )
 
 //go:embed default.conf
@@ -37,13 +38,37 @@ func Load() *appConfig {
 	if err != nil {
 		panic(err)
 	}
+	// Settings added after an application.conf was generated fall back to the
+	// embedded defaults.
+	defaults, err := hocon.ParseString(defaultConfig)
+	if err != nil {
+		panic(err)
+	}
+	conf = conf.WithFallback(defaults)
 	Config = &appConfig{
-		Port:          conf.GetInt("app.port"),
-		ServeFrontend: conf.GetBoolean("app.serve_frontend"),
+		Port:            conf.GetInt("app.port"),
+		ServeFrontend:   conf.GetBoolean("app.serve_frontend"),
+		ShutdownTimeout: conf.GetDuration("app.shutdown_timeout"),
 		Paths: paths{
 			Parquet:  getString(conf, "paths.parquet"),
 			Database: getString(conf, "paths.database"),
 		},
+		Ingestion: ingestion{
+			QueueCapacity:      conf.GetInt("ingestion.queue_capacity"),
+			MaxPropertiesBytes: conf.GetInt("ingestion.max_properties_bytes"),
+			MaxBodyBytes:       int64(conf.GetInt("ingestion.max_body_bytes")),
+			DedupWindow:        conf.GetDuration("ingestion.dedup_window"),
+			GeoIPDatabase:      getString(conf, "ingestion.geoip_database"),
+			RateLimit: rateLimit{
+				Key:          conf.GetInt("ingestion.rate_limit.key"),
+				Project:      conf.GetInt("ingestion.rate_limit.project"),
+				BurstSeconds: conf.GetInt("ingestion.rate_limit.burst_seconds"),
+			},
+			MonthlyQuota:       conf.GetInt("ingestion.monthly_quota"),
+			TimestampPolicy:    getString(conf, "ingestion.timestamp_policy"),
+			TimestampMaxAge:    conf.GetDuration("ingestion.timestamp_max_age"),
+			TimestampMaxFuture: conf.GetDuration("ingestion.timestamp_max_future"),
+		},
 		Database: database{
 			ProjectPrefix:   getString(conf, "database.project_prefix"),
 			AnalyticsPrefix: getString(conf, "database.analytics_prefix"),
@@ -65,11 +90,13 @@ func getString(c *hocon.Config, key string) string {
 }
 
 type appConfig struct {
-	Port          int
-	ServeFrontend bool
-	Paths         paths
-	Database      database
-	Auth          auth
+	Port            int
+	ServeFrontend   bool
+	ShutdownTimeout time.Duration
+	Paths           paths
+	Ingestion       ingestion
+	Database        database
+	Auth            auth
 }
 
 type paths struct {
@@ -77,6 +104,25 @@ type paths struct {
 	Database string
 }
 
+type ingestion struct {
+	QueueCapacity      int
+	MaxPropertiesBytes int
+	MaxBodyBytes       int64
+	DedupWindow        time.Duration
+	GeoIPDatabase      string
+	RateLimit          rateLimit
+	MonthlyQuota       int
+	TimestampPolicy    string
+	TimestampMaxAge    time.Duration
+	TimestampMaxFuture time.Duration
+}
+
+type rateLimit struct {
+	Key          int
+	Project      int
+	BurstSeconds int
+}
+
 type database struct {
 	ProjectPrefix   string
 	AnalyticsPrefix string
@@ -2,9 +2,11 @@ package cron
 
 import (
 	"analytics/log"
+	"errors"
 	"github.com/go-co-op/gocron/v2"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"time"
 )
 
 var Scheduler gocron.Scheduler
@@ -21,6 +23,11 @@ func Init() {
 	Scheduler.Start()
 }
 
+// Shutdown stops the scheduler and waits for running jobs to finish.
+func Shutdown() error {
+	return Scheduler.Shutdown()
+}
+
 var _ gocron.Logger = &cronLogger{}
 
 type cronLogger struct{}
@@ -71,6 +78,31 @@ func InitProjectCron(projectId string, db *gorm.DB, taskFn func(string, *gorm.DB
 	return nil
 }
 
+// InitIntervalCron runs taskFn every interval, for tasks that are not bound to
+// a project.
+func InitIntervalCron(interval time.Duration, taskFn func()) error {
+	_, err := Scheduler.NewJob(
+		gocron.DurationJob(interval),
+		gocron.NewTask(taskFn),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	)
+	return err
+}
+
+// ScheduleProjectTask runs taskFn once after delay. The task is removed with
+// the other tasks of the project by StopProjectCrons.
+func ScheduleProjectTask(projectId string, delay time.Duration, taskFn func()) error {
+	if Scheduler == nil {
+		return errors.New("scheduler is not running")
+	}
+	_, err := Scheduler.NewJob(
+		gocron.OneTimeJob(gocron.OneTimeJobStartDateTime(time.Now().Add(delay))),
+		gocron.NewTask(taskFn),
+		gocron.WithTags(projectId),
+	)
+	return err
+}
+
 func StopProjectCrons(projectId string) error {
 	Scheduler.RemoveByTags(projectId)
 	return nil
@@ -0,0 +1,5 @@
+create table batches
+(
+    id           text primary key,
+    committed_at timestamp not null
+);
@@ -0,0 +1 @@
+drop table if exists person_distinct_ids;
@@ -0,0 +1,6 @@
+create table person_distinct_ids
+(
+    distinct_id text primary key,
+    person_id   text      not null,
+    merged_at   timestamp not null
+);
@@ -0,0 +1,2 @@
+alter table persons drop column property_once;
+alter table events drop column person_operations;
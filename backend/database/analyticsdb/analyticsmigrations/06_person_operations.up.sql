@@ -0,0 +1,2 @@
+alter table events add column person_operations json;
+alter table persons add column property_once json;
@@ -0,0 +1,2 @@
+drop table if exists groups;
+alter table events drop column groups;
@@ -0,0 +1,11 @@
+alter table events add column groups json;
+
+create table groups
+(
+    group_type          text      not null,
+    group_key           text      not null,
+    first_seen          timestamp not null,
+    properties          json      not null,
+    property_timestamps json      not null,
+    primary key (group_type, group_key)
+);
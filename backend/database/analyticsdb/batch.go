@@ -0,0 +1,72 @@
+package analyticsdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/duckdb/duckdb-go/v2"
+)
+
+// BatchTx runs statements and appends rows in a single transaction. Both use
+// the same connection, so the appended rows are committed or rolled back
+// together with the statements.
+type BatchTx struct {
+	conn *sql.Conn
+	done bool
+}
+
+func BeginBatch(db DuckDB) (*BatchTx, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(context.Background(), "BEGIN TRANSACTION"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &BatchTx{conn: conn}, nil
+}
+
+func (t *BatchTx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.conn.ExecContext(context.Background(), query, args...)
+}
+
+// Append appends the rows to a table. A failing row fails all of them.
+func (t *BatchTx) Append(table string, rows [][]driver.Value) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return t.conn.Raw(func(driverConn any) error {
+		appender, err := duckdb.NewAppenderFromConn(driverConn.(driver.Conn), "", table)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := appender.AppendRow(row...); err != nil {
+				appender.Close()
+				return err
+			}
+		}
+		return appender.Close()
+	})
+}
+
+func (t *BatchTx) Commit() error {
+	return t.finish("COMMIT")
+}
+
+// Rollback does nothing once the transaction is finished, so it can be
+// deferred.
+func (t *BatchTx) Rollback() error {
+	return t.finish("ROLLBACK")
+}
+
+func (t *BatchTx) finish(statement string) error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	defer t.conn.Close()
+	_, err := t.conn.ExecContext(context.Background(), statement)
+	return err
+}
@@ -12,6 +12,7 @@ import (
 type DuckDB interface {
 	Appender(table string) DuckDBAppender
 	Tx() (*sql.Tx, error)
+	Conn() (*sql.Conn, error)
 }
 type DuckDBConnection struct {
 	Db         *sql.DB
@@ -32,6 +33,33 @@ func (c *DuckDBConnection) Tx() (*sql.Tx, error) {
 	return c.Db.BeginTx(context.Background(), nil)
 }
 
+func (c *DuckDBConnection) Conn() (*sql.Conn, error) {
+	return c.Db.Conn(context.Background())
+}
+
+// Close checkpoints the DuckDB file, so the write-ahead log of DuckDB is folded
+// into the database, and closes the connection.
+func (c *DuckDBConnection) Close() error {
+	if _, err := c.Db.Exec("CHECKPOINT"); err != nil {
+		log.Error("Error while checkpointing duckdb: %v", err)
+	}
+	if c.connection != nil {
+		if err := c.connection.Close(); err != nil {
+			return err
+		}
+	}
+	return c.Db.Close()
+}
+
+func CloseAll() {
+	for projectId, db := range LookupTable {
+		if err := db.Close(); err != nil {
+			log.Error("Project %s: Error while closing duckdb: %v", projectId, err)
+		}
+		delete(LookupTable, projectId)
+	}
+}
+
 func InitProjectDB(projectId string, analyticsDbFilePath string) {
 	connector, err := duckdb.NewConnector(analyticsDbFilePath+"?"+"access_mode=READ_WRITE", nil)
 	if err != nil {
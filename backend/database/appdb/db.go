@@ -65,3 +65,26 @@ func Init() *gorm.DB {
 
 	return appDb
 }
+
+// Close checkpoints and closes a SQLite database opened through gorm.
+func Close(db *gorm.DB) error {
+	if err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error; err != nil {
+		log.Error("Error while checkpointing database: %v", err)
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.Close()
+}
+
+func CloseAll(appDb *gorm.DB) {
+	for projectId, db := range ProjectDBs {
+		if err := Close(db); err != nil {
+			log.Error("Project %s: Error closing database: %v", projectId, err)
+		}
+	}
+	if err := Close(appDb); err != nil {
+		log.Error("Error closing app database: %v", err)
+	}
+}
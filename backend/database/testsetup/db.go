@@ -59,6 +59,10 @@ func (c *TestDuckDB) Tx() (*sql.Tx, error) {
 	return c.db.BeginTx(context.Background(), nil)
 }
 
+func (c *TestDuckDB) Conn() (*sql.Conn, error) {
+	return c.db.Conn(context.Background())
+}
+
 func (c *TestDuckDB) Close() {
 	c.connection.Close()
 	c.db.Close()
@@ -2,13 +2,70 @@ package apikeys
 
 import (
 	"gorm.io/gorm"
+	"sync"
+	"time"
 )
 
+// cacheTTL is how long the result of a key lookup is reused, so that
+// ingestion requests do not query the app database every time.
+const cacheTTL = time.Minute
+
+type cachedKey struct {
+	project string
+	expires time.Time
+}
+
+var (
+	cacheMu       sync.Mutex
+	cache         = make(map[string]cachedKey)
+	evictionStart sync.Once
+)
+
+// ValidateAPIKey returns the project of a key. Only existing keys are cached,
+// so the cache cannot grow beyond the number of keys however many unknown
+// keys clients send.
 func ValidateAPIKey(appdb *gorm.DB, apiKey string) (string, error) {
+	evictionStart.Do(func() { go evictExpiredKeys() })
+
+	now := time.Now()
+	cacheMu.Lock()
+	cached, ok := cache[apiKey]
+	cacheMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.project, nil
+	}
+
 	var key ApiKey
-	err := appdb.First(&key, "key = ?", apiKey).Error
-	if err != nil {
+	if err := appdb.First(&key, "key = ?", apiKey).Error; err != nil {
 		return "", err
 	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cache[apiKey] = cachedKey{project: key.Project, expires: now.Add(cacheTTL)}
 	return key.Project, nil
 }
+
+// evictExpiredKeys removes expired entries once per cacheTTL, so that deleted
+// keys do not stay in the cache.
+func evictExpiredKeys() {
+	ticker := time.NewTicker(cacheTTL)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		cacheMu.Lock()
+		for apiKey, entry := range cache {
+			if now.After(entry.expires) {
+				delete(cache, apiKey)
+			}
+		}
+		cacheMu.Unlock()
+	}
+}
+
+// ForgetAPIKey removes a key from the lookup cache. It has to be called when a
+// key is created or deleted.
+func ForgetAPIKey(apiKey string) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	delete(cache, apiKey)
+}
@@ -0,0 +1,201 @@
+package deadletters
+
+import (
+	"analytics/domain/events"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Stage is the step of storing an event that failed.
+type Stage string
+
+const (
+	// StageEncode fails for single events while the rest of the batch is
+	// persisted.
+	StageEncode Stage = "encode"
+	// The other stages fail for the whole batch, which is committed at once.
+	StageAppend     Stage = "append"
+	StageIdentities Stage = "identities"
+	StagePersist    Stage = "persist"
+	// StageQuarantine holds events that violated the tracking plan. The plan
+	// is checked after the transformations and sampling and the property
+	// policies are applied before quarantining, so like the stages above
+	// they are only stored on retry.
+	StageQuarantine Stage = "quarantine"
+	// StageQueue holds queued events whose batch kept failing before they
+	// reached storage, e.g. because the rules of the project could not be
+	// read. They are kept as they were queued and, unlike all other stages,
+	// run the whole pipeline on retry.
+	StageQueue Stage = "queue"
+)
+
+// DeadLetter keeps an event that could not be stored together with the error,
+// so it can be inspected and retried instead of being lost.
+type DeadLetter struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	EventId   string    `gorm:"index;not null" json:"eventId"`
+	EventType string    `gorm:"not null" json:"eventType"`
+	Timestamp time.Time `gorm:"not null" json:"timestamp"`
+	Stage     Stage     `gorm:"index;not null" json:"stage"`
+	Error     string    `gorm:"not null" json:"error"`
+	// Payload is the event as it was about to be stored, after the ingestion
+	// pipeline ran, or as it was queued for StageQueue. It is omitted from
+	// lists.
+	Payload   json.RawMessage `gorm:"type:json;not null" json:"payload,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// RetryResult counts the outcome of retrying dead letters. Retried letters
+// are removed, events that fail again are kept as new dead letters.
+type RetryResult struct {
+	Retried    int `json:"retried"`
+	Stored     int `json:"stored"`
+	Duplicates int `json:"duplicates"`
+	// Dropped events of StageQueue letters were filtered by the pipeline,
+	// e.g. as bots or by sampling, quarantined or became dead letters again.
+	Dropped int `json:"dropped"`
+	Failed  int `json:"failed"`
+}
+
+// New creates the dead letter of an event. The payload keeps the id of the
+// event, so a retry stores it under the same id.
+func New(event *events.Event, stage Stage, cause error) DeadLetter {
+	input := event.EventInput
+	input.Uuid = &event.Id
+	input.Ip = ""
+	input.UserAgent = ""
+	payload, err := json.Marshal(input)
+	if err != nil {
+		// Events that cannot be encoded are kept in a readable form, they
+		// cannot be retried.
+		payload, _ = json.Marshal(fmt.Sprintf("%+v", input))
+	}
+	return DeadLetter{
+		EventId:   event.Id.String(),
+		EventType: event.EventType,
+		Timestamp: event.Timestamp,
+		Stage:     stage,
+		Error:     cause.Error(),
+		Payload:   payload,
+	}
+}
+
+// Quarantine creates the dead letter of an event that violated the tracking
+// plan. Events that have no id yet get one, so retrying them twice stores
+// them once.
+func Quarantine(input *events.EventInput, cause error) DeadLetter {
+	event := &events.Event{EventInput: *input}
+	if input.Uuid != nil {
+		event.Id = *input.Uuid
+	} else {
+		event.Id = uuid.New()
+	}
+	return New(event, StageQuarantine, cause)
+}
+
+func FromEvents(failed []*events.Event, stage Stage, cause error) []DeadLetter {
+	letters := make([]DeadLetter, len(failed))
+	for i, event := range failed {
+		letters[i] = New(event, stage, cause)
+	}
+	return letters
+}
+
+// Event decodes the payload of a dead letter.
+func (l DeadLetter) Event() (*events.EventInput, error) {
+	var event events.EventInput
+	if err := json.Unmarshal(l.Payload, &event); err != nil {
+		return nil, fmt.Errorf("dead letter %d cannot be retried: %w", l.ID, err)
+	}
+	return &event, nil
+}
+
+// deleteChunkSize keeps the ids of a delete below the variable limit of
+// SQLite.
+const deleteChunkSize = 500
+
+func Save(db *gorm.DB, letters []DeadLetter) error {
+	if len(letters) == 0 {
+		return nil
+	}
+	return db.CreateInBatches(letters, 100).Error
+}
+
+// List returns a page of dead letters without their payloads, the latest
+// first, and the number of dead letters in total.
+func List(db *gorm.DB, limit int, offset int) ([]DeadLetter, int64, error) {
+	var total int64
+	if err := db.Model(&DeadLetter{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	letters := make([]DeadLetter, 0)
+	err := db.Select("id", "event_id", "event_type", "timestamp", "stage", "error", "created_at").
+		Order("id desc").Limit(limit).Offset(offset).Find(&letters).Error
+	return letters, total, err
+}
+
+func Get(db *gorm.DB, id uint) (*DeadLetter, error) {
+	var letter DeadLetter
+	if err := db.First(&letter, id).Error; err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+func Find(db *gorm.DB, ids []uint) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := db.Where("id in ?", ids).Order("id").Find(&letters).Error
+	return letters, err
+}
+
+// Range returns up to limit dead letters with ids after afterId and up to
+// lastId, the oldest first. Bounding the ids keeps the events that fail again
+// while the range is retried out of it.
+func Range(db *gorm.DB, afterId uint, lastId uint, limit int) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := db.Where("id > ? and id <= ?", afterId, lastId).Order("id").Limit(limit).Find(&letters).Error
+	return letters, err
+}
+
+// LastID returns the id of the latest dead letter, 0 if there is none.
+func LastID(db *gorm.DB) (uint, error) {
+	var id uint
+	err := db.Model(&DeadLetter{}).Select("coalesce(max(id), 0)").Scan(&id).Error
+	return id, err
+}
+
+func Delete(db *gorm.DB, ids []uint) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := db.Delete(&DeadLetter{}, ids)
+	return result.RowsAffected, result.Error
+}
+
+// DeleteOfIds removes the dead letters of events sent with any of the ids as
+// person or session id. It returns the number of removed dead letters.
+func DeleteOfIds(db *gorm.DB, ids []string) (int64, error) {
+	var removed int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(ids); start += deleteChunkSize {
+			chunk := ids[start:min(start+deleteChunkSize, len(ids))]
+			result := tx.Where("json_extract(payload, '$.personId') IN ? OR json_extract(payload, '$.sessionId') IN ?", chunk, chunk).
+				Delete(&DeadLetter{})
+			if result.Error != nil {
+				return result.Error
+			}
+			removed += result.RowsAffected
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// Purge removes all dead letters of a project.
+func Purge(db *gorm.DB) (int64, error) {
+	result := db.Where("1 = 1").Delete(&DeadLetter{})
+	return result.RowsAffected, result.Error
+}
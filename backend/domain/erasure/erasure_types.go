@@ -0,0 +1,42 @@
+package erasure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+// Erasure records the erasure of a person on request of its subject. The id
+// of the person is only kept as a hash, so the record proves an erasure of a
+// known id without keeping the id itself.
+type Erasure struct {
+	ID          uint   `gorm:"primarykey" json:"id"`
+	SubjectHash string `gorm:"index;not null" json:"subjectHash"`
+	Status      Status `gorm:"index;not null" json:"status"`
+	// Error tells why a failed erasure stopped. Erasing the person again
+	// continues with what is left.
+	Error        string `json:"error,omitempty"`
+	Events       int64  `json:"events"`
+	Sessions     int64  `json:"sessions"`
+	SchemaValues int64  `json:"schemaValues"`
+	DeadLetters  int64  `json:"deadLetters"`
+	// QueuedEvents were accepted but not processed yet.
+	QueuedEvents int64      `json:"queuedEvents"`
+	Files        int64      `json:"files"`
+	RequestedAt  time.Time  `gorm:"not null" json:"requestedAt"`
+	CompletedAt  *time.Time `json:"completedAt"`
+}
+
+// HashSubject returns the hash an erasure keeps of the id of a person.
+func HashSubject(personId string) string {
+	hash := sha256.Sum256([]byte(personId))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,39 @@
+package erasure
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Start records that the erasure of a person began.
+func Start(db *gorm.DB, personId string, now time.Time) (Erasure, error) {
+	erasure := Erasure{
+		SubjectHash: HashSubject(personId),
+		Status:      StatusRunning,
+		RequestedAt: now,
+	}
+	return erasure, db.Create(&erasure).Error
+}
+
+// Finish records the outcome of an erasure, failed if err is not nil.
+func Finish(db *gorm.DB, erasure *Erasure, err error, now time.Time) error {
+	erasure.Status = StatusCompleted
+	if err != nil {
+		erasure.Status = StatusFailed
+		erasure.Error = err.Error()
+	}
+	erasure.CompletedAt = &now
+	return db.Save(erasure).Error
+}
+
+// List returns the erasures, the latest first. A subject limits them to the
+// erasures of that person id.
+func List(db *gorm.DB, subject string) ([]Erasure, error) {
+	query := db.Order("requested_at desc, id desc")
+	if subject != "" {
+		query = query.Where("subject_hash = ?", HashSubject(subject))
+	}
+	erasures := make([]Erasure, 0)
+	err := query.Find(&erasures).Error
+	return erasures, err
+}
@@ -0,0 +1,95 @@
+package events
+
+import (
+	"fmt"
+	"time"
+)
+
+// ClientTimestampProperty keeps the timestamp sent by the client when it was
+// changed during ingestion.
+const ClientTimestampProperty = "$client_timestamp"
+
+// TimestampPolicy decides what happens to events whose timestamp lies outside
+// the plausible window of a project.
+type TimestampPolicy string
+
+const (
+	// TimestampPolicyOff keeps all timestamps.
+	TimestampPolicyOff TimestampPolicy = "off"
+	// TimestampPolicyClamp sets timestamps outside the window to the time the
+	// event was received.
+	TimestampPolicyClamp TimestampPolicy = "clamp"
+	// TimestampPolicyReject rejects events with timestamps outside the window.
+	TimestampPolicyReject TimestampPolicy = "reject"
+)
+
+func ParseTimestampPolicy(value string) (TimestampPolicy, bool) {
+	switch policy := TimestampPolicy(value); policy {
+	case TimestampPolicyOff, TimestampPolicyClamp, TimestampPolicyReject:
+		return policy, true
+	}
+	return "", false
+}
+
+// TimestampWindow is the range around the receive time in which event
+// timestamps are plausible.
+type TimestampWindow struct {
+	Policy    TimestampPolicy
+	MaxAge    time.Duration
+	MaxFuture time.Duration
+}
+
+// ParseSentAt parses the time a client sent a batch at. An empty value
+// returns the zero time.
+func ParseSentAt(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	sentAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("sentAt %q is not in ISO 8601 format", value)
+	}
+	return sentAt, nil
+}
+
+// AdjustTimestamp corrects the timestamp of an event by the difference between
+// the server's and the client's clock, measured from the time the client sent
+// the batch at and the time it was received. A zero sentAt skips the
+// correction. The corrected timestamp is then checked against the window. The
+// original timestamp is kept in ClientTimestampProperty if it was changed.
+//
+// Events without a timestamp are left alone, they get the time they are
+// processed at.
+func AdjustTimestamp(event *EventInput, sentAt time.Time, receivedAt time.Time, window TimestampWindow) *ValidationError {
+	if event.Timestamp.IsZero() {
+		return nil
+	}
+	original := event.Timestamp
+	if !sentAt.IsZero() {
+		event.Timestamp = event.Timestamp.Add(receivedAt.Sub(sentAt))
+	}
+
+	if window.Policy != TimestampPolicyOff && window.Policy != "" {
+		earliest := receivedAt.Add(-window.MaxAge)
+		latest := receivedAt.Add(window.MaxFuture)
+		if event.Timestamp.Before(earliest) || event.Timestamp.After(latest) {
+			if window.Policy == TimestampPolicyReject {
+				event.Timestamp = original
+				return &ValidationError{
+					Reason: TimestampOutOfRange,
+					Message: fmt.Sprintf("timestamp %s is outside of %s to %s",
+						original.Format(time.RFC3339), earliest.Format(time.RFC3339), latest.Format(time.RFC3339)),
+				}
+			}
+			event.Timestamp = receivedAt
+		}
+	}
+
+	if !event.Timestamp.Equal(original) {
+		if event.Properties == nil {
+			event.Properties = make(map[string]any)
+		}
+		event.Properties[ClientTimestampProperty] = original.Format(time.RFC3339Nano)
+	}
+	return nil
+}
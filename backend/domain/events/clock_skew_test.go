@@ -0,0 +1,50 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestAdjustTimestampCorrectsClockSkew(t *testing.T) {
+	receivedAt := time.Date(2025, 2, 23, 12, 0, 0, 0, time.UTC)
+	sentAt := receivedAt.Add(-2 * time.Hour)
+	window := TimestampWindow{Policy: TimestampPolicyOff}
+
+	event := &EventInput{Timestamp: sentAt.Add(-time.Minute)}
+	assert.Nil(t, AdjustTimestamp(event, sentAt, receivedAt, window))
+	assert.Equal(t, receivedAt.Add(-time.Minute), event.Timestamp)
+	assert.Equal(t, "2025-02-23T09:59:00Z", event.Properties[ClientTimestampProperty])
+
+	untouched := &EventInput{Timestamp: receivedAt.Add(-time.Minute)}
+	assert.Nil(t, AdjustTimestamp(untouched, time.Time{}, receivedAt, window))
+	assert.Equal(t, receivedAt.Add(-time.Minute), untouched.Timestamp)
+	assert.Nil(t, untouched.Properties)
+
+	missing := &EventInput{}
+	assert.Nil(t, AdjustTimestamp(missing, sentAt, receivedAt, window))
+	assert.True(t, missing.Timestamp.IsZero())
+}
+
+func TestAdjustTimestampAppliesWindow(t *testing.T) {
+	receivedAt := time.Date(2025, 2, 23, 12, 0, 0, 0, time.UTC)
+	future := receivedAt.Add(48 * time.Hour)
+	window := TimestampWindow{MaxAge: 24 * time.Hour, MaxFuture: time.Hour}
+
+	window.Policy = TimestampPolicyClamp
+	clamped := &EventInput{Timestamp: future}
+	assert.Nil(t, AdjustTimestamp(clamped, time.Time{}, receivedAt, window))
+	assert.Equal(t, receivedAt, clamped.Timestamp)
+	assert.Equal(t, "2025-02-25T12:00:00Z", clamped.Properties[ClientTimestampProperty])
+
+	window.Policy = TimestampPolicyReject
+	rejected := &EventInput{Timestamp: receivedAt.AddDate(-1, 0, 0)}
+	err := AdjustTimestamp(rejected, time.Time{}, receivedAt, window)
+	assert.NotNil(t, err)
+	assert.Equal(t, TimestampOutOfRange, err.Reason)
+
+	inside := &EventInput{Timestamp: receivedAt.Add(30 * time.Minute)}
+	assert.Nil(t, AdjustTimestamp(inside, time.Time{}, receivedAt, window))
+	assert.Equal(t, receivedAt.Add(30*time.Minute), inside.Timestamp)
+}
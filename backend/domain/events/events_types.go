@@ -7,12 +7,40 @@ import (
 )
 
 type EventInput struct {
+	// Uuid becomes the id of the stored event. It is assigned when the event is
+	// accepted, so it is known to the client before the event is persisted.
+	Uuid             *uuid.UUID     `json:"uuid,omitempty"`
 	EventType        string         `json:"eventType"`
 	SessionId        *string        `json:"sessionId,omitempty"`
 	PersonId         *string        `json:"personId,omitempty"`
 	Timestamp        time.Time      `json:"timestamp"`
 	Properties       map[string]any `json:"properties,omitempty"`
 	PersonProperties map[string]any `json:"personProperties,omitempty"`
+	// PersonPropertiesOnce are only applied to properties the person does not
+	// have yet.
+	PersonPropertiesOnce map[string]any `json:"personPropertiesOnce,omitempty"`
+	// PersonPropertiesUnset are removed from the person.
+	PersonPropertiesUnset []string `json:"personPropertiesUnset,omitempty"`
+	// PersonPropertiesAdd are added to numeric properties of the person,
+	// which start at zero.
+	PersonPropertiesAdd map[string]float64 `json:"personPropertiesAdd,omitempty"`
+	// PersonPropertiesAppend are appended to list properties of the person,
+	// which start empty. Lists append each of their values.
+	PersonPropertiesAppend map[string]any `json:"personPropertiesAppend,omitempty"`
+	// PersonPropertiesUnion are appended like PersonPropertiesAppend, except
+	// for values the list contains already.
+	PersonPropertiesUnion map[string]any `json:"personPropertiesUnion,omitempty"`
+	// Groups maps group types, e.g. company, to the key of the group the event
+	// is attributed to.
+	Groups map[string]string `json:"groups,omitempty"`
+	// GroupProperties are set on the groups of the event, by group type.
+	GroupProperties map[string]map[string]any `json:"groupProperties,omitempty"`
+	// Ip is the address the event was sent from. It is only used for
+	// enrichment and discarded before the event is persisted.
+	Ip string `json:"ip,omitempty"`
+	// UserAgent is the User-Agent header of the request. It is parsed into
+	// properties and discarded before the event is persisted.
+	UserAgent string `json:"userAgent,omitempty"`
 }
 
 type EventId struct {
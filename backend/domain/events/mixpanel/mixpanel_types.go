@@ -0,0 +1,15 @@
+package mixpanel
+
+// ExportRecord is a line of a Mixpanel raw event export or of a people export.
+// Events have an event name and properties, profiles have a $distinct_id and
+// $properties.
+type ExportRecord struct {
+	Event             string         `json:"event"`
+	Properties        map[string]any `json:"properties"`
+	DistinctId        any            `json:"$distinct_id"`
+	ProfileProperties map[string]any `json:"$properties"`
+}
+
+// ProfileEventType is the type of the events that profiles are imported as.
+// They only carry person properties.
+const ProfileEventType = "$set"
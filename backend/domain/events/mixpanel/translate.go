@@ -0,0 +1,192 @@
+package mixpanel
+
+import (
+	"analytics/domain/events"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventProperties maps the properties set by the Mixpanel SDKs to the property
+// names used by the PostHog integration. Properties both use the same name
+// for, like $browser or $current_url, are kept as they are.
+var eventProperties = map[string]string{
+	"mp_lib":              "$lib",
+	"$city":               "$geo_city",
+	"$region":             "$geo_region",
+	"mp_country_code":     "$geo_country",
+	"$manufacturer":       "$device_manufacturer",
+	"$model":              "$device_model",
+	"$app_version_string": "$app_version",
+	"$app_build_number":   "$app_build",
+}
+
+// droppedEventProperties are added by Mixpanel during ingestion or become
+// fields of the event.
+var droppedEventProperties = []string{
+	"time", "distinct_id", "$insert_id", "$user_id", "token", "$import",
+	"mp_processing_time_ms", "$mp_api_endpoint", "$mp_api_timestamp_ms",
+}
+
+var profileProperties = map[string]string{
+	"$email":        "email",
+	"$name":         "name",
+	"$first_name":   "first_name",
+	"$last_name":    "last_name",
+	"$phone":        "phone",
+	"$avatar":       "avatar",
+	"$created":      "created_at",
+	"$city":         "$geo_city",
+	"$region":       "$geo_region",
+	"$country_code": "$geo_country",
+}
+
+var droppedProfileProperties = []string{"$last_seen"}
+
+// deviceIdPrefix marks the distinct ids of anonymous users under Mixpanel's
+// simplified id merge.
+const deviceIdPrefix = "$device:"
+
+// ToEventInput maps a record of a Mixpanel export onto an event. Identified
+// events get the $user_id or distinct_id as person id and the $device_id as
+// session id, anonymous events only the session id. Events with an $insert_id
+// get an id derived from it and the fields Mixpanel deduplicates by, events
+// without keep a nil uuid.
+func ToEventInput(record ExportRecord) (*events.EventInput, *events.ValidationError) {
+	if record.ProfileProperties != nil {
+		return profileToEventInput(record)
+	}
+	if strings.TrimSpace(record.Event) == "" {
+		return nil, &events.ValidationError{Reason: events.MissingEventType, Message: "event is required"}
+	}
+	timestamp, err := parseTime(record.Properties["time"])
+	if err != nil {
+		return nil, &events.ValidationError{Reason: events.InvalidTimestamp, Message: err.Error()}
+	}
+
+	input := &events.EventInput{
+		EventType:  record.Event,
+		Timestamp:  timestamp,
+		Properties: renameProperties(record.Properties, eventProperties, droppedEventProperties),
+	}
+	distinctId := idString(record.Properties["distinct_id"])
+	userId := idString(record.Properties["$user_id"])
+	deviceId := idString(record.Properties["$device_id"])
+	switch {
+	case userId != "":
+		input.PersonId = &userId
+		if deviceId != "" {
+			input.SessionId = &deviceId
+		}
+	case strings.HasPrefix(distinctId, deviceIdPrefix):
+		anonymousId := strings.TrimPrefix(distinctId, deviceIdPrefix)
+		input.SessionId = &anonymousId
+	case distinctId != "" && distinctId == deviceId:
+		input.SessionId = &distinctId
+	case distinctId != "":
+		input.PersonId = &distinctId
+		if deviceId != "" {
+			input.SessionId = &deviceId
+		}
+	default:
+		return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: "distinct_id is required"}
+	}
+
+	if insertId := idString(record.Properties["$insert_id"]); insertId != "" {
+		id := events.DedupKeyUuid(fmt.Sprintf("mixpanel:%s:%s:%d:%s", record.Event, distinctId, timestamp.Unix(), insertId))
+		input.Uuid = &id
+	}
+	return input, nil
+}
+
+// profileToEventInput imports a profile as an event that sets its properties
+// on the person, timed by when the person was last seen.
+func profileToEventInput(record ExportRecord) (*events.EventInput, *events.ValidationError) {
+	distinctId := idString(record.DistinctId)
+	if distinctId == "" {
+		return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: "$distinct_id is required"}
+	}
+	seen := record.ProfileProperties["$last_seen"]
+	if seen == nil {
+		seen = record.ProfileProperties["$created"]
+	}
+	timestamp, err := parseProfileTime(seen)
+	if err != nil {
+		return nil, &events.ValidationError{Reason: events.InvalidTimestamp, Message: err.Error()}
+	}
+	id := events.DedupKeyUuid(fmt.Sprintf("mixpanel-profile:%s:%d", distinctId, timestamp.Unix()))
+	return &events.EventInput{
+		Uuid:             &id,
+		EventType:        ProfileEventType,
+		Timestamp:        timestamp,
+		PersonId:         &distinctId,
+		Properties:       map[string]any{},
+		PersonProperties: renameProperties(record.ProfileProperties, profileProperties, droppedProfileProperties),
+	}, nil
+}
+
+func renameProperties(properties map[string]any, names map[string]string, dropped []string) map[string]any {
+	renamed := make(map[string]any, len(properties))
+	for key, value := range properties {
+		if name, ok := names[key]; ok {
+			key = name
+		}
+		renamed[key] = value
+	}
+	for _, key := range dropped {
+		delete(renamed, key)
+	}
+	return renamed
+}
+
+// parseTime reads the time of an event, which is in seconds since the epoch in
+// exports and in milliseconds in some newer ones.
+func parseTime(value any) (time.Time, error) {
+	var epoch float64
+	switch v := value.(type) {
+	case float64:
+		epoch = v
+	case string:
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("time %q is not a number", v)
+		}
+		epoch = parsed
+	case nil:
+		return time.Time{}, fmt.Errorf("time is required")
+	default:
+		return time.Time{}, fmt.Errorf("time of type %T is not supported", value)
+	}
+	if math.Abs(epoch) > 1e11 {
+		return time.UnixMilli(int64(epoch)).UTC(), nil
+	}
+	seconds, fraction := math.Modf(epoch)
+	return time.Unix(int64(seconds), int64(fraction*1e9)).UTC(), nil
+}
+
+// parseProfileTime reads the dates of profiles, which are in UTC without a
+// time zone.
+func parseProfileTime(value any) (time.Time, error) {
+	text, _ := value.(string)
+	if text == "" {
+		return time.Time{}, fmt.Errorf("$last_seen or $created is required")
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", time.RFC3339Nano} {
+		if timestamp, err := time.Parse(layout, text); err == nil {
+			return timestamp.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("date %q is not in ISO 8601 format", text)
+}
+
+func idString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package mixpanel
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func parseRecord(t *testing.T, line string) ExportRecord {
+	var record ExportRecord
+	assert.NoError(t, json.Unmarshal([]byte(line), &record))
+	return record
+}
+
+func TestToEventInputMapsEvents(t *testing.T) {
+	identified, validationErr := ToEventInput(parseRecord(t, `{"event":"Signed Up","properties":{
+		"time":1672653600,"distinct_id":"user","$device_id":"device","$insert_id":"abc",
+		"mp_lib":"web","$city":"Berlin","mp_country_code":"DE","$browser":"Chrome","plan":"pro",
+		"mp_processing_time_ms":1672653601000}}`))
+	assert.Nil(t, validationErr)
+	assert.Equal(t, "Signed Up", identified.EventType)
+	assert.Equal(t, time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC), identified.Timestamp)
+	assert.Equal(t, "user", *identified.PersonId)
+	assert.Equal(t, "device", *identified.SessionId)
+	assert.Equal(t, map[string]any{
+		"$device_id": "device", "$lib": "web", "$geo_city": "Berlin", "$geo_country": "DE",
+		"$browser": "Chrome", "plan": "pro",
+	}, identified.Properties)
+
+	retried, _ := ToEventInput(parseRecord(t, `{"event":"Signed Up","properties":{
+		"time":1672653600,"distinct_id":"user","$insert_id":"abc"}}`))
+	assert.Equal(t, *identified.Uuid, *retried.Uuid)
+	other, _ := ToEventInput(parseRecord(t, `{"event":"Signed Up","properties":{
+		"time":1672653600,"distinct_id":"user","$insert_id":"def"}}`))
+	assert.NotEqual(t, *identified.Uuid, *other.Uuid)
+
+	anonymous, validationErr := ToEventInput(parseRecord(t, `{"event":"Viewed","properties":{
+		"time":1672653600123,"distinct_id":"$device:device"}}`))
+	assert.Nil(t, validationErr)
+	assert.Nil(t, anonymous.PersonId)
+	assert.Equal(t, "device", *anonymous.SessionId)
+	assert.Nil(t, anonymous.Uuid)
+	assert.Equal(t, time.Date(2023, 1, 2, 10, 0, 0, 123000000, time.UTC), anonymous.Timestamp)
+
+	_, validationErr = ToEventInput(parseRecord(t, `{"event":"Viewed","properties":{"distinct_id":"user"}}`))
+	assert.Equal(t, "time is required", validationErr.Message)
+}
+
+func TestToEventInputMapsProfiles(t *testing.T) {
+	profile, validationErr := ToEventInput(parseRecord(t, `{"$distinct_id":42,"$properties":{
+		"$email":"test@example.com","$last_seen":"2023-01-02T10:00:00","$created":"2022-01-01T00:00:00","plan":"pro"}}`))
+	assert.Nil(t, validationErr)
+	assert.Equal(t, ProfileEventType, profile.EventType)
+	assert.Equal(t, "42", *profile.PersonId)
+	assert.Equal(t, time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC), profile.Timestamp)
+	assert.Equal(t, map[string]any{
+		"email": "test@example.com", "created_at": "2022-01-01T00:00:00", "plan": "pro",
+	}, profile.PersonProperties)
+}
@@ -4,12 +4,14 @@ import (
 	"analytics/config"
 	"analytics/database/analyticsdb"
 	"analytics/domain/filecatalog"
+	"analytics/domain/groups"
 	"analytics/log"
 	"analytics/util"
 	"errors"
 	"fmt"
 	"gorm.io/gorm"
 	"path"
+	"strings"
 	"time"
 )
 
@@ -18,6 +20,14 @@ func ExportEventsToParquet(projectId string, db *gorm.DB, segment filecatalog.Da
 	if !exists {
 		return errors.New("project not found")
 	}
+	return exportSegment(projectId, dbd, db, segment)
+}
+
+func exportSegment(projectId string, dbd analyticsdb.DuckDB, db *gorm.DB, segment filecatalog.DataSegment) error {
+	groupColumns, err := groupColumnsSQL(db)
+	if err != nil {
+		return err
+	}
 	tx, err := dbd.Tx()
 	if err != nil {
 		return err
@@ -29,12 +39,16 @@ SELECT e.id,
        e.timestamp,
        e.event_type,
        e.session_id,
-       coalesce(e.person_id, s.person_id) as person_id,
-       e.properties
+       coalesce(m.person_id, e.person_id, s.person_id) as person_id,
+       e.properties,
+       coalesce(try_cast(json_extract_string(e.properties, '$."$sample_weight"') AS DOUBLE), 1) as sample_weight,
+       %s
 FROM events e
 LEFT JOIN sessions s ON s.id = e.session_id
+LEFT JOIN person_distinct_ids m ON m.distinct_id = coalesce(e.person_id, s.person_id, e.session_id)
 WHERE e.timestamp >= '%s' AND e.timestamp <= '%s'
 `,
+		groupColumns,
 		segment.StartDate.Format(time.DateTime),
 		segment.EndDate.Format(time.DateTime),
 	)
@@ -80,6 +94,27 @@ WHERE e.timestamp >= '%s' AND e.timestamp <= '%s'
 	return nil
 }
 
+// groupColumnsSQL selects the key of the group of each group type into the
+// column of its index, group_0 to group_4. Columns of unused indexes are NULL,
+// so all files have the same columns.
+func groupColumnsSQL(db *gorm.DB) (string, error) {
+	types, err := groups.ListTypes(db)
+	if err != nil {
+		return "", err
+	}
+	columns := make([]string, groups.MaxGroupTypes)
+	for index := range columns {
+		columns[index] = fmt.Sprintf("NULL::TEXT as group_%d", index)
+	}
+	for _, groupType := range types {
+		if groupType.Index < 0 || groupType.Index >= groups.MaxGroupTypes || !groups.ValidTypeName(groupType.Name) {
+			continue
+		}
+		columns[groupType.Index] = fmt.Sprintf(`json_extract_string(e.groups, '$."%s"') as group_%d`, groupType.Name, groupType.Index)
+	}
+	return strings.Join(columns, ",\n       "), nil
+}
+
 func createCatalogEntry(
 	projectId string,
 	db *gorm.DB,
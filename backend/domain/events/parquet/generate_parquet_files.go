@@ -11,6 +11,8 @@ import (
 )
 
 func GenerateParquetFiles(projectId string, db *gorm.DB) {
+	defer lockGeneration(projectId)()
+
 	now := time.Now()
 	cutoff := now.AddDate(-2, 0, 0)
 	segments := filecatalog2.GenerateTimeFragments(now, cutoff)
@@ -0,0 +1,44 @@
+package parquet
+
+import (
+	"analytics/cron"
+	"analytics/log"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// regenerationDelay collects the late events of several batches before the
+// stale segments are exported again.
+const regenerationDelay = time.Minute
+
+var (
+	scheduledRegenerations sync.Map
+	generationLocks        sync.Map
+)
+
+// ScheduleRegeneration exports the missing and stale segments of a project
+// after regenerationDelay, instead of waiting for the daily run. Calls while
+// a regeneration is scheduled are no-ops.
+func ScheduleRegeneration(projectId string, db *gorm.DB) {
+	if _, scheduled := scheduledRegenerations.LoadOrStore(projectId, true); scheduled {
+		return
+	}
+	err := cron.ScheduleProjectTask(projectId, regenerationDelay, func() {
+		scheduledRegenerations.Delete(projectId)
+		GenerateParquetFiles(projectId, db)
+	})
+	if err != nil {
+		scheduledRegenerations.Delete(projectId)
+		log.Error("FileGen %s: Could not schedule regeneration: %s", projectId, err)
+	}
+}
+
+// lockGeneration keeps the daily run, scheduled regenerations and manual
+// regenerations of a project from writing the same files at once.
+func lockGeneration(projectId string) func() {
+	lock, _ := generationLocks.LoadOrStore(projectId, &sync.Mutex{})
+	mutex := lock.(*sync.Mutex)
+	mutex.Lock()
+	return mutex.Unlock
+}
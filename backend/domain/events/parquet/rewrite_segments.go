@@ -0,0 +1,77 @@
+package parquet
+
+import (
+	"analytics/config"
+	"analytics/database/analyticsdb"
+	"analytics/domain/filecatalog"
+	"gorm.io/gorm"
+	"os"
+	"path"
+	"time"
+)
+
+// RewriteSegments removes deleted events from the exported files right away
+// instead of waiting for a regeneration. The files of valid entries that
+// contained any of the timestamps are exported again with a new entry, which
+// replaces the old one. Files of stale entries are removed, a regeneration
+// exports them again if they are still needed. It returns the number of
+// rewritten and removed files.
+func RewriteSegments(projectId string, dbd analyticsdb.DuckDB, db *gorm.DB, timestamps []time.Time, now time.Time) (int64, error) {
+	defer lockGeneration(projectId)()
+
+	entries, err := filecatalog.ListCovering(db, timestamps)
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	entriesByName := make(map[string][]filecatalog.FileCatalogEntry)
+	for _, entry := range entries {
+		if _, ok := entriesByName[entry.Name]; !ok {
+			names = append(names, entry.Name)
+		}
+		entriesByName[entry.Name] = append(entriesByName[entry.Name], entry)
+	}
+
+	var files int64
+	for _, name := range names {
+		var ids []uint
+		var valid *filecatalog.FileCatalogEntry
+		for i, entry := range entriesByName[name] {
+			ids = append(ids, entry.ID)
+			if entry.ValidUntil == nil || entry.ValidUntil.After(now) {
+				valid = &entriesByName[name][i]
+			}
+		}
+
+		if valid == nil {
+			err := os.Remove(path.Join(config.Config.Paths.Parquet, projectId, name))
+			if err != nil && !os.IsNotExist(err) {
+				return files, err
+			}
+			files++
+			continue
+		}
+
+		segment := filecatalog.DataSegment{
+			StartDate:  *valid.Start,
+			EndDate:    now,
+			ValidUntil: valid.ValidUntil,
+			Filename:   name,
+		}
+		if valid.End != nil {
+			segment.EndDate = *valid.End
+		}
+		if err := exportSegment(projectId, dbd, db, segment); err != nil {
+			return files, err
+		}
+		err := db.Model(&filecatalog.FileCatalogEntry{}).
+			Where("id IN ?", ids).
+			Where("valid_until is null or valid_until > ?", now).
+			Update("valid_until", now).Error
+		if err != nil {
+			return files, err
+		}
+		files++
+	}
+	return files, nil
+}
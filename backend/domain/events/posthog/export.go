@@ -0,0 +1,90 @@
+package posthog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ExportEvent is an event of a PostHog export, as written by the batch exports
+// and the event export of the app. Depending on the destination, the objects
+// are json encoded strings and the timestamps lack the time zone.
+type ExportEvent struct {
+	Uuid             string          `json:"uuid"`
+	Event            string          `json:"event"`
+	DistinctId       json.RawMessage `json:"distinct_id"`
+	Timestamp        string          `json:"timestamp"`
+	Properties       json.RawMessage `json:"properties"`
+	Set              json.RawMessage `json:"set"`
+	SetOnce          json.RawMessage `json:"set_once"`
+	PersonProperties json.RawMessage `json:"person_properties"`
+}
+
+// exportTimestampLayouts are tried after RFC 3339. The ones without a time
+// zone are in UTC.
+var exportTimestampLayouts = []string{"2006-01-02 15:04:05.999999", "2006-01-02T15:04:05.999999"}
+
+// ToCaptureEvent turns an exported event into the event the SDK sent, so that
+// it is mapped the same way as captured events. The properties the person had
+// at the time of the event are set on the person, with the $set of the event
+// taking precedence.
+func (e ExportEvent) ToCaptureEvent() (CaptureEvent, error) {
+	event := CaptureEvent{Event: e.Event, DistinctId: e.DistinctId, Uuid: e.Uuid}
+	var err error
+	if event.Properties, err = decodeObject(e.Properties); err != nil {
+		return event, fmt.Errorf("properties: %w", err)
+	}
+	if event.Set, err = decodeObject(e.Set); err != nil {
+		return event, fmt.Errorf("set: %w", err)
+	}
+	if event.SetOnce, err = decodeObject(e.SetOnce); err != nil {
+		return event, fmt.Errorf("set_once: %w", err)
+	}
+	personProperties, err := decodeObject(e.PersonProperties)
+	if err != nil {
+		return event, fmt.Errorf("person_properties: %w", err)
+	}
+	if len(personProperties) > 0 {
+		set, _ := event.Properties["$set"].(map[string]any)
+		event.Set = mergeProperties(personProperties, mergeProperties(set, event.Set))
+		delete(event.Properties, "$set")
+	}
+	if event.Timestamp, err = normalizeExportTimestamp(e.Timestamp); err != nil {
+		return event, err
+	}
+	return event, nil
+}
+
+func decodeObject(raw json.RawMessage) (map[string]any, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var encoded string
+	if err := json.Unmarshal(raw, &encoded); err == nil {
+		if encoded == "" {
+			return nil, nil
+		}
+		raw = json.RawMessage(encoded)
+	}
+	var object map[string]any
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, errors.New("not a json object")
+	}
+	return object, nil
+}
+
+func normalizeExportTimestamp(value string) (string, error) {
+	if value == "" {
+		return "", errors.New("timestamp is required")
+	}
+	if _, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return value, nil
+	}
+	for _, layout := range exportTimestampLayouts {
+		if timestamp, err := time.Parse(layout, value); err == nil {
+			return timestamp.UTC().Format(time.RFC3339Nano), nil
+		}
+	}
+	return "", fmt.Errorf("timestamp %q is not in ISO 8601 format", value)
+}
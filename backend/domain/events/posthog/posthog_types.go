@@ -0,0 +1,64 @@
+package posthog
+
+import "encoding/json"
+
+// CaptureEvent is a single event as sent by the PostHog SDKs.
+type CaptureEvent struct {
+	Event      string          `json:"event"`
+	DistinctId json.RawMessage `json:"distinct_id"`
+	Uuid       string          `json:"uuid"`
+	Timestamp  string          `json:"timestamp"`
+	Offset     *int64          `json:"offset"`
+	Properties map[string]any  `json:"properties"`
+	Set        map[string]any  `json:"$set"`
+	SetOnce    map[string]any  `json:"$set_once"`
+	ApiKey     string          `json:"api_key"`
+	Token      string          `json:"token"`
+}
+
+// CapturePayload covers the shapes accepted by the capture endpoints: a single
+// event, an array of events or an object with a batch of events.
+type CapturePayload struct {
+	ApiKey string
+	SentAt string
+	Events []CaptureEvent
+}
+
+type batchEnvelope struct {
+	ApiKey string         `json:"api_key"`
+	SentAt string         `json:"sent_at"`
+	Batch  []CaptureEvent `json:"batch"`
+}
+
+// DecideResponse is returned by the decide endpoint. Feature flags, session
+// recordings and site apps are not supported, so everything is disabled.
+type DecideResponse struct {
+	Config                    decideConfig   `json:"config"`
+	FeatureFlags              map[string]any `json:"featureFlags"`
+	FeatureFlagPayloads       map[string]any `json:"featureFlagPayloads"`
+	ErrorsWhileComputingFlags bool           `json:"errorsWhileComputingFlags"`
+	SessionRecording          bool           `json:"sessionRecording"`
+	SupportedCompression      []string       `json:"supportedCompression"`
+	SiteApps                  []any          `json:"siteApps"`
+	Toolbar                   map[string]any `json:"toolbarParams"`
+	IsAuthenticated           bool           `json:"isAuthenticated"`
+	Heatmaps                  bool           `json:"heatmaps"`
+	Surveys                   bool           `json:"surveys"`
+	AutocaptureOptOut         bool           `json:"autocapture_opt_out"`
+	CapturePerformance        bool           `json:"capturePerformance"`
+}
+
+type decideConfig struct {
+	EnableCollectEverything bool `json:"enable_collect_everything"`
+}
+
+func NewDecideResponse() DecideResponse {
+	return DecideResponse{
+		Config:               decideConfig{EnableCollectEverything: true},
+		FeatureFlags:         map[string]any{},
+		FeatureFlagPayloads:  map[string]any{},
+		SupportedCompression: []string{"gzip", "gzip-js"},
+		SiteApps:             []any{},
+		Toolbar:              map[string]any{},
+	}
+}
@@ -0,0 +1,280 @@
+package posthog
+
+import (
+	"analytics/domain/events"
+	"analytics/domain/groups"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ParseCapturePayload decodes the body of a capture request. Besides plain
+// json, older SDKs send a form encoded body with a base64 encoded data field.
+func ParseCapturePayload(body []byte) (*CapturePayload, error) {
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("data=")) {
+		decoded, err := decodeFormData(body)
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+
+	payload := &CapturePayload{}
+	switch {
+	case len(body) > 0 && body[0] == '[':
+		if err := json.Unmarshal(body, &payload.Events); err != nil {
+			return nil, err
+		}
+	case len(body) > 0 && body[0] == '{':
+		var envelope batchEnvelope
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return nil, err
+		}
+		if envelope.Batch != nil {
+			payload.ApiKey = envelope.ApiKey
+			payload.SentAt = envelope.SentAt
+			payload.Events = envelope.Batch
+			break
+		}
+		var event CaptureEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		payload.Events = []CaptureEvent{event}
+	default:
+		return nil, errors.New("payload must be an event object, an array of events or a batch")
+	}
+
+	if payload.ApiKey == "" {
+		for _, event := range payload.Events {
+			if key := event.apiKey(); key != "" {
+				payload.ApiKey = key
+				break
+			}
+		}
+	}
+	return payload, nil
+}
+
+func decodeFormData(body []byte) ([]byte, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	data := strings.TrimSpace(values.Get("data"))
+	if strings.HasPrefix(data, "{") || strings.HasPrefix(data, "[") {
+		return []byte(data), nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("data is neither json nor base64 encoded json: %w", err)
+	}
+	return decoded, nil
+}
+
+func (e CaptureEvent) apiKey() string {
+	if e.ApiKey != "" {
+		return e.ApiKey
+	}
+	if e.Token != "" {
+		return e.Token
+	}
+	token, _ := e.Properties["token"].(string)
+	return token
+}
+
+// ToEventInput maps a PostHog event onto an event of this project. The
+// distinct id becomes the person id of identified events and the session id of
+// anonymous ones. Identified events carry the anonymous id they were sent with
+// ($device_id, or $anon_distinct_id for $identify) as session id, which links
+// the anonymous history to the person.
+func ToEventInput(event CaptureEvent, receivedAt time.Time) (*events.EventInput, *events.ValidationError) {
+	if strings.TrimSpace(event.Event) == "" {
+		return nil, &events.ValidationError{Reason: events.MissingEventType, Message: "event is required"}
+	}
+	distinctId, err := parseDistinctId(event.DistinctId)
+	if err != nil {
+		return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: err.Error()}
+	}
+	if distinctId == "" {
+		distinctId, _ = event.Properties["distinct_id"].(string)
+	}
+	if distinctId == "" {
+		return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: "distinct_id is required"}
+	}
+
+	properties := make(map[string]any, len(event.Properties))
+	for key, value := range event.Properties {
+		properties[key] = value
+	}
+	set := mergeProperties(properties["$set"], event.Set)
+	setOnce := mergeProperties(properties["$set_once"], event.SetOnce)
+	delete(properties, "$set")
+	delete(properties, "$set_once")
+	unset := unsetKeys(properties["$unset"])
+	delete(properties, "$unset")
+	groups, groupProperties := groupsOf(event.Event, properties)
+	delete(properties, "token")
+	delete(properties, "distinct_id")
+
+	input := &events.EventInput{
+		EventType:             event.Event,
+		Properties:            properties,
+		PersonProperties:      set,
+		PersonPropertiesOnce:  setOnce,
+		PersonPropertiesUnset: unset,
+		Groups:                groups,
+		GroupProperties:       groupProperties,
+	}
+
+	if isAnonymous(event.Event, properties) {
+		input.SessionId = &distinctId
+	} else {
+		input.PersonId = &distinctId
+		if anonymousId := anonymousIdOf(event.Event, properties); anonymousId != "" && anonymousId != distinctId {
+			input.SessionId = &anonymousId
+		}
+	}
+
+	timestamp, err := parseTimestamp(event, receivedAt)
+	if err != nil {
+		return nil, &events.ValidationError{Reason: events.InvalidTimestamp, Message: err.Error()}
+	}
+	input.Timestamp = timestamp
+
+	id := uuid.New()
+	if event.Uuid != "" {
+		id, err = uuid.Parse(event.Uuid)
+		if err != nil || id == uuid.Nil {
+			return nil, &events.ValidationError{Reason: events.InvalidUuid, Message: fmt.Sprintf("uuid %q is not valid", event.Uuid)}
+		}
+	}
+	input.Uuid = &id
+
+	return input, nil
+}
+
+func parseDistinctId(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return strings.TrimSpace(value), nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err == nil {
+		return number.String(), nil
+	}
+	return "", errors.New("distinct_id must be a string or a number")
+}
+
+func isAnonymous(eventType string, properties map[string]any) bool {
+	if eventType == "$identify" {
+		return false
+	}
+	if identified, ok := properties["$is_identified"].(bool); ok {
+		return !identified
+	}
+	state, _ := properties["$user_state"].(string)
+	return state == "anonymous"
+}
+
+func anonymousIdOf(eventType string, properties map[string]any) string {
+	if eventType == "$identify" {
+		if anonId, ok := properties["$anon_distinct_id"].(string); ok && anonId != "" {
+			return anonId
+		}
+	}
+	deviceId, _ := properties["$device_id"].(string)
+	return deviceId
+}
+
+func mergeProperties(fromProperties any, topLevel map[string]any) map[string]any {
+	nested, _ := fromProperties.(map[string]any)
+	if len(nested) == 0 && len(topLevel) == 0 {
+		return nil
+	}
+	merged := make(map[string]any, len(nested)+len(topLevel))
+	for key, value := range nested {
+		merged[key] = value
+	}
+	for key, value := range topLevel {
+		merged[key] = value
+	}
+	return merged
+}
+
+// unsetKeys reads the keys of $unset, which the SDKs send as a list of keys.
+func unsetKeys(value any) []string {
+	list, _ := value.([]any)
+	keys := make([]string, 0, len(list))
+	for _, item := range list {
+		if key, ok := item.(string); ok && key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return keys
+}
+
+// groupsOf reads the groups of an event from $groups, and the group and its
+// properties from $groupidentify events. Groups with a type that is not valid
+// are skipped.
+func groupsOf(eventType string, properties map[string]any) (map[string]string, map[string]map[string]any) {
+	eventGroups := make(map[string]string)
+	if values, ok := properties["$groups"].(map[string]any); ok {
+		for groupType, value := range values {
+			if key, ok := value.(string); ok && key != "" && groups.ValidTypeName(groupType) {
+				eventGroups[groupType] = key
+			}
+		}
+		delete(properties, "$groups")
+	}
+
+	var groupProperties map[string]map[string]any
+	if eventType == "$groupidentify" {
+		groupType, _ := properties["$group_type"].(string)
+		key, _ := properties["$group_key"].(string)
+		if key != "" && groups.ValidTypeName(groupType) {
+			eventGroups[groupType] = key
+			if set, ok := properties["$group_set"].(map[string]any); ok && len(set) > 0 {
+				groupProperties = map[string]map[string]any{groupType: set}
+			}
+			delete(properties, "$group_type")
+			delete(properties, "$group_key")
+			delete(properties, "$group_set")
+		}
+	}
+
+	if len(eventGroups) == 0 {
+		return nil, nil
+	}
+	return eventGroups, groupProperties
+}
+
+// parseTimestamp prefers the event timestamp and falls back to the offset in
+// milliseconds that some SDKs send instead. Without either, the time of
+// processing is used.
+func parseTimestamp(event CaptureEvent, receivedAt time.Time) (time.Time, error) {
+	if event.Timestamp != "" {
+		timestamp, err := time.Parse(time.RFC3339Nano, event.Timestamp)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("timestamp %q is not in ISO 8601 format", event.Timestamp)
+		}
+		return timestamp, nil
+	}
+	if event.Offset != nil {
+		return receivedAt.Add(-time.Duration(*event.Offset) * time.Millisecond), nil
+	}
+	return time.Time{}, nil
+}
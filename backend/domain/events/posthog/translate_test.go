@@ -0,0 +1,103 @@
+package posthog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestParseCapturePayloadShapes(t *testing.T) {
+	batch, err := ParseCapturePayload([]byte(`{"api_key":"key","batch":[{"event":"a","distinct_id":"u1"},{"event":"b","distinct_id":2}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "key", batch.ApiKey)
+	assert.Equal(t, 2, len(batch.Events))
+
+	single, err := ParseCapturePayload([]byte(`{"event":"a","distinct_id":"u1","properties":{"token":"key"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "key", single.ApiKey)
+	assert.Equal(t, 1, len(single.Events))
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(`[{"event":"a","distinct_id":"u1","token":"key"}]`))
+	form, err := ParseCapturePayload([]byte("data=" + encoded))
+	assert.NoError(t, err)
+	assert.Equal(t, "key", form.ApiKey)
+	assert.Equal(t, 1, len(form.Events))
+}
+
+func TestToEventInputMapsIdentity(t *testing.T) {
+	payload, err := ParseCapturePayload([]byte(`[
+		{"event":"$pageview","distinct_id":"anon","properties":{"$is_identified":false,"$set":{"a":1}}},
+		{"event":"$identify","distinct_id":"user","properties":{"$anon_distinct_id":"anon"},"$set_once":{"b":2}},
+		{"event":"purchase","distinct_id":"user","offset":1000,"properties":{"$device_id":"anon","token":"key","$unset":["plan"]}}
+	]`))
+	assert.NoError(t, err)
+	receivedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	anonymous, validationErr := ToEventInput(payload.Events[0], receivedAt)
+	assert.Nil(t, validationErr)
+	assert.Nil(t, anonymous.PersonId)
+	assert.Equal(t, "anon", *anonymous.SessionId)
+	assert.Equal(t, 1.0, anonymous.PersonProperties["a"])
+	_, hasSet := anonymous.Properties["$set"]
+	assert.False(t, hasSet)
+
+	identify, validationErr := ToEventInput(payload.Events[1], receivedAt)
+	assert.Nil(t, validationErr)
+	assert.Equal(t, "user", *identify.PersonId)
+	assert.Equal(t, "anon", *identify.SessionId)
+	assert.Equal(t, 2.0, identify.PersonPropertiesOnce["b"])
+
+	purchase, validationErr := ToEventInput(payload.Events[2], receivedAt)
+	assert.Nil(t, validationErr)
+	assert.Equal(t, "user", *purchase.PersonId)
+	assert.Equal(t, "anon", *purchase.SessionId)
+	assert.Equal(t, receivedAt.Add(-time.Second), purchase.Timestamp)
+	_, hasToken := purchase.Properties["token"]
+	assert.False(t, hasToken)
+	assert.DeepEqual(t, []string{"plan"}, purchase.PersonPropertiesUnset)
+}
+
+func TestExportEventToCaptureEvent(t *testing.T) {
+	var exported ExportEvent
+	err := json.Unmarshal([]byte(`{"uuid":"0188a1c2-7d5e-7b3a-9c1f-3e2d4c5b6a79","event":"$pageview","distinct_id":"user",
+		"timestamp":"2023-01-02 10:00:00.123000","properties":"{\"$current_url\":\"https://example.com\",\"$set\":{\"plan\":\"pro\"}}",
+		"person_properties":{"plan":"free","email":"test@example.com"}}`), &exported)
+	assert.NoError(t, err)
+
+	event, err := exported.ToCaptureEvent()
+	assert.NoError(t, err)
+	input, validationErr := ToEventInput(event, time.Time{})
+	assert.Nil(t, validationErr)
+	assert.Equal(t, "user", *input.PersonId)
+	assert.Equal(t, "0188a1c2-7d5e-7b3a-9c1f-3e2d4c5b6a79", input.Uuid.String())
+	assert.Equal(t, time.Date(2023, 1, 2, 10, 0, 0, 123000000, time.UTC), input.Timestamp)
+	assert.Equal(t, map[string]any{"$current_url": "https://example.com"}, input.Properties)
+	assert.Equal(t, map[string]any{"plan": "pro", "email": "test@example.com"}, input.PersonProperties)
+
+	exported.Timestamp = ""
+	_, err = exported.ToCaptureEvent()
+	assert.Error(t, err)
+}
+
+func TestToEventInputMapsGroups(t *testing.T) {
+	payload, err := ParseCapturePayload([]byte(`[
+		{"event":"invite","distinct_id":"user","properties":{"$groups":{"company":"acme","bad type":"x"}}},
+		{"event":"$groupidentify","distinct_id":"user","properties":{"$group_type":"company","$group_key":"acme","$group_set":{"plan":"pro"}}}
+	]`))
+	assert.NoError(t, err)
+	receivedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	invite, validationErr := ToEventInput(payload.Events[0], receivedAt)
+	assert.Nil(t, validationErr)
+	assert.DeepEqual(t, map[string]string{"company": "acme"}, invite.Groups)
+	_, hasGroups := invite.Properties["$groups"]
+	assert.False(t, hasGroups)
+
+	identify, validationErr := ToEventInput(payload.Events[1], receivedAt)
+	assert.Nil(t, validationErr)
+	assert.DeepEqual(t, map[string]string{"company": "acme"}, identify.Groups)
+	assert.Equal(t, "pro", identify.GroupProperties["company"]["plan"])
+}
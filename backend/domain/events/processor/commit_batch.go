@@ -0,0 +1,199 @@
+package processor
+
+import (
+	"analytics/database/analyticsdb"
+	"analytics/domain/deadletters"
+	"analytics/domain/events"
+	"analytics/domain/schema"
+	"analytics/log"
+	"database/sql/driver"
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"time"
+)
+
+// storeEvents commits a batch that passed the ingestion pipeline. Its persons,
+// sessions, groups and events are written in one DuckDB transaction. The schema update
+// is recorded under the id of the batch before and applied after that
+// transaction, so it is applied exactly for the batches that were committed.
+// If the batch fails, its events are moved to the dead letters, so an error is
+// only returned if that fails too and the batch has to be kept in the
+// write-ahead log.
+func (p *ProjectProcessor) storeEvents(input []*events.EventInput) ([]*events.Event, error) {
+	p.recoverSchemaUpdates()
+
+	slices.SortFunc(input, func(i, j *events.EventInput) int {
+		if i.Timestamp.Equal(j.Timestamp) {
+			return 0
+		}
+		isBefore := i.Timestamp.Before(j.Timestamp)
+		if isBefore {
+			return -1
+		}
+		return 1
+	})
+
+	newEvents := make([]*events.Event, 0, len(input))
+	for _, event := range input {
+		id := uuid.New()
+		if event.Uuid != nil {
+			id = *event.Uuid
+		}
+		newEvents = append(newEvents, &events.Event{
+			EventId: events.EventId{
+				Id: id,
+			},
+			EventInput: *event,
+		})
+	}
+
+	encoded, rows, failed := p.encodeEvents(newEvents)
+	if err := p.saveDeadLetters(failed); err != nil {
+		return nil, err
+	}
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+
+	identities, err := p.resolveIdentities(encoded)
+	if err != nil {
+		log.Error("Project %s: Error processing identities: %v", p.projectID, err)
+		return nil, p.deadLetterBatch(encoded, deadletters.StageIdentities, err)
+	}
+	groupChanges, err := p.resolveGroups(encoded)
+	if err != nil {
+		log.Error("Project %s: Error processing groups: %v", p.projectID, err)
+		return nil, p.deadLetterBatch(encoded, deadletters.StageIdentities, err)
+	}
+
+	batchId := batchIdOf(encoded)
+	schemasByType := make(map[string]*schema.EventSchema)
+	encodedInput := make([]*events.EventInput, len(encoded))
+	for i, event := range encoded {
+		encodedInput[i] = &event.EventInput
+	}
+	mergeEventsIntoSchemas(encodedInput, schemasByType)
+	if err := schema.SavePendingUpdate(p.db, batchId, schema.UpdateOf(schemasByType)); err != nil {
+		log.Error("Project %s: Error recording schema update: %v", p.projectID, err)
+		return nil, p.deadLetterBatch(encoded, deadletters.StagePersist, err)
+	}
+
+	if stage, err := p.commitBatch(batchId, identities, groupChanges, rows); err != nil {
+		log.Error("Project %s: Error committing batch: %v", p.projectID, err)
+		if err := schema.DiscardPendingUpdate(p.db, batchId); err != nil {
+			log.Error("Project %s: Error discarding schema update of batch %s: %v", p.projectID, batchId, err)
+			p.schemaRecovery = true
+		}
+		return nil, p.deadLetterBatch(encoded, stage, err)
+	}
+
+	p.applySchemaUpdate(batchId)
+	p.invalidateSegments(encoded)
+	p.invalidateMergedPersons(identities.merges)
+	p.registerGroupTypes(groupChanges.types)
+	return encoded, nil
+}
+
+// commitBatch writes the persons, sessions, groups and events of a batch
+// together with its id. The stage tells which part failed.
+func (p *ProjectProcessor) commitBatch(batchId string, identities *identityChanges, groups *groupChanges, rows [][]driver.Value) (deadletters.Stage, error) {
+	tx, err := analyticsdb.BeginBatch(p.dbd)
+	if err != nil {
+		return deadletters.StagePersist, err
+	}
+	defer tx.Rollback()
+
+	if err := identities.persist(tx); err != nil {
+		return deadletters.StageIdentities, err
+	}
+	if err := groups.persist(tx); err != nil {
+		return deadletters.StageIdentities, err
+	}
+	if err := tx.Append("events", rows); err != nil {
+		return deadletters.StageAppend, err
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO batches (id, committed_at) VALUES ($1, $2)", batchId, time.Now().UTC())
+	if err != nil {
+		return deadletters.StagePersist, err
+	}
+	return deadletters.StagePersist, tx.Commit()
+}
+
+// applySchemaUpdate applies the schema update of a committed batch, after
+// which the batch id is no longer needed. A failed update is retried before
+// the next batch.
+func (p *ProjectProcessor) applySchemaUpdate(batchId string) bool {
+	if err := schema.ApplyPendingUpdate(p.db, batchId); err != nil {
+		log.Error("Project %s: Error applying schema update of batch %s: %v", p.projectID, batchId, err)
+		p.schemaRecovery = true
+		return false
+	}
+	tx, err := p.dbd.Tx()
+	if err == nil {
+		if _, err = tx.Exec("DELETE FROM batches WHERE id = $1", batchId); err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	if err != nil {
+		log.Warn("Project %s: Error removing batch %s: %v", p.projectID, batchId, err)
+	}
+	return true
+}
+
+// recoverSchemaUpdates settles the schema updates left pending by a crash or a
+// failed update. Updates of committed batches are applied, the others are
+// discarded.
+func (p *ProjectProcessor) recoverSchemaUpdates() {
+	if !p.schemaRecovery {
+		return
+	}
+	pending, err := schema.ListPendingUpdates(p.db)
+	if err != nil {
+		log.Error("Project %s: Error reading pending schema updates: %v", p.projectID, err)
+		return
+	}
+	for _, update := range pending {
+		committed, err := p.batchCommitted(update.BatchId)
+		if err != nil {
+			log.Error("Project %s: Error reading batch %s: %v", p.projectID, update.BatchId, err)
+			return
+		}
+		if !committed {
+			if err := schema.DiscardPendingUpdate(p.db, update.BatchId); err != nil {
+				log.Error("Project %s: Error discarding schema update of batch %s: %v", p.projectID, update.BatchId, err)
+				return
+			}
+			continue
+		}
+		if !p.applySchemaUpdate(update.BatchId) {
+			return
+		}
+		log.Info("Project %s: Applied schema update of batch %s", p.projectID, update.BatchId)
+	}
+	p.schemaRecovery = false
+}
+
+func (p *ProjectProcessor) batchCommitted(batchId string) (bool, error) {
+	tx, err := p.dbd.Tx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Commit()
+	var count int
+	err = tx.QueryRow("SELECT count(*) FROM batches WHERE id = $1", batchId).Scan(&count)
+	return count > 0, err
+}
+
+// batchIdOf derives the id of a batch from the ids of its events, so that a
+// retried batch gets the same id.
+func batchIdOf(batch []*events.Event) string {
+	ids := make([]string, len(batch))
+	for i, event := range batch {
+		ids[i] = event.Id.String()
+	}
+	slices.Sort(ids)
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(strings.Join(ids, ","))).String()
+}
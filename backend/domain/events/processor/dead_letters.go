@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"analytics/domain/deadletters"
+	"analytics/domain/events"
+	"analytics/log"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+func (p *ProjectProcessor) saveDeadLetters(letters []deadletters.DeadLetter) error {
+	if len(letters) == 0 {
+		return nil
+	}
+	if err := deadletters.Save(p.db, letters); err != nil {
+		return err
+	}
+	log.Warn("Project %s: Moved %d events to the dead letters", p.projectID, len(letters))
+	return nil
+}
+
+// deadLetterBatch moves the events of a failed batch to the dead letters. It
+// returns the cause if they could not be saved.
+func (p *ProjectProcessor) deadLetterBatch(failed []*events.Event, stage deadletters.Stage, cause error) error {
+	if err := p.saveDeadLetters(deadletters.FromEvents(failed, stage, cause)); err != nil {
+		return fmt.Errorf("%w, saving dead letters failed: %v", cause, err)
+	}
+	return nil
+}
+
+// RetryDeadLetters stores the events of dead letters again. The ingestion
+// pipeline already ran for most of them, so they are only deduplicated and
+// stored. Events of failed queue batches never passed it and run the whole
+// pipeline, including the tracking plan.
+func RetryDeadLetters(projectID string, letters []deadletters.DeadLetter) (deadletters.RetryResult, error) {
+	return GetOrCreateProcessor(projectID).retryDeadLetters(letters)
+}
+
+func (p *ProjectProcessor) retryDeadLetters(letters []deadletters.DeadLetter) (deadletters.RetryResult, error) {
+	p.processing.Lock()
+	defer p.processing.Unlock()
+
+	var result deadletters.RetryResult
+	input := make([]*events.EventInput, 0, len(letters))
+	unprocessed := make(map[uuid.UUID]bool)
+	ids := make([]uint, 0, len(letters))
+	for _, letter := range letters {
+		event, err := letter.Event()
+		if err != nil {
+			log.Warn("Project %s: %v", p.projectID, err)
+			result.Failed++
+			continue
+		}
+		if letter.Stage == deadletters.StageQueue && event.Uuid != nil {
+			unprocessed[*event.Uuid] = true
+		}
+		input = append(input, event)
+		ids = append(ids, letter.ID)
+	}
+
+	unique, err := p.dropDuplicates(input)
+	if err != nil {
+		return result, err
+	}
+	result.Duplicates = len(input) - len(unique)
+	var processed, stored []*events.EventInput
+	for _, event := range unique {
+		if unprocessed[*event.Uuid] {
+			processed = append(processed, event)
+		} else {
+			stored = append(stored, event)
+		}
+	}
+	if len(processed) > 0 {
+		persisted, err := p.runPipeline(processed, true)
+		if err != nil {
+			return result, err
+		}
+		p.recentIds.remember(persisted, time.Now())
+		result.Stored += len(persisted)
+		result.Dropped = len(processed) - len(persisted)
+	}
+	if len(stored) > 0 {
+		persisted, err := p.storeEvents(stored)
+		if err != nil {
+			return result, err
+		}
+		p.recentIds.remember(persisted, time.Now())
+		result.Stored += len(persisted)
+		result.Failed += len(stored) - len(persisted)
+	}
+
+	removed, err := deadletters.Delete(p.db, ids)
+	result.Retried = int(removed)
+	return result, err
+}
+
+// deadLetterQueued moves the events of a queue batch that kept failing to the
+// dead letters. Events without an id get one, so a retry stores them once.
+func (p *ProjectProcessor) deadLetterQueued(input []*events.EventInput, cause error) error {
+	failed := make([]*events.Event, len(input))
+	for i, event := range input {
+		if event.Uuid == nil {
+			id := uuid.New()
+			event.Uuid = &id
+		}
+		failed[i] = &events.Event{EventId: events.EventId{Id: *event.Uuid}, EventInput: *event}
+	}
+	return p.deadLetterBatch(failed, deadletters.StageQueue, cause)
+}
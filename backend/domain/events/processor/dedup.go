@@ -0,0 +1,126 @@
+package processor
+
+import (
+	"analytics/domain/events"
+	"analytics/log"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// recentIds remembers the ids of events processed within the dedup window, so
+// retries that arrive shortly after the original are dropped without a lookup.
+type recentIds struct {
+	window time.Duration
+	seenAt map[uuid.UUID]time.Time
+}
+
+func newRecentIds(window time.Duration) *recentIds {
+	return &recentIds{
+		window: window,
+		seenAt: make(map[uuid.UUID]time.Time),
+	}
+}
+
+func (r *recentIds) contains(id uuid.UUID) bool {
+	_, exists := r.seenAt[id]
+	return exists
+}
+
+// remember is called once events are persisted. Ids of events that failed to
+// persist are not remembered, so that their retries are not dropped.
+func (r *recentIds) remember(persisted []*events.Event, now time.Time) {
+	if r.window <= 0 {
+		return
+	}
+	for _, event := range persisted {
+		r.seenAt[event.Id] = now
+	}
+}
+
+func (r *recentIds) expire(now time.Time) {
+	for id, seenAt := range r.seenAt {
+		if now.Sub(seenAt) > r.window {
+			delete(r.seenAt, id)
+		}
+	}
+}
+
+// dropDuplicates removes events whose uuid was already processed. Duplicates
+// are detected within the batch, in the recent ids and finally through the
+// primary key of the events table, which also guards the appender against
+// conflicting rows.
+func (p *ProjectProcessor) dropDuplicates(input []*events.EventInput) ([]*events.EventInput, error) {
+	p.recentIds.expire(time.Now())
+
+	candidates := make([]*events.EventInput, 0, len(input))
+	inBatch := make(map[uuid.UUID]bool, len(input))
+	for _, event := range input {
+		if event.Uuid == nil {
+			candidates = append(candidates, event)
+			continue
+		}
+		if inBatch[*event.Uuid] || p.recentIds.contains(*event.Uuid) {
+			continue
+		}
+		inBatch[*event.Uuid] = true
+		candidates = append(candidates, event)
+	}
+
+	stored, err := p.fetchExistingEventIds(inBatch)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*events.EventInput, 0, len(candidates))
+	for _, event := range candidates {
+		if event.Uuid != nil && stored[*event.Uuid] {
+			continue
+		}
+		result = append(result, event)
+	}
+
+	if dropped := len(input) - len(result); dropped > 0 {
+		log.Info("Project %s: Dropped %d duplicate events", p.projectID, dropped)
+	}
+	return result, nil
+}
+
+func (p *ProjectProcessor) fetchExistingEventIds(ids map[uuid.UUID]bool) (map[uuid.UUID]bool, error) {
+	existing := make(map[uuid.UUID]bool)
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	placeholders := make([]string, 0, len(ids))
+	params := make([]interface{}, 0, len(ids))
+	for id := range ids {
+		params = append(params, id.String())
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(params)))
+	}
+
+	tx, err := p.dbd.Tx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	rows, err := tx.Query(
+		fmt.Sprintf("SELECT id FROM events WHERE id IN (%s)", strings.Join(placeholders, ", ")),
+		params...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		existing[id] = true
+	}
+	return existing, rows.Err()
+}
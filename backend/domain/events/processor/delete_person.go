@@ -0,0 +1,37 @@
+package processor
+
+import (
+	"analytics/domain/events/parquet"
+	"analytics/domain/filecatalog"
+	"analytics/domain/person"
+	"analytics/log"
+	"time"
+)
+
+// DeletePerson deletes a person of the project with its events and sessions,
+// between batches so that no batch links new events to it meanwhile. The
+// exported files that contained its events are regenerated.
+func DeletePerson(projectID string, personId string) (person.Deletion, error) {
+	return GetOrCreateProcessor(projectID).deletePerson(personId)
+}
+
+func (p *ProjectProcessor) deletePerson(personId string) (person.Deletion, error) {
+	p.processing.Lock()
+	defer p.processing.Unlock()
+
+	deletion, err := person.Delete(p.dbd, personId)
+	if err != nil {
+		return deletion, err
+	}
+	log.Info("Project %s: Deleted person %s with %d events and %d sessions", p.projectID, personId, deletion.Events, deletion.Sessions)
+
+	invalidated, err := filecatalog.InvalidateSegments(p.db, deletion.Timestamps, time.Now())
+	if err != nil {
+		log.Error("Project %s: Error invalidating parquet files: %v", p.projectID, err)
+		return deletion, nil
+	}
+	if invalidated > 0 {
+		parquet.ScheduleRegeneration(p.projectID, p.db)
+	}
+	return deletion, nil
+}
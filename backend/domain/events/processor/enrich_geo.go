@@ -0,0 +1,43 @@
+package processor
+
+import (
+	"analytics/domain/events"
+	"analytics/domain/geoip"
+)
+
+// ipProperty is set by the PostHog and Segment integrations. It takes
+// precedence over the address the request was sent from, so that server side
+// libraries can report the address of their users.
+const ipProperty = "$ip"
+
+// enrichWithGeoIP adds the location of the client to the events' properties.
+// The ip address is discarded afterwards, whether or not a GeoIP database is
+// configured, so it is never persisted.
+func enrichWithGeoIP(input []*events.EventInput) {
+	for _, event := range input {
+		ip := event.Ip
+		if value, ok := event.Properties[ipProperty].(string); ok && value != "" {
+			ip = value
+		}
+		delete(event.Properties, ipProperty)
+		event.Ip = ""
+
+		if ip == "" {
+			continue
+		}
+		location, found := geoip.Lookup(ip)
+		if !found {
+			continue
+		}
+		setIfPresent(event.Properties, "$geo_country", location.Country)
+		setIfPresent(event.Properties, "$geo_city", location.City)
+		setIfPresent(event.Properties, "$geo_region", location.Region)
+		setIfPresent(event.Properties, "$geo_timezone", location.Timezone)
+	}
+}
+
+func setIfPresent(properties map[string]any, key string, value string) {
+	if value != "" {
+		properties[key] = value
+	}
+}
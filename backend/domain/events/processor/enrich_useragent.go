@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"analytics/domain/events"
+	"analytics/domain/projects"
+	"analytics/domain/useragent"
+	"analytics/log"
+	"time"
+)
+
+// userAgentProperties are sent by the PostHog and Segment integrations. They
+// take precedence over the User-Agent header, which is the one of the server
+// for events sent by server side libraries.
+var userAgentProperties = []string{"$user_agent", "$raw_user_agent"}
+
+// enrichWithUserAgent adds the browser, operating system and device type to
+// the events' properties and filters the events of bots according to the
+// project's bot filter setting. Properties that were sent with the event are
+// kept.
+func (p *ProjectProcessor) enrichWithUserAgent(input []*events.EventInput) []*events.EventInput {
+	mode := p.botFilterMode()
+	result := make([]*events.EventInput, 0, len(input))
+	flagged, dropped := 0, 0
+	for _, event := range input {
+		header := event.UserAgent
+		for _, key := range userAgentProperties {
+			if value, ok := event.Properties[key].(string); ok && value != "" {
+				header = value
+				break
+			}
+		}
+		event.UserAgent = ""
+		if header == "" {
+			result = append(result, event)
+			continue
+		}
+
+		info := useragent.Parse(header)
+		if info.Bot {
+			switch mode {
+			case useragent.BotFilterDrop:
+				dropped++
+				continue
+			case useragent.BotFilterFlag:
+				event.Properties["$is_bot"] = true
+				flagged++
+			}
+		}
+		if info.OS != "" {
+			setIfAbsent(event.Properties, "$browser", info.Browser)
+			setIfAbsent(event.Properties, "$browser_version", info.BrowserVersion)
+			setIfAbsent(event.Properties, "$os", info.OS)
+			setIfAbsent(event.Properties, "$device_type", info.DeviceType)
+		}
+		result = append(result, event)
+	}
+
+	if flagged > 0 || dropped > 0 {
+		log.Info("Project %s: Filtered events of bots, %d flagged, %d dropped", p.projectID, flagged, dropped)
+		if err := useragent.RecordFilteredEvents(p.db, time.Now(), flagged, dropped); err != nil {
+			log.Error("Project %s: Error recording filtered events: %v", p.projectID, err)
+		}
+	}
+	return result
+}
+
+func (p *ProjectProcessor) botFilterMode() useragent.BotFilterMode {
+	settings, err := projects.QuerySettings(p.projectID, p.db)
+	if err != nil {
+		log.Error("Project %s: Error reading bot filter setting: %v", p.projectID, err)
+		return useragent.BotFilterDrop
+	}
+	mode, ok := useragent.ParseBotFilterMode(settings[projects.BotFilter])
+	if !ok {
+		log.Warn("Project %s: Invalid bot filter setting %q", p.projectID, settings[projects.BotFilter])
+		return useragent.BotFilterDrop
+	}
+	return mode
+}
+
+func setIfAbsent(properties map[string]any, key string, value string) {
+	if value == "" {
+		return
+	}
+	if _, exists := properties[key]; !exists {
+		properties[key] = value
+	}
+}
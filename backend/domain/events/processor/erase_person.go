@@ -0,0 +1,186 @@
+package processor
+
+import (
+	"analytics/domain/deadletters"
+	"analytics/domain/erasure"
+	"analytics/domain/events"
+	"analytics/domain/events/parquet"
+	"analytics/domain/person"
+	"analytics/domain/schema"
+	"analytics/log"
+	"time"
+)
+
+// erasurePageSize is the number of events of a person read at once to
+// collect the schema values they contributed.
+const erasurePageSize = 1000
+
+// ErasePerson erases a person of the project on request of its subject. It
+// deletes the person like DeletePerson together with its dead letters,
+// removes the schema values only its events had and rewrites the exported files that contained its events
+// before returning. The erasure is recorded, also when it fails.
+func ErasePerson(projectID string, personId string) (erasure.Erasure, error) {
+	return GetOrCreateProcessor(projectID).erasePerson(personId)
+}
+
+func (p *ProjectProcessor) erasePerson(personId string) (erasure.Erasure, error) {
+	p.processing.Lock()
+	defer p.processing.Unlock()
+
+	record, err := erasure.Start(p.db, personId, time.Now())
+	if err != nil {
+		return record, err
+	}
+	err = p.erase(personId, &record)
+	if finishErr := erasure.Finish(p.db, &record, err, time.Now()); finishErr != nil {
+		log.Error("Project %s: Error recording erasure %d: %v", p.projectID, record.ID, finishErr)
+	}
+	if err != nil {
+		return record, err
+	}
+	log.Info("Project %s: Erased person with %d events, %d sessions, %d dead letters, %d queued events, %d schema values and %d files",
+		p.projectID, record.Events, record.Sessions, record.DeadLetters, record.QueuedEvents, record.SchemaValues, record.Files)
+	return record, nil
+}
+
+func (p *ProjectProcessor) erase(personId string, record *erasure.Erasure) error {
+	contributed, err := p.contributedValues(personId)
+	if err != nil {
+		return err
+	}
+
+	deletion, err := person.Delete(p.dbd, personId)
+	if err != nil {
+		return err
+	}
+	record.Events, record.Sessions = deletion.Events, deletion.Sessions
+
+	// Retrying dead letters would store the events of the person again, and
+	// so would processing its events that are still queued.
+	if record.DeadLetters, err = deadletters.DeleteOfIds(p.db, deletion.Ids); err != nil {
+		return err
+	}
+	if record.QueuedEvents, err = p.eraseQueued(deletion.Ids); err != nil {
+		return err
+	}
+
+	unused, err := p.unusedValues(contributed)
+	if err != nil {
+		return err
+	}
+	if record.SchemaValues, err = schema.ScrubValues(p.db, unused); err != nil {
+		return err
+	}
+
+	record.Files, err = parquet.RewriteSegments(p.projectID, p.dbd, p.db, deletion.Timestamps, time.Now())
+	return err
+}
+
+// eraseQueued removes the events sent with any of ids from the write-ahead log
+// and drops them when they leave the queue.
+func (p *ProjectProcessor) eraseQueued(ids []string) (int64, error) {
+	erased := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		erased[id] = true
+	}
+	removed, seq, err := p.wal.Erase(func(event *events.EventInput) bool {
+		return sentWithAny(event, erased)
+	})
+	if err != nil {
+		return removed, err
+	}
+	if p.erased == nil {
+		p.erased = make(map[string]uint64, len(ids))
+	}
+	for _, id := range ids {
+		p.erased[id] = seq
+	}
+	return removed, nil
+}
+
+// withoutErased returns the events of a queue batch that were not erased
+// after they were queued.
+func (p *ProjectProcessor) withoutErased(batch []queuedEvent) []*events.EventInput {
+	input := make([]*events.EventInput, 0, len(batch))
+	for _, item := range batch {
+		if !p.isErased(item) {
+			input = append(input, item.event)
+		}
+	}
+	return input
+}
+
+func (p *ProjectProcessor) isErased(item queuedEvent) bool {
+	for _, id := range []*string{item.event.PersonId, item.event.SessionId} {
+		if id == nil {
+			continue
+		}
+		if seq, ok := p.erased[*id]; ok && item.walSeq <= seq {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetErased drops the erased ids whose events were all taken from the
+// queue once the log is committed up to seq.
+func (p *ProjectProcessor) forgetErased(seq uint64) {
+	p.processing.Lock()
+	defer p.processing.Unlock()
+	for id, last := range p.erased {
+		if last <= seq {
+			delete(p.erased, id)
+		}
+	}
+}
+
+func sentWithAny(event *events.EventInput, ids map[string]bool) bool {
+	return (event.PersonId != nil && ids[*event.PersonId]) || (event.SessionId != nil && ids[*event.SessionId])
+}
+
+// contributedValues collects the schema values of the events of a person the
+// way a batch of them would add them.
+func (p *ProjectProcessor) contributedValues(personId string) (schema.SchemaUpdate, error) {
+	schemasByType := make(map[string]*schema.EventSchema)
+	for offset := 0; ; offset += erasurePageSize {
+		page, _, err := events.QueryPersonTimeline(p.dbd, personId, erasurePageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		input := make([]*events.EventInput, len(*page))
+		for i, event := range *page {
+			input[i] = &events.EventInput{EventType: event.EventType, Properties: event.Properties}
+		}
+		mergeEventsIntoSchemas(input, schemasByType)
+		if len(*page) < erasurePageSize {
+			return schema.UpdateOf(schemasByType), nil
+		}
+	}
+}
+
+// unusedValues drops the values the remaining events still have.
+func (p *ProjectProcessor) unusedValues(contributed schema.SchemaUpdate) (schema.SchemaUpdate, error) {
+	unused := make(schema.SchemaUpdate, len(contributed))
+	for eventType, properties := range contributed {
+		for _, property := range properties {
+			inUse, err := events.PropertyValuesInUse(p.dbd, eventType, property.Key, property.Values)
+			if err != nil {
+				return nil, err
+			}
+			used := make(map[string]bool, len(inUse))
+			for _, value := range inUse {
+				used[value] = true
+			}
+			var values []string
+			for _, value := range property.Values {
+				if !used[value] {
+					values = append(values, value)
+				}
+			}
+			if len(values) > 0 {
+				unused[eventType] = append(unused[eventType], schema.PropertyUpdate{Key: property.Key, Type: property.Type, Values: values})
+			}
+		}
+	}
+	return unused, nil
+}
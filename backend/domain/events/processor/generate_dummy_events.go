@@ -45,6 +45,8 @@ func GenerateRandomEvents(projectId string, numEvents int, eventType string) {
 			Properties: randomProperties,
 		}
 
-		ProcessEvent(projectId, eventInput)
+		if err := ProcessEvent(projectId, eventInput); err != nil {
+			log.Error("Error queueing dummy event: %v", err)
+		}
 	}
 }
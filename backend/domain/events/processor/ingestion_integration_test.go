@@ -2,15 +2,51 @@ package processor
 
 import (
 	"analytics/database/testsetup"
+	"analytics/domain/deadletters"
+	"analytics/domain/erasure"
 	"analytics/domain/events"
+	"analytics/domain/filecatalog"
+	"analytics/domain/groups"
+	"analytics/domain/person"
+	"analytics/domain/privacy"
+	"analytics/domain/projects"
 	"analytics/domain/queries"
+	"analytics/domain/sampling"
 	"analytics/domain/schema"
+	"analytics/domain/trackingplan"
+	"analytics/domain/transformations"
+	"analytics/domain/useragent"
+	"errors"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/zeebo/assert"
+	"gorm.io/gorm"
 )
 
+func migrateProjectTables(t *testing.T, db *gorm.DB) {
+	err := db.AutoMigrate(
+		&schema.EventSchema{},
+		&schema.EventSchemaProperty{},
+		&schema.EventSchemaPropertyValue{},
+		&schema.PendingSchemaUpdate{},
+		&projects.ProjectSetting{},
+		&useragent.FilteredEvents{},
+		&transformations.TransformationRule{},
+		&privacy.PropertyPolicy{},
+		&sampling.SamplingRule{},
+		&filecatalog.FileCatalogEntry{},
+		&deadletters.DeadLetter{},
+		&groups.GroupType{},
+		&erasure.Erasure{},
+		&trackingplan.TrackingPlan{},
+	)
+	assert.NoError(t, err)
+}
+
 func TestProcessBatchPersistsEventsQueryableByEventsEndpoint(t *testing.T) {
 	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
 		ProjectDB: true,
@@ -23,12 +59,7 @@ func TestProcessBatchPersistsEventsQueryableByEventsEndpoint(t *testing.T) {
 	personID := "person-1"
 	timestamp := time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC)
 	secondTimestamp := timestamp.Add(time.Minute)
-	err := setup.ProjectDB.AutoMigrate(
-		&schema.EventSchema{},
-		&schema.EventSchemaProperty{},
-		&schema.EventSchemaPropertyValue{},
-	)
-	assert.NoError(t, err)
+	migrateProjectTables(t, setup.ProjectDB)
 
 	processor := NewProjectProcessor(projectID, setup.ProjectDB, &setup.DuckDB)
 	processor.processBatch([]*events.EventInput{
@@ -85,3 +116,621 @@ func TestProcessBatchPersistsEventsQueryableByEventsEndpoint(t *testing.T) {
 	assert.Equal(t, secondTimestamp, secondEvent.Timestamp)
 	assert.Equal(t, "signup", secondEvent.Properties["button"])
 }
+
+func TestProcessBatchDropsDuplicateEventIds(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+
+	id := uuid.New()
+	timestamp := time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC)
+	newEvent := func() *events.EventInput {
+		return &events.EventInput{
+			Uuid:      &id,
+			EventType: "purchase",
+			Timestamp: timestamp,
+		}
+	}
+
+	processor := NewProjectProcessor("dedup-test", setup.ProjectDB, &setup.DuckDB)
+	assert.NoError(t, processor.processBatch([]*events.EventInput{newEvent(), newEvent()}))
+	assert.NoError(t, processor.processBatch([]*events.EventInput{newEvent()}))
+
+	result, err := events.QueryEvents(&setup.DuckDB, &queries.EmptyQueryParams)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*result))
+	assert.Equal(t, id, (*result)[0].Id)
+}
+
+func TestFailedBatchIsDeadLetteredAndRetried(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+
+	renameSessions := func(from string, to string) {
+		tx, err := setup.DuckDB.Tx()
+		assert.NoError(t, err)
+		_, err = tx.Exec("alter table " + from + " rename to " + to)
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Commit())
+	}
+
+	sessionID := "session-1"
+	processor := NewProjectProcessor("dead-letter-test", setup.ProjectDB, &setup.DuckDB)
+	renameSessions("sessions", "sessions_unavailable")
+	assert.NoError(t, processor.processBatch([]*events.EventInput{{
+		EventType:  "purchase",
+		SessionId:  &sessionID,
+		Timestamp:  time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC),
+		Properties: map[string]any{"total": 10},
+	}}))
+
+	letters, total, err := deadletters.List(setup.ProjectDB, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, deadletters.StageIdentities, letters[0].Stage)
+	assert.Equal(t, "purchase", letters[0].EventType)
+
+	renameSessions("sessions_unavailable", "sessions")
+	stored, err := deadletters.Find(setup.ProjectDB, []uint{letters[0].ID})
+	assert.NoError(t, err)
+	result, err := processor.retryDeadLetters(stored)
+	assert.NoError(t, err)
+	assert.Equal(t, deadletters.RetryResult{Retried: 1, Stored: 1}, result)
+
+	persisted, err := events.QueryEvents(&setup.DuckDB, &queries.EmptyQueryParams)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*persisted))
+	assert.Equal(t, letters[0].EventId, (*persisted)[0].Id.String())
+	assert.Equal(t, float64(10), (*persisted)[0].Properties["total"])
+
+	_, total, err = deadletters.List(setup.ProjectDB, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+}
+
+func TestFailedQueueBatchIsNeverCommittedPastInTheLog(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+	defer func(delay time.Duration) { batchRetryDelay = delay }(batchRetryDelay)
+	batchRetryDelay = time.Millisecond
+	renameEvents := func(from string, to string) {
+		tx, err := setup.DuckDB.Tx()
+		assert.NoError(t, err)
+		_, err = tx.Exec("alter table " + from + " rename to " + to)
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Commit())
+	}
+	wal, err := openWAL(t.TempDir())
+	assert.NoError(t, err)
+	processor := NewProjectProcessor("queue-failure-test", setup.ProjectDB, &setup.DuckDB)
+	processor.wal = wal
+	queue := func(eventType string) []queuedEvent {
+		id := uuid.New()
+		event := &events.EventInput{Uuid: &id, EventType: eventType, Timestamp: time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC)}
+		seq, err := wal.Append([]*events.EventInput{event})
+		assert.NoError(t, err)
+		return []queuedEvent{{event: event, walSeq: seq, recordEnd: true}}
+	}
+
+	renameEvents("events", "events_unavailable")
+	processor.processQueuedBatch(queue("dead_lettered"))
+	letters, _, err := deadletters.List(setup.ProjectDB, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, deadletters.StageQueue, letters[0].Stage)
+
+	assert.NoError(t, setup.ProjectDB.Migrator().RenameTable("dead_letters", "dead_letters_unavailable"))
+	processor.processQueuedBatch(queue("held"))
+	processor.processQueuedBatch(queue("also_held"))
+
+	records, err := wal.Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "held", records[0].Events[0].EventType)
+	assert.Equal(t, "also_held", records[1].Events[0].EventType)
+
+	// The held batches are tried again with the next batch, which releases
+	// the log.
+	assert.NoError(t, setup.ProjectDB.Migrator().RenameTable("dead_letters_unavailable", "dead_letters"))
+	renameEvents("events_unavailable", "events")
+	processor.processQueuedBatch(queue("stored"))
+
+	records, err = wal.Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(records))
+	persisted, err := events.QueryEvents(&setup.DuckDB, &queries.EmptyQueryParams)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(*persisted))
+}
+
+func TestRetriedQueueBatchIsProcessedOnce(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+	_, err := transformations.CreateRule(setup.ProjectDB, transformations.RuleInput{
+		Name: "tag plan", EventType: "purchase", Action: transformations.SetProperty, Property: "plan",
+		Value: transformations.RuleValue{Constant: "pro"},
+	})
+	assert.NoError(t, err)
+	_, err = sampling.SaveRule(setup.ProjectDB, sampling.SamplingRule{EventType: "purchase", Rate: 0.5})
+	assert.NoError(t, err)
+	_, err = privacy.CreatePolicy(setup.ProjectDB, privacy.PolicyInput{Pattern: "email", Action: privacy.Hash})
+	assert.NoError(t, err)
+	renameEvents := func(from string, to string) {
+		tx, err := setup.DuckDB.Tx()
+		assert.NoError(t, err)
+		_, err = tx.Exec("alter table " + from + " rename to " + to)
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Commit())
+	}
+	defer func(sleep func(time.Duration)) { sleepBeforeRetry = sleep }(sleepBeforeRetry)
+	retries := 0
+	sleepBeforeRetry = func(time.Duration) {
+		retries++
+		renameEvents("events_unavailable", "events")
+	}
+
+	wal, err := openWAL(t.TempDir())
+	assert.NoError(t, err)
+	processor := NewProjectProcessor("retry-test", setup.ProjectDB, &setup.DuckDB)
+	processor.wal = wal
+	event := &events.EventInput{
+		EventType: "purchase", Timestamp: time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC),
+		Properties: map[string]any{"email": "jon@example.com", sampling.WeightProperty: 2.0},
+	}
+	for event.Uuid == nil || !sampling.Keep(event, 0.5) {
+		id := uuid.New()
+		event.Uuid = &id
+	}
+	seq, err := wal.Append([]*events.EventInput{event})
+	assert.NoError(t, err)
+
+	renameEvents("events", "events_unavailable")
+	processor.processQueuedBatch([]queuedEvent{{event: event, walSeq: seq, recordEnd: true}})
+	assert.Equal(t, 1, retries)
+
+	persisted, err := events.QueryEvents(&setup.DuckDB, &queries.EmptyQueryParams)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*persisted))
+	secret, err := privacy.HashSecret("retry-test", setup.ProjectDB)
+	assert.NoError(t, err)
+	hashed := &events.EventInput{Properties: map[string]any{"email": "jon@example.com"}}
+	privacy.NewEnforcer([]privacy.PropertyPolicy{{PolicyInput: privacy.PolicyInput{Pattern: "email", Action: privacy.Hash}}}, secret).Apply(hashed)
+	properties := (*persisted)[0].Properties
+	assert.Equal(t, hashed.Properties["email"], properties["email"])
+	assert.Equal(t, "pro", properties["plan"])
+	assert.Equal(t, 4.0, properties[sampling.WeightProperty])
+}
+
+func TestFailedCommitLeavesNoPartialBatch(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+	exec := func(query string) {
+		tx, err := setup.DuckDB.Tx()
+		assert.NoError(t, err)
+		_, err = tx.Exec(query)
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Commit())
+	}
+	count := func(table string) int {
+		tx, err := setup.DuckDB.Tx()
+		assert.NoError(t, err)
+		defer tx.Commit()
+		var n int
+		assert.NoError(t, tx.QueryRow("select count(*) from "+table).Scan(&n))
+		return n
+	}
+
+	personID := "person-1"
+	processor := NewProjectProcessor("commit-test", setup.ProjectDB, &setup.DuckDB)
+	exec("alter table batches rename to batches_unavailable")
+	assert.NoError(t, processor.processBatch([]*events.EventInput{{
+		EventType:        "signup",
+		PersonId:         &personID,
+		Timestamp:        time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC),
+		Properties:       map[string]any{"plan": "pro"},
+		PersonProperties: map[string]any{"plan": "pro"},
+	}}))
+
+	assert.Equal(t, 0, count("events"))
+	assert.Equal(t, 0, count("persons"))
+	var schemas, pending int64
+	assert.NoError(t, setup.ProjectDB.Model(&schema.EventSchema{}).Count(&schemas).Error)
+	assert.NoError(t, setup.ProjectDB.Model(&schema.PendingSchemaUpdate{}).Count(&pending).Error)
+	assert.Equal(t, int64(0), schemas)
+	assert.Equal(t, int64(0), pending)
+	letters, _, err := deadletters.List(setup.ProjectDB, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, deadletters.StagePersist, letters[0].Stage)
+}
+
+func TestPendingSchemaUpdatesAreRecovered(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+	// A crash left the update of a committed and of an uncommitted batch.
+	assert.NoError(t, schema.SavePendingUpdate(setup.ProjectDB, "committed", schema.SchemaUpdate{
+		"signup": {{Key: "plan", Type: "string", Values: []string{"pro"}}},
+	}))
+	assert.NoError(t, schema.SavePendingUpdate(setup.ProjectDB, "uncommitted", schema.SchemaUpdate{
+		"refund": {{Key: "amount", Type: "number", Values: []string{"10"}}},
+	}))
+	tx, err := setup.DuckDB.Tx()
+	assert.NoError(t, err)
+	_, err = tx.Exec("insert into batches values ('committed', now())")
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	processor := NewProjectProcessor("recovery-test", setup.ProjectDB, &setup.DuckDB)
+	assert.NoError(t, processor.processBatch([]*events.EventInput{{
+		EventType:  "signup",
+		Timestamp:  time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC),
+		Properties: map[string]any{"plan": "free"},
+	}}))
+	// Applying an update twice changes nothing.
+	assert.NoError(t, schema.ApplyPendingUpdate(setup.ProjectDB, "committed"))
+
+	var schemas []schema.EventSchema
+	assert.NoError(t, setup.ProjectDB.Preload("Properties.Values").Find(&schemas).Error)
+	assert.Equal(t, 1, len(schemas))
+	assert.Equal(t, "signup", schemas[0].EventType)
+	assert.Equal(t, 1, len(schemas[0].Properties))
+	assert.Equal(t, 2, len(schemas[0].Properties[0].Values))
+
+	var pending int64
+	assert.NoError(t, setup.ProjectDB.Model(&schema.PendingSchemaUpdate{}).Count(&pending).Error)
+	assert.Equal(t, int64(0), pending)
+}
+
+func TestTrackingPlanIsCheckedAfterTransformationsAndPolicies(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+	_, err := trackingplan.SavePlan(setup.ProjectDB, trackingplan.TrackingPlan{
+		Mode:   trackingplan.Quarantine,
+		Events: trackingplan.PlannedEvents{{EventType: "purchase", Schema: trackingplan.PropertySchema{Type: trackingplan.SchemaTypes{"object"}}}},
+	})
+	assert.NoError(t, err)
+	_, err = transformations.CreateRule(setup.ProjectDB, transformations.RuleInput{
+		Name: "rename buy", EventType: "buy", Action: transformations.RenameEvent, Target: "purchase",
+	})
+	assert.NoError(t, err)
+	_, err = privacy.CreatePolicy(setup.ProjectDB, privacy.PolicyInput{Pattern: "email", Action: privacy.Drop})
+	assert.NoError(t, err)
+
+	timestamp := time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC)
+	processor := NewProjectProcessor("quarantine-test", setup.ProjectDB, &setup.DuckDB)
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "buy", Timestamp: timestamp},
+		{EventType: "refund", Timestamp: timestamp, Properties: map[string]any{"email": "jon@example.com"}},
+	}))
+
+	persisted, err := events.QueryEvents(&setup.DuckDB, &queries.EmptyQueryParams)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*persisted))
+	assert.Equal(t, "purchase", (*persisted)[0].EventType)
+
+	letters, _, err := deadletters.List(setup.ProjectDB, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, deadletters.StageQuarantine, letters[0].Stage)
+	stored, err := deadletters.Find(setup.ProjectDB, []uint{letters[0].ID})
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(stored[0].Payload), "jon@example.com"))
+
+	// Quarantined events passed the pipeline, retrying them stores them
+	// without checking the plan again.
+	result, err := processor.retryDeadLetters(stored)
+	assert.NoError(t, err)
+	assert.Equal(t, deadletters.RetryResult{Retried: 1, Stored: 1}, result)
+}
+
+func TestMergedPersonsResolveToCanonicalId(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+	deviceId, userId, anonymousId := "device-1", "user-1", "anonymous-1"
+	timestamp := time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC)
+	processor := NewProjectProcessor("merge-test", setup.ProjectDB, &setup.DuckDB)
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "page_view", PersonId: &deviceId, Timestamp: timestamp, PersonProperties: map[string]any{"browser": "firefox"}},
+		{EventType: "page_view", SessionId: &anonymousId, Timestamp: timestamp},
+		{EventType: "login", PersonId: &userId, Timestamp: timestamp.Add(time.Minute), PersonProperties: map[string]any{"plan": "pro"}},
+	}))
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "$merge", PersonId: &userId, Timestamp: timestamp.Add(2 * time.Minute), Properties: map[string]any{AliasProperty: deviceId}},
+		{EventType: "$alias", PersonId: &userId, Timestamp: timestamp.Add(3 * time.Minute), Properties: map[string]any{AliasProperty: anonymousId}},
+	}))
+
+	result, err := events.QueryEvents(&setup.DuckDB, &queries.EmptyQueryParams)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(*result))
+	for _, event := range *result {
+		assert.Equal(t, userId, *event.PersonId)
+	}
+
+	tx, err := setup.DuckDB.Tx()
+	assert.NoError(t, err)
+	defer tx.Commit()
+	var properties person.PersonProperties
+	assert.NoError(t, tx.QueryRow("select properties from persons where id = $1", userId).Scan(&properties))
+	assert.Equal(t, person.PersonProperties{"browser": "firefox", "plan": "pro"}, properties)
+}
+
+func TestPersonOperationsAreReplayedInOrder(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+	sessionId, userId := "session-1", "user-1"
+	timestamp := time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC)
+	processor := NewProjectProcessor("operations-test", setup.ProjectDB, &setup.DuckDB)
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "page_view", SessionId: &sessionId, Timestamp: timestamp.Add(time.Minute),
+			PersonPropertiesOnce: map[string]any{"utm_source": "mail"}, PersonPropertiesAdd: map[string]float64{"visits": 1}},
+		{EventType: "page_view", SessionId: &sessionId, Timestamp: timestamp,
+			PersonPropertiesOnce: map[string]any{"utm_source": "ads"}, PersonProperties: map[string]any{"visits": 10.0}},
+	}))
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "login", SessionId: &sessionId, PersonId: &userId, Timestamp: timestamp.Add(2 * time.Minute),
+			PersonPropertiesAdd: map[string]float64{"visits": 1}, PersonPropertiesUnion: map[string]any{"plans": []any{"free"}}},
+	}))
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "upgrade", PersonId: &userId, Timestamp: timestamp.Add(3 * time.Minute),
+			PersonPropertiesUnion: map[string]any{"plans": []any{"free", "pro"}}, PersonPropertiesUnset: []string{"utm_source"}},
+		{EventType: "page_view", PersonId: &userId, Timestamp: timestamp.Add(-time.Minute),
+			PersonPropertiesOnce: map[string]any{"utm_source": "seo"}},
+	}))
+
+	tx, err := setup.DuckDB.Tx()
+	assert.NoError(t, err)
+	defer tx.Commit()
+	var properties person.PersonProperties
+	assert.NoError(t, tx.QueryRow("select properties from persons where id = $1", userId).Scan(&properties))
+	assert.Equal(t, person.PersonProperties{"visits": 12.0, "plans": []any{"free", "pro"}}, properties)
+}
+
+func TestGroupsAreStoredWithTheirProperties(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+	userId := "user-1"
+	timestamp := time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC)
+	processor := NewProjectProcessor("groups-test", setup.ProjectDB, &setup.DuckDB)
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "login", PersonId: &userId, Timestamp: timestamp.Add(time.Minute),
+			Groups:          map[string]string{"company": "acme", "workspace": "acme-eu"},
+			GroupProperties: map[string]map[string]any{"company": {"plan": "pro"}}},
+	}))
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "invite", PersonId: &userId, Timestamp: timestamp,
+			Groups:          map[string]string{"company": "acme"},
+			GroupProperties: map[string]map[string]any{"company": {"plan": "free", "seats": 5.0}}},
+	}))
+
+	group, err := groups.GetGroup(&setup.DuckDB, "company", "acme")
+	assert.NoError(t, err)
+	assert.Equal(t, timestamp, group.FirstSeen.UTC())
+	assert.Equal(t, person.PersonProperties{"plan": "pro", "seats": 5.0}, group.Properties)
+
+	types, err := groups.ListTypes(setup.ProjectDB)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(types))
+
+	tx, err := setup.DuckDB.Tx()
+	assert.NoError(t, err)
+	defer tx.Commit()
+	var eventType string
+	assert.NoError(t, tx.QueryRow(`select event_type from events where json_extract_string(groups, '$."workspace"') = 'acme-eu'`).Scan(&eventType))
+	assert.Equal(t, "login", eventType)
+}
+
+func TestPersonsAreListedAndDeletedWithTheirEvents(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+	sessionId, deviceId, userId, otherId := "session-1", "device-1", "user-1", "user-2"
+	timestamp := time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC)
+	processor := NewProjectProcessor("persons-test", setup.ProjectDB, &setup.DuckDB)
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "page_view", SessionId: &sessionId, Timestamp: timestamp},
+		{EventType: "page_view", PersonId: &deviceId, Timestamp: timestamp, PersonProperties: map[string]any{"browser": "firefox"}},
+		{EventType: "login", SessionId: &sessionId, PersonId: &userId, Timestamp: timestamp.Add(time.Minute),
+			PersonProperties: map[string]any{"email": "jon@example.com", "age": 42.0}},
+		{EventType: "login", PersonId: &otherId, Timestamp: timestamp.Add(2 * time.Minute),
+			PersonProperties: map[string]any{"email": "ann@example.com", "age": 23.0}},
+	}))
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "$merge", PersonId: &userId, Timestamp: timestamp.Add(3 * time.Minute), Properties: map[string]any{AliasProperty: deviceId}},
+	}))
+
+	persons, total, err := person.List(&setup.DuckDB, person.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, otherId, persons[0].Id)
+
+	filter, err := person.ParsePropertyFilter("properties.age__gt", "30")
+	assert.NoError(t, err)
+	persons, total, err = person.List(&setup.DuckDB, person.ListParams{Search: "EXAMPLE", Filters: []person.PropertyFilter{filter}, Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, userId, persons[0].Id)
+	assert.Equal(t, "firefox", persons[0].Properties["browser"])
+
+	canonicalId, err := person.CanonicalId(&setup.DuckDB, deviceId)
+	assert.NoError(t, err)
+	assert.Equal(t, userId, canonicalId)
+	profile, err := person.GetProfile(&setup.DuckDB, userId, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{deviceId}, profile.DistinctIds)
+	assert.Equal(t, int64(1), profile.SessionCount)
+	assert.Equal(t, sessionId, profile.Sessions[0].Id)
+
+	timeline, total, err := events.QueryPersonTimeline(&setup.DuckDB, userId, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+	assert.Equal(t, 2, len(*timeline))
+	assert.Equal(t, "$merge", (*timeline)[0].EventType)
+
+	deletion, err := processor.deletePerson(userId)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), deletion.Events)
+	assert.Equal(t, int64(1), deletion.Sessions)
+	profile, err = person.GetProfile(&setup.DuckDB, userId, 10)
+	assert.NoError(t, err)
+	assert.Nil(t, profile)
+	result, err := events.QueryEvents(&setup.DuckDB, &queries.EmptyQueryParams)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*result))
+	assert.Equal(t, otherId, *(*result)[0].PersonId)
+}
+
+func TestErasureScrubsSchemaValuesAndIsRecorded(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+	userId, otherId := "user-1", "user-2"
+	timestamp := time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC)
+	processor := NewProjectProcessor("erasure-test", setup.ProjectDB, &setup.DuckDB)
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "signup", PersonId: &userId, Timestamp: timestamp,
+			Properties: map[string]any{"plan": "pro", "email": "jon@example.com"}},
+		{EventType: "signup", PersonId: &otherId, Timestamp: timestamp.Add(time.Minute),
+			Properties: map[string]any{"plan": "pro"}},
+	}))
+
+	sessionId := "session-1"
+	assert.NoError(t, deadletters.Save(setup.ProjectDB, []deadletters.DeadLetter{
+		deadletters.Quarantine(&events.EventInput{EventType: "signup", SessionId: &sessionId, PersonId: &userId,
+			Properties: map[string]any{"email": "jon@example.com"}}, errors.New("unplanned")),
+		deadletters.Quarantine(&events.EventInput{EventType: "signup", PersonId: &otherId}, errors.New("unplanned")),
+	}))
+
+	record, err := processor.erasePerson(userId)
+	assert.NoError(t, err)
+	assert.Equal(t, erasure.StatusCompleted, record.Status)
+	assert.Equal(t, int64(1), record.Events)
+	assert.Equal(t, int64(1), record.DeadLetters)
+	assert.Equal(t, int64(1), record.SchemaValues)
+	assert.NotEqual(t, userId, record.SubjectHash)
+
+	var values []string
+	assert.NoError(t, setup.ProjectDB.Model(&schema.EventSchemaPropertyValue{}).Order("value").Pluck("value", &values).Error)
+	assert.Equal(t, []string{"pro"}, values)
+	result, err := events.QueryEvents(&setup.DuckDB, &queries.EmptyQueryParams)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*result))
+
+	letters, _, err := deadletters.List(setup.ProjectDB, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(letters))
+
+	erasures, err := erasure.List(setup.ProjectDB, userId)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(erasures))
+	assert.NotNil(t, erasures[0].CompletedAt)
+	erasures, err = erasure.List(setup.ProjectDB, otherId)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(erasures))
+}
+
+func TestErasureDropsQueuedEventsOfThePerson(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{
+		ProjectDB: true,
+		DuckDB:    true,
+	})
+	defer setup.Dispose()
+
+	migrateProjectTables(t, setup.ProjectDB)
+	userId, otherId, sessionId := "user-1", "user-2", "session-1"
+	timestamp := time.Date(2026, 5, 9, 12, 30, 0, 0, time.UTC)
+	wal, err := openWAL(t.TempDir())
+	assert.NoError(t, err)
+	processor := NewProjectProcessor("queued-erasure-test", setup.ProjectDB, &setup.DuckDB)
+	processor.wal = wal
+	assert.NoError(t, processor.processBatch([]*events.EventInput{
+		{EventType: "signup", PersonId: &userId, SessionId: &sessionId, Timestamp: timestamp},
+	}))
+	assert.NoError(t, processor.enqueueEvents([]*events.EventInput{
+		{EventType: "page_view", SessionId: &sessionId, Timestamp: timestamp.Add(time.Minute), Ip: "1.2.3.4"},
+		{EventType: "page_view", PersonId: &otherId, Timestamp: timestamp.Add(time.Minute)},
+	}))
+
+	record, err := processor.erasePerson(userId)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), record.QueuedEvents)
+	records, err := wal.Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, 1, len(records[0].Events))
+	assert.Equal(t, otherId, *records[0].Events[0].PersonId)
+
+	// The person may come back with events sent after the erasure.
+	assert.NoError(t, processor.enqueueEvents([]*events.EventInput{
+		{EventType: "login", PersonId: &userId, Timestamp: timestamp.Add(time.Hour)},
+	}))
+	batch := make([]queuedEvent, 0, 3)
+	for len(processor.eventQueue) > 0 {
+		batch = append(batch, <-processor.eventQueue)
+	}
+	processor.processQueuedBatch(batch)
+
+	persisted, err := events.QueryEvents(&setup.DuckDB, &queries.EmptyQueryParams)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(*persisted))
+	eventTypes := []string{(*persisted)[0].EventType, (*persisted)[1].EventType}
+	slices.Sort(eventTypes)
+	assert.Equal(t, []string{"login", "page_view"}, eventTypes)
+	assert.Equal(t, 0, len(processor.erased))
+}
@@ -1,28 +1,44 @@
 package processor
 
 import (
+	"analytics/domain/deadletters"
 	"analytics/domain/events"
+	"analytics/domain/events/parquet"
+	"analytics/domain/filecatalog"
 	"analytics/log"
+	"database/sql/driver"
 	"encoding/json"
+	"time"
 )
 
-func (p *ProjectProcessor) PersistEvents(events []*events.Event) {
-	appender := p.dbd.Appender("events")
-	defer appender.Close()
-
-	for _, event := range events {
+// encodeEvents converts the events into rows of the events table. Events that
+// cannot be encoded are returned as dead letters instead.
+func (p *ProjectProcessor) encodeEvents(input []*events.Event) ([]*events.Event, [][]driver.Value, []deadletters.DeadLetter) {
+	encoded := make([]*events.Event, 0, len(input))
+	rows := make([][]driver.Value, 0, len(input))
+	var failed []deadletters.DeadLetter
+	for _, event := range input {
 		propertiesJson, err := json.Marshal(event.Properties)
 		if err != nil {
 			log.Error("Project %s: Error marshaling properties: %v", p.projectID, err)
+			failed = append(failed, deadletters.New(event, deadletters.StageEncode, err))
 			continue
 		}
 		personPropertiesJson, err := json.Marshal(event.PersonProperties)
 		if err != nil {
 			log.Error("Project %s: Error marshaling person properties: %v", p.projectID, err)
+			failed = append(failed, deadletters.New(event, deadletters.StageEncode, err))
+			continue
+		}
+		personOperations, err := personOperationsOf(event).Value()
+		if err != nil {
+			log.Error("Project %s: Error marshaling person operations: %v", p.projectID, err)
+			failed = append(failed, deadletters.New(event, deadletters.StageEncode, err))
 			continue
 		}
 
-		err = appender.AppendRow(
+		encoded = append(encoded, event)
+		rows = append(rows, []driver.Value{
 			mapUuid(event.Id),
 			event.Timestamp,
 			event.EventType,
@@ -30,10 +46,27 @@ func (p *ProjectProcessor) PersistEvents(events []*events.Event) {
 			nullableString(event.PersonId),
 			string(propertiesJson),
 			string(personPropertiesJson),
-		)
-		if err != nil {
-			log.Error("Project %s: Error appending row: %v", p.projectID, err)
-			continue
-		}
+			personOperations,
+			encodeGroups(event),
+		})
+	}
+	return encoded, rows, failed
+}
+
+// invalidateSegments marks the parquet files that were exported before some of
+// the events arrived as stale and schedules their export.
+func (p *ProjectProcessor) invalidateSegments(events []*events.Event) {
+	timestamps := make([]time.Time, len(events))
+	for i, event := range events {
+		timestamps[i] = event.Timestamp
+	}
+	invalidated, err := filecatalog.InvalidateSegments(p.db, timestamps, time.Now())
+	if err != nil {
+		log.Error("Project %s: Error invalidating parquet files: %v", p.projectID, err)
+		return
+	}
+	if invalidated > 0 {
+		log.Info("Project %s: Late events made %d parquet files stale", p.projectID, invalidated)
+		parquet.ScheduleRegeneration(p.projectID, p.db)
 	}
 }
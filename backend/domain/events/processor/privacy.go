@@ -0,0 +1,29 @@
+package processor
+
+import (
+	"analytics/domain/events"
+	"analytics/domain/privacy"
+)
+
+// applyPropertyPolicies redacts the properties and person properties matched
+// by the project's property policies. It runs before the schemas are merged,
+// so raw values reach neither the events, the persons nor the schema values.
+func (p *ProjectProcessor) applyPropertyPolicies(input []*events.EventInput) error {
+	policies, err := privacy.ListPolicies(p.db)
+	if err != nil {
+		return err
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+	secret, err := privacy.HashSecret(p.projectID, p.db)
+	if err != nil {
+		return err
+	}
+
+	enforcer := privacy.NewEnforcer(policies, secret)
+	for _, event := range input {
+		enforcer.Apply(event)
+	}
+	return nil
+}
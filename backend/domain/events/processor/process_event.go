@@ -2,60 +2,341 @@ package processor
 
 import (
 	"analytics/domain/events"
-	"analytics/domain/schema"
 	"analytics/log"
+	"analytics/util"
+	"encoding/json"
 	"github.com/duckdb/duckdb-go/v2"
 	"github.com/google/uuid"
-	"slices"
 	"time"
 )
 
 const (
 	batchSize    = 100
 	batchTimeout = 5 * time.Second
+	// batchRetries is the number of times a failed queue batch is processed
+	// again before its events are moved to the dead letters. The delay
+	// doubles with each retry.
+	batchRetries = 3
 )
 
-func ProcessEvent(projectID string, event *events.EventInput) {
-	processor := GetOrCreateProcessor(projectID)
-	processor.eventQueue <- event
+var (
+	batchRetryDelay  = time.Second
+	sleepBeforeRetry = time.Sleep
+)
+
+// RetryAfter is the delay suggested to clients whose events were rejected
+// because the queue was full. The queue is flushed at least this often.
+const RetryAfter = batchTimeout
+
+func ProcessEvent(projectID string, event *events.EventInput) error {
+	return ProcessEvents(projectID, []*events.EventInput{event})
 }
 
-func ProcessEvents(projectID string, events []*events.EventInput) {
+// ProcessEvents records the events in the project's write-ahead log and queues
+// them for processing. Once it returns without error the events are durable.
+// It never blocks on a full queue but returns ErrQueueFull instead.
+func ProcessEvents(projectID string, events []*events.EventInput) error {
 	processor := GetOrCreateProcessor(projectID)
-	for _, event := range events {
-		processor.eventQueue <- event
+	return processor.enqueueEvents(events)
+}
+
+// ImportEvents processes a chunk of historical events right away, bypassing
+// the event queue and its write-ahead log. Importing the same events again
+// is safe, as events with known ids are dropped. Imports fail once the
+// processor is stopped.
+func ImportEvents(projectID string, events []*events.EventInput) error {
+	proc := GetOrCreateProcessor(projectID)
+	proc.enqueue.Lock()
+	stopped := proc.stopped
+	proc.enqueue.Unlock()
+	if stopped {
+		return ErrProcessorStopped
+	}
+	return proc.processEvents(events, false)
+}
+
+func QueueStatsFor(projectID string) QueueStats {
+	return GetOrCreateProcessor(projectID).Stats()
+}
+
+func (p *ProjectProcessor) enqueueEvents(input []*events.EventInput) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	// Appending and queueing under one lock keeps the queue in log order,
+	// which is what allows committing the log by sequence number.
+	p.enqueue.Lock()
+	defer p.enqueue.Unlock()
+	if p.stopped {
+		return ErrProcessorStopped
 	}
+	// Only this function adds to the queue and it holds the lock, so the free
+	// space can only grow until the events below are sent.
+	if len(input) > cap(p.eventQueue) {
+		return ErrBatchTooLarge
+	}
+	if len(p.eventQueue)+len(input) > cap(p.eventQueue) {
+		return ErrQueueFull
+	}
+
+	seq, err := p.wal.Append(input)
+	if err != nil {
+		log.Error("Project %s: Error writing events to write-ahead log: %v", p.projectID, err)
+		return err
+	}
+	for i, event := range input {
+		p.eventQueue <- queuedEvent{
+			event:     event,
+			walSeq:    seq,
+			recordEnd: i == len(input)-1,
+		}
+	}
+	return nil
 }
 
 // actions/process_event.go
 func (p *ProjectProcessor) processEventQueue() {
-	batch := make([]*events.EventInput, 0, batchSize)
+	p.replayWAL()
+
+	p.enqueue.Lock()
+	queue := p.eventQueue
+	p.enqueue.Unlock()
+	batch := make([]queuedEvent, 0, batchSize)
 	timer := time.NewTimer(batchTimeout)
 
 	for {
 		select {
-		case event := <-p.eventQueue:
-			batch = append(batch, event)
+		case item := <-queue:
+			batch = append(batch, item)
 			if len(batch) >= batchSize {
-				p.processBatch(batch)
+				p.processQueuedBatch(batch)
 				batch = batch[:0]
 				timer.Reset(batchTimeout)
 			}
 
+		case resized := <-p.resized:
+			// Nothing is added to the previous queue once it was replaced.
+			batch = p.emptyQueue(queue, batch)
+			queue = resized
+
 		case <-timer.C:
 			if len(batch) > 0 {
-				p.processBatch(batch)
+				p.processQueuedBatch(batch)
 				batch = batch[:0]
 			}
 			timer.Reset(batchTimeout)
+
+		case <-p.stop:
+			timer.Stop()
+			p.drainQueue(queue, batch)
+			return
+		}
+	}
+}
+
+// emptyQueue takes everything queued in queue, processing full batches. It
+// returns the pending batch.
+func (p *ProjectProcessor) emptyQueue(queue chan queuedEvent, batch []queuedEvent) []queuedEvent {
+	for {
+		select {
+		case item := <-queue:
+			batch = append(batch, item)
+			if len(batch) >= batchSize {
+				p.processQueuedBatch(batch)
+				batch = batch[:0]
+			}
+		default:
+			return batch
+		}
+	}
+}
+
+// drainQueue flushes the pending batch together with everything still queued
+// and closes the write-ahead log. New events are rejected once stop is closed,
+// so the queue cannot grow while it is drained. A queue that replaced queue
+// before the worker switched to it is drained as well.
+func (p *ProjectProcessor) drainQueue(queue chan queuedEvent, batch []queuedEvent) {
+	defer close(p.done)
+	batch = p.emptyQueue(queue, batch)
+	p.enqueue.Lock()
+	current := p.eventQueue
+	p.enqueue.Unlock()
+	if current != queue {
+		batch = p.emptyQueue(current, batch)
+	}
+	if len(batch) > 0 {
+		p.processQueuedBatch(batch)
+	}
+	if err := p.wal.Close(); err != nil {
+		log.Error("Project %s: Error closing write-ahead log: %v", p.projectID, err)
+	}
+	log.Info("Project %s: Event queue drained", p.projectID)
+}
+
+// replayWAL processes events that were accepted but not persisted before the
+// previous shutdown.
+func (p *ProjectProcessor) replayWAL() {
+	records, err := p.wal.Replay()
+	if err != nil {
+		log.Error("Project %s: Error reading write-ahead log: %v", p.projectID, err)
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	pending := make([]queuedEvent, 0)
+	for _, record := range records {
+		for i, event := range record.Events {
+			pending = append(pending, queuedEvent{
+				event:     event,
+				walSeq:    record.Seq,
+				recordEnd: i == len(record.Events)-1,
+			})
+		}
+	}
+	log.Info("Project %s: Replaying %d events from %d write-ahead log records", p.projectID, len(pending), len(records))
+
+	util.ProcessBatched(&pending, batchSize, p.processQueuedBatch)
+}
+
+// processQueuedBatch processes a batch of the queue and commits the log up to
+// its last complete record. Batches that cannot be processed are retried and
+// then moved to the dead letters. If that fails as well, the batch is held and
+// tried again with the next batch. The log is not committed past a held batch,
+// so it is replayed on the next start.
+func (p *ProjectProcessor) processQueuedBatch(batch []queuedEvent) {
+	p.retryHeld()
+	if !p.handleQueued(batch, batchRetries) {
+		p.held = append(p.held, batch)
+	}
+
+	// A record split across two batches is only committed with its last event.
+	last := batch[len(batch)-1]
+	committed := last.walSeq
+	if !last.recordEnd {
+		committed--
+	}
+	if len(p.held) > 0 {
+		committed = min(committed, p.held[0][0].walSeq-1)
+	}
+	if err := p.wal.Commit(committed); err != nil {
+		log.Error("Project %s: Error committing write-ahead log: %v", p.projectID, err)
+	}
+	p.forgetErased(committed)
+}
+
+// retryHeld tries the held batches once more and keeps those that fail again.
+func (p *ProjectProcessor) retryHeld() {
+	held := p.held[:0]
+	for _, batch := range p.held {
+		if !p.handleQueued(batch, 0) {
+			held = append(held, batch)
+		}
+	}
+	p.held = held
+}
+
+// handleQueued stores a batch of the queue or moves it to the dead letters. It
+// returns false if neither worked.
+func (p *ProjectProcessor) handleQueued(batch []queuedEvent, retries int) bool {
+	err := p.processWithRetries(batch, retries)
+	if err == nil {
+		return true
+	}
+	p.processing.Lock()
+	defer p.processing.Unlock()
+	input := p.withoutErased(batch)
+	if len(input) == 0 {
+		return true
+	}
+	if err := p.deadLetterQueued(input, err); err != nil {
+		log.Error("Project %s: Keeping write-ahead log from record %d: %v", p.projectID, batch[0].walSeq, err)
+		return false
+	}
+	return true
+}
+
+func (p *ProjectProcessor) processWithRetries(batch []queuedEvent, retries int) error {
+	delay := batchRetryDelay
+	for retry := 0; ; retry++ {
+		err := p.processQueued(batch)
+		if err == nil || retry == retries {
+			return err
 		}
+		log.Warn("Project %s: Retrying failed batch in %v: %v", p.projectID, delay, err)
+		sleepBeforeRetry(delay)
+		delay *= 2
+	}
+}
+
+// processQueued processes copies of the events of a queue batch that were not
+// erased, as the pipeline changes the events it runs on. The queued events
+// stay as they were accepted for the next attempt and the dead letters.
+func (p *ProjectProcessor) processQueued(batch []queuedEvent) error {
+	p.processing.Lock()
+	defer p.processing.Unlock()
+	input, err := copyEvents(p.withoutErased(batch))
+	if err != nil {
+		return err
+	}
+	if len(input) == 0 {
+		return nil
+	}
+	return p.processLocked(input, true)
+}
+
+// copyEvents returns deep copies of events, made the way the write-ahead log
+// stores them.
+func copyEvents(input []*events.EventInput) ([]*events.EventInput, error) {
+	encoded, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
 	}
+	var copied []*events.EventInput
+	if err := json.Unmarshal(encoded, &copied); err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
+
+func (p *ProjectProcessor) processBatch(input []*events.EventInput) error {
+	return p.processEvents(input, true)
 }
 
-func (p *ProjectProcessor) processBatch(input []*events.EventInput) {
+// processEvents runs a batch through the ingestion pipeline. Live events, as
+// opposed to imported ones, are checked against the tracking plan and the ids
+// of the stored ones are kept for the dedup window. Imports skip the latter to
+// not hold millions of ids in memory.
+func (p *ProjectProcessor) processEvents(input []*events.EventInput, live bool) error {
+	p.processing.Lock()
+	defer p.processing.Unlock()
+	return p.processLocked(input, live)
+}
+
+func (p *ProjectProcessor) processLocked(input []*events.EventInput, live bool) error {
 	log.Info("Project %s: Processing batch of %d events", p.projectID, len(input))
 	startTime := time.Now()
 
+	persisted, err := p.runPipeline(input, live)
+	if err != nil {
+		return err
+	}
+	if live {
+		p.recentIds.remember(persisted, time.Now())
+	}
+	if len(persisted) > 0 {
+		duration := time.Since(startTime)
+		log.Info("Project %s: Processed batch of %d events in %v", p.projectID, len(persisted), duration)
+	}
+	return nil
+}
+
+// runPipeline normalizes, deduplicates, enriches, transforms, samples, checks
+// against the tracking plan if checkPlan is set and stores events. It returns
+// the stored events and must be called while holding the processing lock.
+func (p *ProjectProcessor) runPipeline(input []*events.EventInput, checkPlan bool) ([]*events.Event, error) {
 	workingCopy := make([]*events.EventInput, 0, len(input))
 	for i, event := range input {
 		normalized := normalizeEvent(event)
@@ -68,45 +349,77 @@ func (p *ProjectProcessor) processBatch(input []*events.EventInput) {
 
 	if len(workingCopy) == 0 {
 		log.Info("Project %s: No valid events in batch", p.projectID)
-		return
+		return nil, nil
 	}
 
-	slices.SortFunc(workingCopy, func(i, j *events.EventInput) int {
-		if i.Timestamp.Equal(j.Timestamp) {
-			return 0
-		}
-		isBefore := i.Timestamp.Before(j.Timestamp)
-		if isBefore {
-			return -1
-		}
-		return 1
-	})
-
-	uniqueEventTypes := schema.ExtractUniqueEventTypes(workingCopy)
-	schemas := schema.FetchExistingSchemas(uniqueEventTypes, p.db)
-	schemasByType := schema.MakeSchemaMap(schemas)
-	mergeEventsIntoSchemas(workingCopy, schemasByType)
-
-	newEvents := make([]*events.Event, 0, len(workingCopy))
-	for _, event := range workingCopy {
-		newEvents = append(newEvents, &events.Event{
-			EventId: events.EventId{
-				Id: uuid.New(),
-			},
-			EventInput: *event,
-		})
-	}
-
-	if err := p.ProcessIdentities(newEvents); err != nil {
-		log.Error("Error processing identities: %v", err)
-		return
+	workingCopy, err := p.dropDuplicates(workingCopy)
+	if err != nil {
+		log.Error("Project %s: Error checking for duplicate events: %v", p.projectID, err)
+		return nil, err
 	}
+	if len(workingCopy) == 0 {
+		log.Info("Project %s: Only duplicate events in batch", p.projectID)
+		return nil, nil
+	}
+	workingCopy = p.enrichWithUserAgent(workingCopy)
+	if len(workingCopy) == 0 {
+		log.Info("Project %s: Only events of bots in batch", p.projectID)
+		return nil, nil
+	}
+	enrichWithGeoIP(workingCopy)
 
-	p.PersistAllSchemas(schemasByType)
-	p.PersistEvents(newEvents)
+	workingCopy, err = p.applyTransformations(workingCopy)
+	if err != nil {
+		log.Error("Project %s: Error applying transformation rules: %v", p.projectID, err)
+		return nil, err
+	}
+	if len(workingCopy) == 0 {
+		log.Info("Project %s: All events in batch were dropped by transformation rules", p.projectID)
+		return nil, nil
+	}
+	workingCopy, err = p.applySampling(workingCopy)
+	if err != nil {
+		log.Error("Project %s: Error applying sampling rules: %v", p.projectID, err)
+		return nil, err
+	}
+	if len(workingCopy) == 0 {
+		log.Info("Project %s: All events in batch were sampled out", p.projectID)
+		return nil, nil
+	}
+	var quarantined []quarantinedEvent
+	if checkPlan {
+		workingCopy, quarantined, err = p.checkTrackingPlan(workingCopy, time.Now())
+		if err != nil {
+			log.Error("Project %s: Error checking the tracking plan: %v", p.projectID, err)
+			return nil, err
+		}
+	}
+	// Quarantined events are kept with the policies applied, so that raw
+	// values do not reach the dead letters either.
+	policed := make([]*events.EventInput, 0, len(workingCopy)+len(quarantined))
+	policed = append(policed, workingCopy...)
+	for _, held := range quarantined {
+		policed = append(policed, held.event)
+	}
+	if err := p.applyPropertyPolicies(policed); err != nil {
+		log.Error("Project %s: Error applying property policies: %v", p.projectID, err)
+		return nil, err
+	}
 
-	duration := time.Since(startTime)
-	log.Info("Project %s: Processed batch of %d events in %v", p.projectID, len(workingCopy), duration)
+	var persisted []*events.Event
+	if len(workingCopy) > 0 {
+		persisted, err = p.storeEvents(workingCopy)
+		if err != nil {
+			return persisted, err
+		}
+	}
+	if len(quarantined) > 0 {
+		if err := p.saveQuarantined(quarantined); err != nil {
+			log.Error("Project %s: Error quarantining events: %v", p.projectID, err)
+			return persisted, err
+		}
+	}
+	return persisted, nil
 }
 
 func mapUuid(id uuid.UUID) duckdb.UUID {
@@ -116,7 +429,11 @@ func mapUuid(id uuid.UUID) duckdb.UUID {
 }
 
 func normalizeEvent(event *events.EventInput) *events.EventInput {
-	if event == nil || event.EventType == "" {
+	if event == nil {
+		return nil
+	}
+	if event.EventType == "" {
+		log.Warn("Dropping event without event type")
 		return nil
 	}
 	if event.Timestamp.IsZero() {
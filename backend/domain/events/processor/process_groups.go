@@ -0,0 +1,222 @@
+package processor
+
+import (
+	"analytics/domain/events"
+	"analytics/domain/groups"
+	"analytics/log"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type groupId struct {
+	groupType string
+	key       string
+}
+
+// groupChanges are the groups a batch creates or updates, together with the
+// groups that existed before the batch.
+type groupChanges struct {
+	groups   map[groupId]*groups.Group
+	existing map[groupId]bool
+	// types are the group types of the batch, which are registered after it
+	// is committed.
+	types []string
+}
+
+// resolveGroups reads the groups of a batch and applies the group properties
+// of its events in the order of their timestamps. Nothing is written until the
+// changes are persisted.
+func (p *ProjectProcessor) resolveGroups(input []*events.Event) (*groupChanges, error) {
+	changes := &groupChanges{groups: make(map[groupId]*groups.Group), existing: make(map[groupId]bool)}
+	ids := make([]groupId, 0)
+	for _, event := range input {
+		for groupType, key := range event.Groups {
+			if !groups.ValidTypeName(groupType) || key == "" {
+				continue
+			}
+			id := groupId{groupType: groupType, key: key}
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+			if !slices.Contains(changes.types, groupType) {
+				changes.types = append(changes.types, groupType)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return changes, nil
+	}
+
+	existing, err := p.fetchGroups(ids)
+	if err != nil {
+		return nil, err
+	}
+	for id, group := range existing {
+		changes.groups[id] = group
+		changes.existing[id] = true
+	}
+
+	for _, event := range input {
+		for groupType, key := range event.Groups {
+			id := groupId{groupType: groupType, key: key}
+			if !slices.Contains(ids, id) {
+				continue
+			}
+			group, ok := changes.groups[id]
+			if !ok {
+				group = &groups.Group{Type: groupType, Key: key, FirstSeen: event.Timestamp}
+				changes.groups[id] = group
+			}
+			if event.Timestamp.Before(group.FirstSeen) {
+				group.FirstSeen = event.Timestamp
+			}
+		}
+	}
+
+	// Events are sorted by timestamp before they are stored.
+	for _, event := range input {
+		for groupType, properties := range event.GroupProperties {
+			id := groupId{groupType: groupType, key: event.Groups[groupType]}
+			if group, ok := changes.groups[id]; ok {
+				group.Apply(properties, event.Timestamp)
+			}
+		}
+	}
+	return changes, nil
+}
+
+func (p *ProjectProcessor) fetchGroups(ids []groupId) (map[groupId]*groups.Group, error) {
+	types := make([]string, len(ids))
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		types[i] = id.groupType
+		keys[i] = id.key
+	}
+
+	tx, err := p.dbd.Tx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	rows, err := tx.Query(`
+		SELECT group_type, group_key, first_seen, properties, property_timestamps
+		FROM groups
+		WHERE list_contains($1::TEXT[], group_type) AND list_contains($2::TEXT[], group_key)
+	`, types, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[groupId]*groups.Group)
+	for rows.Next() {
+		var group groups.Group
+		if err := rows.Scan(&group.Type, &group.Key, &group.FirstSeen, &group.Properties, &group.PropertyTimestamps); err != nil {
+			return nil, err
+		}
+		id := groupId{groupType: group.Type, key: group.Key}
+		if slices.Contains(ids, id) {
+			existing[id] = &group
+		}
+	}
+	return existing, nil
+}
+
+func (c *groupChanges) persist(tx execer) error {
+	newGroups := make([]*groups.Group, 0)
+	for id, group := range c.groups {
+		if !c.existing[id] {
+			newGroups = append(newGroups, group)
+			continue
+		}
+		propertiesJson, err := json.Marshal(group.Properties)
+		if err != nil {
+			return err
+		}
+		timestampsJson, err := json.Marshal(group.PropertyTimestamps)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"UPDATE groups SET first_seen = $3, properties = json($4), property_timestamps = json($5) WHERE group_type = $1 AND group_key = $2",
+			group.Type,
+			group.Key,
+			group.FirstSeen,
+			string(propertiesJson),
+			string(timestampsJson),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if len(newGroups) == 0 {
+		return nil
+	}
+
+	var values strings.Builder
+	params := make([]interface{}, 0, len(newGroups)*5)
+	for i, group := range newGroups {
+		propertiesJson, err := json.Marshal(group.Properties)
+		if err != nil {
+			return err
+		}
+		timestampsJson, err := json.Marshal(group.PropertyTimestamps)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			values.WriteString(", ")
+		}
+		paramIndex := i*5 + 1
+		values.WriteString(fmt.Sprintf("($%d, $%d, $%d, json($%d), json($%d))", paramIndex, paramIndex+1, paramIndex+2, paramIndex+3, paramIndex+4))
+		params = append(params, group.Type, group.Key, group.FirstSeen, string(propertiesJson), string(timestampsJson))
+	}
+	query := fmt.Sprintf("INSERT INTO groups (group_type, group_key, first_seen, properties, property_timestamps) VALUES %s", values.String())
+	_, err := tx.Exec(query, params...)
+	return err
+}
+
+// registerGroupTypes registers the group types of a committed batch that the
+// project does not know yet. Types that do not fit are only logged, their
+// groups are kept with the events.
+func (p *ProjectProcessor) registerGroupTypes(types []string) {
+	unknown := make([]string, 0)
+	for _, groupType := range types {
+		if !p.groupTypes[groupType] {
+			unknown = append(unknown, groupType)
+		}
+	}
+	if len(unknown) == 0 {
+		return
+	}
+	registered, refused, err := groups.RegisterTypes(p.db, unknown)
+	if err != nil {
+		log.Error("Project %s: Error registering group types: %v", p.projectID, err)
+		return
+	}
+	for _, groupType := range registered {
+		p.groupTypes[groupType.Name] = true
+	}
+	if len(refused) > 0 {
+		log.Warn("Project %s: Group types %v exceed the limit of %d and are not exported", p.projectID, refused, groups.MaxGroupTypes)
+		for _, groupType := range refused {
+			p.groupTypes[groupType] = true
+		}
+	}
+}
+
+// encodeGroups is the groups column of an event, NULL for events without
+// groups. The appender encodes maps as JSON objects.
+func encodeGroups(event *events.Event) any {
+	if len(event.Groups) == 0 {
+		return nil
+	}
+	groups := make(map[string]any, len(event.Groups))
+	for groupType, key := range event.Groups {
+		groups[groupType] = key
+	}
+	return groups
+}
@@ -8,6 +8,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 )
@@ -23,36 +24,97 @@ type propertyUpdate struct {
 	PersonId   string
 	Timestamp  time.Time
 	Properties person.PersonProperties
+	Operations person.PropertyOperations
 }
 
-func (p *ProjectProcessor) ProcessIdentities(input []*events.Event) error {
+// identityChanges are the persons and sessions a batch creates or updates,
+// together with their state before the batch, and the persons it merges.
+type identityChanges struct {
+	persons          map[string]*person.Person
+	existingPersons  map[string]*person.Person
+	sessions         map[string]*sessionState
+	existingSessions map[string]*sessionState
+	merges           []appliedMerge
+}
+
+// execer is a transaction the identity changes are written in.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// resolveIdentities reads the persons and sessions of a batch and applies the
+// batch to them. Persons are referred to by their canonical id, the id of the
+// person they were merged into. Nothing is written until the changes are
+// persisted.
+func (p *ProjectProcessor) resolveIdentities(input []*events.Event) (*identityChanges, error) {
 	sessionIds := collectSessionIds(input)
 	existingSessions, err := p.fetchSessions(sessionIds)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	merges := collectMerges(input)
+	ids, err := p.fetchDistinctIds(distinctIdsToLookUp(input, existingSessions, merges))
+	if err != nil {
+		return nil, err
 	}
+	mergedPersons, err := p.fetchPersons(mergedPersonIds(ids, merges))
+	if err != nil {
+		return nil, err
+	}
+	applied := applyMerges(ids, merges, func(id string) bool {
+		_, exists := mergedPersons[id]
+		return exists
+	})
 
-	sessions, newlyLinkedSessions := resolveSessions(input, existingSessions)
-	personIds := collectPersonIds(input, sessions)
+	sessions, newlyLinkedSessions := resolveSessions(input, existingSessions, ids)
+	for _, merge := range applied {
+		newlyLinkedSessions[merge.id] = merge.to
+	}
+	personIds := collectPersonIds(input, sessions, ids)
+	for _, merge := range applied {
+		personIds = appendMissing(personIds, merge.to)
+	}
 	existingPersons, err := p.fetchPersons(personIds)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, merge := range applied {
+		if merged, ok := mergedPersons[merge.from]; ok {
+			existingPersons[merge.from] = merged
+		}
 	}
 
-	personUpdates := collectPropertyUpdates(input, sessions)
+	personUpdates := collectPropertyUpdates(input, sessions, ids)
 	historicalUpdates, err := p.fetchHistoricalSessionPropertyUpdates(newlyLinkedSessions)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	personUpdates = append(personUpdates, historicalUpdates...)
 
-	persons := buildPersons(personIds, existingPersons, input, personUpdates)
+	persons := buildPersons(personIds, existingPersons, input, personUpdates, ids)
 	applyPropertyUpdates(persons, personUpdates)
+	mergePersons(persons, applied)
+
+	return &identityChanges{
+		persons:          persons,
+		existingPersons:  existingPersons,
+		sessions:         sessions,
+		existingSessions: existingSessions,
+		merges:           applied,
+	}, nil
+}
 
-	if err := p.persistPersons(persons, existingPersons); err != nil {
+// persist writes the persons before the merges and sessions that reference
+// them.
+func (c *identityChanges) persist(tx execer) error {
+	if err := persistPersons(tx, c.persons, c.existingPersons); err != nil {
 		return err
 	}
-	return p.persistSessions(sessions, existingSessions)
+	if err := persistMerges(tx, c.merges); err != nil {
+		return err
+	}
+	return persistSessions(tx, c.sessions, c.existingSessions)
 }
 
 func collectSessionIds(input []*events.Event) types.StringList {
@@ -108,12 +170,16 @@ func (p *ProjectProcessor) fetchSessions(sessionIds types.StringList) (map[strin
 	return sessions, nil
 }
 
-func resolveSessions(input []*events.Event, existingSessions map[string]*sessionState) (map[string]*sessionState, map[string]string) {
+func resolveSessions(input []*events.Event, existingSessions map[string]*sessionState, ids distinctIds) (map[string]*sessionState, map[string]string) {
 	sessions := make(map[string]*sessionState)
 	newlyLinkedSessions := make(map[string]string)
 
 	for _, existing := range existingSessions {
 		copy := *existing
+		if copy.PersonId != nil {
+			personId := ids.canonical(*copy.PersonId)
+			copy.PersonId = &personId
+		}
 		sessions[existing.Id] = &copy
 	}
 
@@ -141,7 +207,7 @@ func resolveSessions(input []*events.Event, existingSessions map[string]*session
 
 		if event.PersonId != nil && *event.PersonId != "" {
 			wasAnonymous := session.PersonId == nil || *session.PersonId == ""
-			personId := *event.PersonId
+			personId := ids.canonical(*event.PersonId)
 			session.PersonId = &personId
 			if wasAnonymous {
 				newlyLinkedSessions[session.Id] = personId
@@ -152,7 +218,7 @@ func resolveSessions(input []*events.Event, existingSessions map[string]*session
 	return sessions, newlyLinkedSessions
 }
 
-func collectPersonIds(input []*events.Event, sessions map[string]*sessionState) types.StringList {
+func collectPersonIds(input []*events.Event, sessions map[string]*sessionState, ids distinctIds) types.StringList {
 	seen := make(map[string]bool)
 	personIds := make(types.StringList, 0)
 	add := func(personId string) {
@@ -165,18 +231,54 @@ func collectPersonIds(input []*events.Event, sessions map[string]*sessionState)
 
 	for _, event := range input {
 		if event.PersonId != nil {
-			add(*event.PersonId)
+			add(ids.canonical(*event.PersonId))
 		}
-		if event.SessionId != nil {
-			if session, ok := sessions[*event.SessionId]; ok && session.PersonId != nil {
-				add(*session.PersonId)
-			}
+		if event.SessionId == nil {
+			continue
 		}
+		if session, ok := sessions[*event.SessionId]; ok && session.PersonId != nil {
+			add(*session.PersonId)
+		} else {
+			add(ids[*event.SessionId])
+		}
+	}
+
+	return personIds
+}
+
+// personIdOf returns the canonical id of the person of an event, which is
+// the person of its session or the person its anonymous id was merged into if
+// it has none. It is empty for anonymous events.
+func personIdOf(event *events.Event, sessions map[string]*sessionState, ids distinctIds) string {
+	if event.PersonId != nil && *event.PersonId != "" {
+		return ids.canonical(*event.PersonId)
+	}
+	if event.SessionId == nil || *event.SessionId == "" {
+		return ""
+	}
+	if session, ok := sessions[*event.SessionId]; ok && session.PersonId != nil && *session.PersonId != "" {
+		return ids.canonical(*session.PersonId)
 	}
+	return ids[*event.SessionId]
+}
 
+// mergedPersonIds are the persons merges of a batch may move, which are
+// looked up before the merges are applied.
+func mergedPersonIds(ids distinctIds, merges []personMerge) types.StringList {
+	personIds := make(types.StringList, 0, len(merges))
+	for _, merge := range merges {
+		personIds = appendMissing(personIds, ids.canonical(merge.id))
+	}
 	return personIds
 }
 
+func appendMissing(list types.StringList, value string) types.StringList {
+	if slices.Contains(list, value) {
+		return list
+	}
+	return append(list, value)
+}
+
 func (p *ProjectProcessor) fetchPersons(personIds types.StringList) (map[string]*person.Person, error) {
 	persons := make(map[string]*person.Person)
 	if len(personIds) == 0 {
@@ -190,7 +292,7 @@ func (p *ProjectProcessor) fetchPersons(personIds types.StringList) (map[string]
 	defer tx.Commit()
 
 	rows, err := tx.Query(`
-		SELECT id, first_seen, properties, property_timestamps
+		SELECT id, first_seen, properties, property_timestamps, property_once
 		FROM persons
 		WHERE list_contains($1::TEXT[], id)
 	`, personIds)
@@ -206,6 +308,7 @@ func (p *ProjectProcessor) fetchPersons(personIds types.StringList) (map[string]
 			&personRecord.FirstSeen,
 			&personRecord.Properties,
 			&personRecord.PropertyTimestamps,
+			&personRecord.OnceTimestamps,
 		); err != nil {
 			return nil, err
 		}
@@ -215,28 +318,24 @@ func (p *ProjectProcessor) fetchPersons(personIds types.StringList) (map[string]
 		if personRecord.PropertyTimestamps == nil {
 			personRecord.PropertyTimestamps = make(person.PropertyTimestamps)
 		}
+		if personRecord.OnceTimestamps == nil {
+			personRecord.OnceTimestamps = make(person.PropertyTimestamps)
+		}
 		persons[personRecord.Id] = &personRecord
 	}
 
 	return persons, nil
 }
 
-func collectPropertyUpdates(input []*events.Event, sessions map[string]*sessionState) []propertyUpdate {
+func collectPropertyUpdates(input []*events.Event, sessions map[string]*sessionState, ids distinctIds) []propertyUpdate {
 	updates := make([]propertyUpdate, 0)
 	for _, event := range input {
-		if len(event.PersonProperties) == 0 {
+		operations := personOperationsOf(event)
+		if len(event.PersonProperties) == 0 && operations.IsEmpty() {
 			continue
 		}
 
-		personId := ""
-		if event.PersonId != nil {
-			personId = *event.PersonId
-		}
-		if personId == "" && event.SessionId != nil {
-			if session, ok := sessions[*event.SessionId]; ok && session.PersonId != nil {
-				personId = *session.PersonId
-			}
-		}
+		personId := personIdOf(event, sessions, ids)
 		if personId == "" {
 			continue
 		}
@@ -245,11 +344,25 @@ func collectPropertyUpdates(input []*events.Event, sessions map[string]*sessionS
 			PersonId:   personId,
 			Timestamp:  event.Timestamp,
 			Properties: person.PersonProperties(event.PersonProperties),
+			Operations: operations,
 		})
 	}
 	return updates
 }
 
+// personOperationsOf collects the updates of an event to person properties
+// besides setting them. They are stored with the event, so they can be
+// replayed when its session is linked to a person later.
+func personOperationsOf(event *events.Event) person.PropertyOperations {
+	return person.PropertyOperations{
+		Once:   event.PersonPropertiesOnce,
+		Unset:  event.PersonPropertiesUnset,
+		Add:    event.PersonPropertiesAdd,
+		Append: event.PersonPropertiesAppend,
+		Union:  event.PersonPropertiesUnion,
+	}
+}
+
 func (p *ProjectProcessor) fetchHistoricalSessionPropertyUpdates(newlyLinkedSessions map[string]string) ([]propertyUpdate, error) {
 	if len(newlyLinkedSessions) == 0 {
 		return nil, nil
@@ -267,7 +380,7 @@ func (p *ProjectProcessor) fetchHistoricalSessionPropertyUpdates(newlyLinkedSess
 	defer tx.Commit()
 
 	rows, err := tx.Query(`
-		SELECT session_id, timestamp, person_properties
+		SELECT session_id, timestamp, person_properties, person_operations
 		FROM events
 		WHERE session_id IS NOT NULL
 		  AND list_contains($1::TEXT[], session_id)
@@ -282,7 +395,8 @@ func (p *ProjectProcessor) fetchHistoricalSessionPropertyUpdates(newlyLinkedSess
 		var sessionId string
 		var timestamp time.Time
 		var propertiesValue any
-		if err := rows.Scan(&sessionId, &timestamp, &propertiesValue); err != nil {
+		var operations person.PropertyOperations
+		if err := rows.Scan(&sessionId, &timestamp, &propertiesValue, &operations); err != nil {
 			return nil, err
 		}
 		properties, err := events.ParseJSONProperties(propertiesValue)
@@ -291,7 +405,7 @@ func (p *ProjectProcessor) fetchHistoricalSessionPropertyUpdates(newlyLinkedSess
 			continue
 		}
 		props := person.PersonProperties(properties)
-		if len(props) == 0 {
+		if len(props) == 0 && operations.IsEmpty() {
 			continue
 		}
 
@@ -299,6 +413,7 @@ func (p *ProjectProcessor) fetchHistoricalSessionPropertyUpdates(newlyLinkedSess
 			PersonId:   newlyLinkedSessions[sessionId],
 			Timestamp:  timestamp,
 			Properties: props,
+			Operations: operations,
 		})
 	}
 
@@ -310,6 +425,7 @@ func buildPersons(
 	existingPersons map[string]*person.Person,
 	input []*events.Event,
 	updates []propertyUpdate,
+	ids distinctIds,
 ) map[string]*person.Person {
 	persons := make(map[string]*person.Person)
 	for _, existing := range existingPersons {
@@ -320,7 +436,7 @@ func buildPersons(
 	firstSeen := make(map[string]time.Time)
 	for _, event := range input {
 		if event.PersonId != nil && *event.PersonId != "" {
-			updateFirstSeen(firstSeen, *event.PersonId, event.Timestamp)
+			updateFirstSeen(firstSeen, ids.canonical(*event.PersonId), event.Timestamp)
 		}
 	}
 	for _, update := range updates {
@@ -340,6 +456,7 @@ func buildPersons(
 			FirstSeen:          seenAt,
 			Properties:         make(person.PersonProperties),
 			PropertyTimestamps: make(person.PropertyTimestamps),
+			OnceTimestamps:     make(person.PropertyTimestamps),
 		}
 	}
 
@@ -362,33 +479,27 @@ func updateFirstSeen(firstSeen map[string]time.Time, personId string, timestamp
 	}
 }
 
+// applyPropertyUpdates applies the updates in the order of their events, so
+// increments and appends land on the value that was set before them, whether
+// the events came in one batch or were replayed.
 func applyPropertyUpdates(persons map[string]*person.Person, updates []propertyUpdate) {
+	slices.SortStableFunc(updates, func(a, b propertyUpdate) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
 	for _, update := range updates {
 		personRecord, exists := persons[update.PersonId]
 		if !exists {
 			continue
 		}
-		if personRecord.Properties == nil {
-			personRecord.Properties = make(person.PersonProperties)
-		}
-		if personRecord.PropertyTimestamps == nil {
-			personRecord.PropertyTimestamps = make(person.PropertyTimestamps)
-		}
-		personRecord.Properties.ApplyLatestProps(update.Properties, personRecord.PropertyTimestamps, update.Timestamp)
+		personRecord.Apply(update.Properties, update.Operations, update.Timestamp)
 	}
 }
 
-func (p *ProjectProcessor) persistSessions(sessions, existingSessions map[string]*sessionState) error {
+func persistSessions(tx execer, sessions, existingSessions map[string]*sessionState) error {
 	if len(sessions) == 0 {
 		return nil
 	}
 
-	tx, err := p.dbd.Tx()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
 	newSessions := make([]*sessionState, 0)
 	updatedSessions := make([]*sessionState, 0)
 	for id, session := range sessions {
@@ -420,7 +531,7 @@ func (p *ProjectProcessor) persistSessions(sessions, existingSessions map[string
 		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
 func sessionInsertValues(sessions []*sessionState) (string, []interface{}) {
@@ -438,17 +549,11 @@ func sessionInsertValues(sessions []*sessionState) (string, []interface{}) {
 	return values.String(), params
 }
 
-func (p *ProjectProcessor) persistPersons(persons, existingPersons map[string]*person.Person) error {
+func persistPersons(tx execer, persons, existingPersons map[string]*person.Person) error {
 	if len(persons) == 0 {
 		return nil
 	}
 
-	tx, err := p.dbd.Tx()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
 	newPersons := make([]*person.Person, 0)
 	updatedPersons := make([]*person.Person, 0)
 	for id, personRecord := range persons {
@@ -464,7 +569,7 @@ func (p *ProjectProcessor) persistPersons(persons, existingPersons map[string]*p
 		if err != nil {
 			return err
 		}
-		query := fmt.Sprintf("INSERT INTO persons (id, first_seen, properties, property_timestamps) VALUES %s", values)
+		query := fmt.Sprintf("INSERT INTO persons (id, first_seen, properties, property_timestamps, property_once) VALUES %s", values)
 		if _, err := tx.Exec(query, params...); err != nil {
 			return err
 		}
@@ -479,24 +584,29 @@ func (p *ProjectProcessor) persistPersons(persons, existingPersons map[string]*p
 		if err != nil {
 			return err
 		}
+		onceJson, err := json.Marshal(personRecord.OnceTimestamps)
+		if err != nil {
+			return err
+		}
 		_, err = tx.Exec(
-			"UPDATE persons SET first_seen = $2, properties = json($3), property_timestamps = json($4) WHERE id = $1",
+			"UPDATE persons SET first_seen = $2, properties = json($3), property_timestamps = json($4), property_once = json($5) WHERE id = $1",
 			personRecord.Id,
 			personRecord.FirstSeen,
 			string(propertiesJson),
 			string(timestampsJson),
+			string(onceJson),
 		)
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
 func personInsertValues(persons []*person.Person) (string, []interface{}, error) {
 	var values strings.Builder
-	params := make([]interface{}, 0, len(persons)*4)
+	params := make([]interface{}, 0, len(persons)*5)
 	paramIndex := 1
 	for i, personRecord := range persons {
 		propertiesJson, err := json.Marshal(personRecord.Properties)
@@ -507,12 +617,16 @@ func personInsertValues(persons []*person.Person) (string, []interface{}, error)
 		if err != nil {
 			return "", nil, err
 		}
+		onceJson, err := json.Marshal(personRecord.OnceTimestamps)
+		if err != nil {
+			return "", nil, err
+		}
 		if i > 0 {
 			values.WriteString(", ")
 		}
-		values.WriteString(fmt.Sprintf("($%d, $%d, json($%d), json($%d))", paramIndex, paramIndex+1, paramIndex+2, paramIndex+3))
-		params = append(params, personRecord.Id, personRecord.FirstSeen, string(propertiesJson), string(timestampsJson))
-		paramIndex += 4
+		values.WriteString(fmt.Sprintf("($%d, $%d, json($%d), json($%d), json($%d))", paramIndex, paramIndex+1, paramIndex+2, paramIndex+3, paramIndex+4))
+		params = append(params, personRecord.Id, personRecord.FirstSeen, string(propertiesJson), string(timestampsJson), string(onceJson))
+		paramIndex += 5
 	}
 	return values.String(), params, nil
 }
@@ -20,9 +20,9 @@ func TestAnonymousEventDoesNotCreateIdentity(t *testing.T) {
 		},
 	}
 
-	sessions, linkedSessions := resolveSessions(input, map[string]*sessionState{})
-	personIds := collectPersonIds(input, sessions)
-	updates := collectPropertyUpdates(input, sessions)
+	sessions, linkedSessions := resolveSessions(input, map[string]*sessionState{}, distinctIds{})
+	personIds := collectPersonIds(input, sessions, distinctIds{})
+	updates := collectPropertyUpdates(input, sessions, distinctIds{})
 
 	if len(sessions) != 0 {
 		t.Fatalf("expected no sessions, got %d", len(sessions))
@@ -76,10 +76,10 @@ func TestSessionUpgradeAppliesLatestPersonPropertiesByTimestamp(t *testing.T) {
 		},
 	}
 
-	sessions, _ := resolveSessions(input, map[string]*sessionState{})
-	personIds := collectPersonIds(input, sessions)
-	updates := collectPropertyUpdates(input, sessions)
-	persons := buildPersons(personIds, map[string]*person.Person{}, input, updates)
+	sessions, _ := resolveSessions(input, map[string]*sessionState{}, distinctIds{})
+	personIds := collectPersonIds(input, sessions, distinctIds{})
+	updates := collectPropertyUpdates(input, sessions, distinctIds{})
+	persons := buildPersons(personIds, map[string]*person.Person{}, input, updates, distinctIds{})
 	applyPropertyUpdates(persons, updates)
 
 	personRecord := persons[personId]
@@ -93,3 +93,56 @@ func TestSessionUpgradeAppliesLatestPersonPropertiesByTimestamp(t *testing.T) {
 		t.Fatalf("expected latest property timestamp %s, got %s", t2, got)
 	}
 }
+
+func TestMergesMoveAllIdsOfAPerson(t *testing.T) {
+	ids := distinctIds{"device_1": "user_1"}
+	applied := applyMerges(ids, []personMerge{
+		{id: "device_2", target: "user_2", operation: identifyOperation},
+		{id: "user_1", target: "user_2", operation: mergeOperation},
+		{id: "device_1", target: "user_3", operation: aliasOperation},
+	}, func(id string) bool { return id == "user_1" })
+
+	if len(applied) != 1 {
+		t.Fatalf("expected only the merge to apply, got %v", applied)
+	}
+	if got := ids.canonical("device_1"); got != "user_2" {
+		t.Fatalf("expected device_1 to move with its person, got %s", got)
+	}
+	if got := ids.canonical("user_1"); got != "user_2" {
+		t.Fatalf("expected user_1 to be merged into user_2, got %s", got)
+	}
+	if got := ids.canonical("device_2"); got != "device_2" {
+		t.Fatalf("expected the anonymous id of $identify to be left to its session, got %s", got)
+	}
+}
+
+func TestMergePersonsKeepsLatestProperties(t *testing.T) {
+	t1 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	persons := map[string]*person.Person{
+		"device": {
+			Id:                 "device",
+			FirstSeen:          t1,
+			Properties:         person.PersonProperties{"plan": "free", "browser": "firefox"},
+			PropertyTimestamps: person.PropertyTimestamps{"plan": t1, "browser": t1},
+		},
+		"user": {
+			Id:                 "user",
+			FirstSeen:          t2,
+			Properties:         person.PersonProperties{"plan": "paid"},
+			PropertyTimestamps: person.PropertyTimestamps{"plan": t2},
+		},
+	}
+	mergePersons(persons, []appliedMerge{{id: "device", from: "device", to: "user"}})
+
+	if _, ok := persons["device"]; ok {
+		t.Fatal("expected the merged person to no longer be updated")
+	}
+	merged := persons["user"]
+	if merged.Properties["plan"] != "paid" || merged.Properties["browser"] != "firefox" {
+		t.Fatalf("expected latest properties of both persons, got %v", merged.Properties)
+	}
+	if !merged.FirstSeen.Equal(t1) {
+		t.Fatalf("expected first seen of the merged person, got %s", merged.FirstSeen)
+	}
+}
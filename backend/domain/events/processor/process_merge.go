@@ -0,0 +1,263 @@
+package processor
+
+import (
+	"analytics/database/types"
+	"analytics/domain/events"
+	"analytics/domain/events/parquet"
+	"analytics/domain/filecatalog"
+	"analytics/domain/person"
+	"analytics/log"
+	"time"
+)
+
+// AliasProperty names the id that $alias and $merge events move to the person
+// of the event. Events without it move their session id.
+const AliasProperty = "alias"
+
+type identityOperation int
+
+const (
+	identifyOperation identityOperation = iota
+	aliasOperation
+	mergeOperation
+)
+
+// identityEvents are the event types that move an id to the person of the
+// event, including those of the PostHog SDKs.
+var identityEvents = map[string]identityOperation{
+	"$identify":          identifyOperation,
+	"$alias":             aliasOperation,
+	"$create_alias":      aliasOperation,
+	"$merge":             mergeOperation,
+	"$merge_dangerously": mergeOperation,
+}
+
+// distinctIds maps ids that were merged into another person to the id of that
+// person. Ids that are not mapped are the id of their own person.
+type distinctIds map[string]string
+
+func (d distinctIds) canonical(id string) string {
+	if personId, ok := d[id]; ok {
+		return personId
+	}
+	return id
+}
+
+type personMerge struct {
+	id        string
+	target    string
+	operation identityOperation
+}
+
+// appliedMerge moved the person from, which id belonged to, into the person to.
+type appliedMerge struct {
+	id   string
+	from string
+	to   string
+}
+
+func collectMerges(input []*events.Event) []personMerge {
+	merges := make([]personMerge, 0)
+	for _, event := range input {
+		operation, ok := identityEvents[event.EventType]
+		if !ok || event.PersonId == nil || *event.PersonId == "" {
+			continue
+		}
+		id, _ := event.Properties[AliasProperty].(string)
+		if id == "" && event.SessionId != nil {
+			id = *event.SessionId
+		}
+		if id == "" || id == *event.PersonId {
+			continue
+		}
+		merges = append(merges, personMerge{id: id, target: *event.PersonId, operation: operation})
+	}
+	return merges
+}
+
+// distinctIdsToLookUp collects the ids of a batch whose person may have been
+// merged into another one.
+func distinctIdsToLookUp(input []*events.Event, sessions map[string]*sessionState, merges []personMerge) types.StringList {
+	seen := make(map[string]bool)
+	ids := make(types.StringList, 0)
+	add := func(id *string) {
+		if id == nil || *id == "" || seen[*id] {
+			return
+		}
+		seen[*id] = true
+		ids = append(ids, *id)
+	}
+	for _, event := range input {
+		add(event.PersonId)
+		add(event.SessionId)
+	}
+	for _, session := range sessions {
+		add(session.PersonId)
+	}
+	for _, merge := range merges {
+		add(&merge.id)
+		add(&merge.target)
+	}
+	return ids
+}
+
+// fetchDistinctIds reads the mapping of the ids and of the ids merged into
+// them.
+func (p *ProjectProcessor) fetchDistinctIds(ids types.StringList) (distinctIds, error) {
+	mapping := make(distinctIds)
+	if len(ids) == 0 {
+		return mapping, nil
+	}
+
+	tx, err := p.dbd.Tx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	rows, err := tx.Query(`
+		SELECT distinct_id, person_id
+		FROM person_distinct_ids
+		WHERE list_contains($1::TEXT[], distinct_id) OR list_contains($1::TEXT[], person_id)
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var distinctId, personId string
+		if err := rows.Scan(&distinctId, &personId); err != nil {
+			return nil, err
+		}
+		mapping[distinctId] = personId
+	}
+	return mapping, nil
+}
+
+// applyMerges applies the merges of a batch in order to the mapping. $identify
+// only merges ids that are persons of their own, as linking anonymous ids is
+// done by their sessions. $identify and $alias do not move an id that was
+// merged into another person before, $merge does.
+func applyMerges(ids distinctIds, merges []personMerge, isPerson func(id string) bool) []appliedMerge {
+	applied := make([]appliedMerge, 0)
+	for _, merge := range merges {
+		from := ids.canonical(merge.id)
+		to := ids.canonical(merge.target)
+		if from == to {
+			continue
+		}
+		if merge.operation != mergeOperation && from != merge.id {
+			log.Info("Not moving %s to %s, it was merged into %s before", merge.id, to, from)
+			continue
+		}
+		if merge.operation == identifyOperation && !isPerson(from) {
+			continue
+		}
+		for distinctId, personId := range ids {
+			if personId == from {
+				ids[distinctId] = to
+			}
+		}
+		ids[from] = to
+		applied = append(applied, appliedMerge{id: merge.id, from: from, to: to})
+	}
+	// Persons that were merged twice end up in the person of the last merge.
+	for i := range applied {
+		applied[i].to = ids.canonical(applied[i].to)
+	}
+	return applied
+}
+
+// mergePersons moves the properties of merged persons into the person they
+// were merged into. Properties both have keep the value set last, or the one
+// set first if both were set by $set_once. Merged persons are kept, as
+// sessions may still reference them until the batch is committed, but are no
+// longer updated.
+func mergePersons(persons map[string]*person.Person, merges []appliedMerge) {
+	for _, merge := range merges {
+		source, ok := persons[merge.from]
+		if !ok {
+			continue
+		}
+		delete(persons, merge.from)
+		target, ok := persons[merge.to]
+		if !ok {
+			continue
+		}
+		if source.FirstSeen.Before(target.FirstSeen) {
+			target.FirstSeen = source.FirstSeen
+		}
+		target.Merge(source)
+	}
+}
+
+// persistMerges points the ids and sessions of merged persons to the person
+// they were merged into.
+func persistMerges(tx execer, merges []appliedMerge) error {
+	now := time.Now().UTC()
+	for _, merge := range merges {
+		if _, err := tx.Exec("UPDATE person_distinct_ids SET person_id = $2, merged_at = $3 WHERE person_id = $1", merge.from, merge.to, now); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO person_distinct_ids (distinct_id, person_id, merged_at) VALUES ($1, $2, $3)", merge.from, merge.to, now); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE sessions SET person_id = $2 WHERE person_id = $1", merge.from, merge.to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidateMergedPersons marks the parquet files with events of persons that
+// other persons were merged into as stale, as their events were exported
+// under the ids of the merged persons.
+func (p *ProjectProcessor) invalidateMergedPersons(merges []appliedMerge) {
+	if len(merges) == 0 {
+		return
+	}
+	targets := make(types.StringList, 0, len(merges))
+	for _, merge := range merges {
+		targets = append(targets, merge.to)
+	}
+
+	tx, err := p.dbd.Tx()
+	if err != nil {
+		log.Error("Project %s: Error reading events of merged persons: %v", p.projectID, err)
+		return
+	}
+	defer tx.Commit()
+	rows, err := tx.Query(`
+		SELECT min(events.timestamp), max(events.timestamp)
+		FROM events events
+		LEFT JOIN sessions sessions ON sessions.id = events.session_id
+		LEFT JOIN person_distinct_ids merged ON merged.distinct_id = coalesce(events.person_id, sessions.person_id, events.session_id)
+		WHERE list_contains($1::TEXT[], coalesce(merged.person_id, events.person_id, sessions.person_id))
+		GROUP BY CAST(events.timestamp AS DATE)
+	`, targets)
+	if err != nil {
+		log.Error("Project %s: Error reading events of merged persons: %v", p.projectID, err)
+		return
+	}
+	defer rows.Close()
+
+	timestamps := make([]time.Time, 0)
+	for rows.Next() {
+		var first, last time.Time
+		if err := rows.Scan(&first, &last); err != nil {
+			log.Error("Project %s: Error reading events of merged persons: %v", p.projectID, err)
+			return
+		}
+		timestamps = append(timestamps, first, last)
+	}
+	invalidated, err := filecatalog.InvalidateSegments(p.db, timestamps, time.Now())
+	if err != nil {
+		log.Error("Project %s: Error invalidating parquet files: %v", p.projectID, err)
+		return
+	}
+	if invalidated > 0 {
+		log.Info("Project %s: Merged persons made %d parquet files stale", p.projectID, invalidated)
+		parquet.ScheduleRegeneration(p.projectID, p.db)
+	}
+}
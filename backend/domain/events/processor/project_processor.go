@@ -1,19 +1,73 @@
 package processor
 
 import (
+	"analytics/config"
 	"analytics/database/analyticsdb"
 	"analytics/database/appdb"
 	"analytics/domain/events"
+	"analytics/domain/projects"
+	"analytics/log"
+	"context"
+	"errors"
 	"fmt"
 	"gorm.io/gorm"
+	"strconv"
 	"sync"
 )
 
 type ProjectProcessor struct {
-	projectID  string
-	db         *gorm.DB
-	dbd        analyticsdb.DuckDB
-	eventQueue chan *events.EventInput
+	projectID string
+	db        *gorm.DB
+	dbd       analyticsdb.DuckDB
+	wal       *writeAheadLog
+	// held are the queue batches that could neither be stored nor moved to
+	// the dead letters, in the order of the log. They are tried again before
+	// the next batch and the log is not committed past the first of them.
+	// Only the queue worker uses them.
+	held [][]queuedEvent
+	// erased maps the ids of erased persons and sessions to the last log
+	// record that can hold events of them from before the erasure. Those
+	// events are dropped. Only used while holding the processing lock.
+	erased    map[string]uint64
+	recentIds *recentIds
+	enqueue   sync.Mutex
+	// processing serializes the batches of the queue worker and of imports.
+	processing sync.Mutex
+	// schemaRecovery is set while schema updates may be pending, which is
+	// the case after a start and after a failed update.
+	schemaRecovery bool
+	// groupTypes are the group types known to be registered.
+	groupTypes map[string]bool
+	stopped    bool
+	eventQueue chan queuedEvent
+	// resized hands the queue that replaced eventQueue to the worker, which
+	// empties the previous queue before it switches.
+	resized chan chan queuedEvent
+	resize  sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// queuedEvent ties an event to the write-ahead log record it was accepted in.
+// recordEnd marks the last event of that record, which is the point at which
+// the record can be committed.
+type queuedEvent struct {
+	event     *events.EventInput
+	walSeq    uint64
+	recordEnd bool
+}
+
+const defaultQueueCapacity = 1000
+
+var (
+	ErrProcessorStopped = errors.New("event processor is shutting down")
+	ErrQueueFull        = errors.New("event queue is full")
+	ErrBatchTooLarge    = errors.New("batch exceeds event queue capacity")
+)
+
+type QueueStats struct {
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
 }
 
 var (
@@ -39,18 +93,148 @@ func GetOrCreateProcessor(projectID string) *ProjectProcessor {
 		panic(fmt.Sprintf("Project %s not found", projectID))
 	}
 
-	proc := NewProjectProcessor(projectID, db, dbd)
+	proc := newProjectProcessor(projectID, db, dbd, queueCapacity(projectID, db))
+	proc.recentIds = newRecentIds(config.Config.Ingestion.DedupWindow)
+	wal, err := openWAL(walDirectory(projectID))
+	if err != nil {
+		log.Fatal("Project %s: Error opening write-ahead log: %v", projectID, err)
+	}
+	proc.wal = wal
+
 	go proc.processEventQueue()
-	processors[projectID] = &proc
+	processors[projectID] = proc
+
+	return proc
+}
+
+// StartProcessors creates the processors of all known projects so events left
+// in their write-ahead logs are replayed right away instead of on first use.
+func StartProcessors(projectDbs *appdb.ProjectDBLookup) {
+	for projectID := range *projectDbs {
+		GetOrCreateProcessor(projectID)
+	}
+}
+
+func NewProjectProcessor(projectID string, db *gorm.DB, dbd analyticsdb.DuckDB) *ProjectProcessor {
+	return newProjectProcessor(projectID, db, dbd, defaultQueueCapacity)
+}
+
+func newProjectProcessor(projectID string, db *gorm.DB, dbd analyticsdb.DuckDB, capacity int) *ProjectProcessor {
+	return &ProjectProcessor{
+		projectID: projectID,
+		db:        db,
+		dbd:       dbd,
+		recentIds: newRecentIds(0),
+		// Updates may be left behind by the previous run.
+		schemaRecovery: true,
+		groupTypes:     make(map[string]bool),
+		eventQueue:     make(chan queuedEvent, capacity),
+		resized:        make(chan chan queuedEvent),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+// queueCapacity resolves the queue size of a project from its settings and
+// falls back to the configured default.
+func queueCapacity(projectID string, db *gorm.DB) int {
+	capacity := config.Config.Ingestion.QueueCapacity
+	if capacity <= 0 {
+		capacity = defaultQueueCapacity
+	}
+	settings, err := projects.QuerySettings(projectID, db)
+	if err != nil {
+		log.Warn("Project %s: Could not read queue capacity setting: %v", projectID, err)
+		return capacity
+	}
+	if value := settings[projects.QueueCapacity]; value != "" {
+		override, err := strconv.Atoi(value)
+		if err != nil || override <= 0 {
+			log.Warn("Invalid queue capacity: %s should be a positive integer", value)
+			return capacity
+		}
+		capacity = override
+	}
+	return capacity
+}
+
+// UpdateQueueCapacity applies a changed queue capacity setting of a project
+// to its running queue.
+func UpdateQueueCapacity(projectID string) {
+	proc := GetOrCreateProcessor(projectID)
+	proc.resizeQueue(queueCapacity(projectID, proc.db))
+}
+
+// resizeQueue replaces the queue with one of the given capacity. Queued events
+// stay in the previous queue until the worker has taken them, so events are
+// processed in the order they were accepted.
+func (p *ProjectProcessor) resizeQueue(capacity int) {
+	p.resize.Lock()
+	defer p.resize.Unlock()
+
+	p.enqueue.Lock()
+	if p.stopped || capacity == cap(p.eventQueue) {
+		p.enqueue.Unlock()
+		return
+	}
+	queue := make(chan queuedEvent, capacity)
+	p.eventQueue = queue
+	p.enqueue.Unlock()
+
+	select {
+	case p.resized <- queue:
+		log.Info("Project %s: Resized event queue to %d events", p.projectID, capacity)
+	case <-p.done:
+	}
+}
 
-	return &proc
+// Stats reports how many events are waiting in the queue.
+func (p *ProjectProcessor) Stats() QueueStats {
+	p.enqueue.Lock()
+	defer p.enqueue.Unlock()
+	return QueueStats{
+		Depth:    len(p.eventQueue),
+		Capacity: cap(p.eventQueue),
+	}
+}
+
+// Stop rejects new events and lets the queue worker flush everything that is
+// already queued. Wait blocks until that has finished.
+func (p *ProjectProcessor) Stop() {
+	p.enqueue.Lock()
+	defer p.enqueue.Unlock()
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	close(p.stop)
 }
 
-func NewProjectProcessor(projectID string, db *gorm.DB, dbd analyticsdb.DuckDB) ProjectProcessor {
-	return ProjectProcessor{
-		projectID:  projectID,
-		db:         db,
-		dbd:        dbd,
-		eventQueue: make(chan *events.EventInput, 1000),
+func (p *ProjectProcessor) Wait(ctx context.Context) error {
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StopProcessors stops all processors and waits for their queues to drain.
+// Events that are not persisted before ctx expires remain in the write-ahead
+// log and are replayed on the next start.
+func StopProcessors(ctx context.Context) error {
+	processorsLock.Lock()
+	defer processorsLock.Unlock()
+
+	for _, proc := range processors {
+		proc.Stop()
+	}
+	var result error
+	for projectID, proc := range processors {
+		if err := proc.Wait(ctx); err != nil {
+			log.Warn("Project %s: Queue not drained before shutdown deadline", projectID)
+			result = err
+		}
 	}
+	return result
 }
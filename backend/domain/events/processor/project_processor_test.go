@@ -0,0 +1,48 @@
+package processor
+
+import (
+	"analytics/domain/events"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestEnqueueRejectsEventsWhenQueueIsFull(t *testing.T) {
+	processor := newProjectProcessor("backpressure-test", nil, nil, 2)
+
+	err := processor.enqueueEvents([]*events.EventInput{{EventType: "a"}, {EventType: "b"}, {EventType: "c"}})
+	assert.Equal(t, ErrBatchTooLarge, err)
+
+	err = processor.enqueueEvents([]*events.EventInput{{EventType: "a"}, {EventType: "b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, QueueStats{Depth: 2, Capacity: 2}, processor.Stats())
+
+	err = processor.enqueueEvents([]*events.EventInput{{EventType: "c"}})
+	assert.Equal(t, ErrQueueFull, err)
+
+	processor.Stop()
+	err = processor.enqueueEvents([]*events.EventInput{{EventType: "d"}})
+	assert.Equal(t, ErrProcessorStopped, err)
+}
+
+func TestResizedQueueKeepsQueuedEventsForTheWorker(t *testing.T) {
+	processor := newProjectProcessor("resize-test", nil, nil, 2)
+	err := processor.enqueueEvents([]*events.EventInput{{EventType: "a"}, {EventType: "b"}})
+	assert.NoError(t, err)
+	previous := processor.eventQueue
+
+	switched := make(chan chan queuedEvent, 1)
+	go func() { switched <- <-processor.resized }()
+	processor.resizeQueue(3)
+	assert.Equal(t, QueueStats{Depth: 0, Capacity: 3}, processor.Stats())
+	assert.Equal(t, processor.eventQueue, <-switched)
+
+	err = processor.enqueueEvents([]*events.EventInput{{EventType: "c"}, {EventType: "d"}, {EventType: "e"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "a", (<-previous).event.EventType)
+	assert.Equal(t, "b", (<-previous).event.EventType)
+
+	processor.Stop()
+	processor.resizeQueue(5)
+	assert.Equal(t, 3, processor.Stats().Capacity)
+}
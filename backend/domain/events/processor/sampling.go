@@ -0,0 +1,41 @@
+package processor
+
+import (
+	"analytics/domain/events"
+	"analytics/domain/sampling"
+	"analytics/log"
+)
+
+// applySampling keeps the configured share of the events of sampled event
+// types and stores on each kept event the weight that scales counts back up.
+func (p *ProjectProcessor) applySampling(input []*events.EventInput) ([]*events.EventInput, error) {
+	rules, err := sampling.ListRules(p.db)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return input, nil
+	}
+	rates := make(map[string]float64, len(rules))
+	for _, rule := range rules {
+		rates[rule.EventType] = rule.Rate
+	}
+
+	result := make([]*events.EventInput, 0, len(input))
+	for _, event := range input {
+		rate, sampled := rates[event.EventType]
+		if !sampled || rate >= 1 {
+			result = append(result, event)
+			continue
+		}
+		if !sampling.Keep(event, rate) {
+			continue
+		}
+		sampling.SetWeight(event, rate)
+		result = append(result, event)
+	}
+	if dropped := len(input) - len(result); dropped > 0 {
+		log.Info("Project %s: Sampled out %d events", p.projectID, dropped)
+	}
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"analytics/domain/deadletters"
+	"analytics/domain/events"
+	"analytics/domain/trackingplan"
+	"errors"
+	"strings"
+	"time"
+)
+
+// quarantinedEvent is an event that violated the tracking plan in quarantine
+// mode, held back until the property policies were applied to it.
+type quarantinedEvent struct {
+	event *events.EventInput
+	cause error
+}
+
+// checkTrackingPlan checks the events against the project's tracking plan. It
+// runs after the transformation rules, so rules can fix events that violate
+// the plan. In allow mode the violations are listed in the
+// ViolationsProperty of the event. In quarantine mode violating events are
+// removed from the batch and returned. Plans in reject mode were checked when
+// the events were received.
+func (p *ProjectProcessor) checkTrackingPlan(input []*events.EventInput, now time.Time) ([]*events.EventInput, []quarantinedEvent, error) {
+	plan, err := trackingplan.GetPlan(p.db)
+	if err != nil {
+		return nil, nil, err
+	}
+	checker := trackingplan.NewChecker(plan)
+	if checker == nil || checker.Mode() == trackingplan.Reject {
+		return input, nil, nil
+	}
+
+	result := make([]*events.EventInput, 0, len(input))
+	var quarantined []quarantinedEvent
+	for _, event := range input {
+		violations := checker.Check(event)
+		if len(violations) == 0 {
+			result = append(result, event)
+			continue
+		}
+		trackingplan.Record(p.projectID, now, event, violations)
+		messages := trackingplan.Messages(violations)
+		if checker.Mode() == trackingplan.Quarantine {
+			quarantined = append(quarantined, quarantinedEvent{event: event, cause: errors.New(strings.Join(messages, "; "))})
+			continue
+		}
+		flagged := make([]any, len(messages))
+		for i, message := range messages {
+			flagged[i] = message
+		}
+		event.Properties[trackingplan.ViolationsProperty] = flagged
+		result = append(result, event)
+	}
+	return result, quarantined, nil
+}
+
+// saveQuarantined keeps the quarantined events of a batch as dead letters.
+func (p *ProjectProcessor) saveQuarantined(quarantined []quarantinedEvent) error {
+	letters := make([]deadletters.DeadLetter, len(quarantined))
+	for i, held := range quarantined {
+		letters[i] = deadletters.Quarantine(held.event, held.cause)
+	}
+	return p.saveDeadLetters(letters)
+}
@@ -0,0 +1,32 @@
+package processor
+
+import (
+	"analytics/domain/events"
+	"analytics/domain/transformations"
+	"analytics/log"
+)
+
+// applyTransformations runs the project's transformation rules on the events
+// and removes the events dropped by them. It runs before the schemas are
+// merged, so the schemas only see transformed events.
+func (p *ProjectProcessor) applyTransformations(input []*events.EventInput) ([]*events.EventInput, error) {
+	rules, err := transformations.ListRules(p.db)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return input, nil
+	}
+
+	result := make([]*events.EventInput, 0, len(input))
+	for _, event := range input {
+		if _, dropped := transformations.Apply(rules, event); dropped {
+			continue
+		}
+		result = append(result, event)
+	}
+	if dropped := len(input) - len(result); dropped > 0 {
+		log.Info("Project %s: Dropped %d events by transformation rules", p.projectID, dropped)
+	}
+	return result, nil
+}
@@ -0,0 +1,346 @@
+package processor
+
+import (
+	"analytics/config"
+	"analytics/domain/events"
+	"analytics/log"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	walSuffix         = ".wal"
+	walCheckpointFile = "checkpoint"
+)
+
+// writeAheadLog is an append-only, per-project log of accepted event batches.
+// Every batch is written and synced before it is acknowledged, so events that
+// are still queued in memory survive a crash and are replayed on startup.
+//
+// The log is split into segments named after the first sequence number they
+// contain. Once a batch has been persisted, its sequence number is written to
+// a checkpoint file and every segment that only holds committed records is
+// removed.
+type writeAheadLog struct {
+	mu        sync.Mutex
+	dir       string
+	file      *os.File
+	segment   uint64
+	seq       uint64
+	committed uint64
+	segments  map[uint64]uint64 // first seq -> last seq
+}
+
+type walRecord struct {
+	Seq    uint64               `json:"seq"`
+	Events []*events.EventInput `json:"events"`
+}
+
+func walDirectory(projectID string) string {
+	return filepath.Join(config.Config.Paths.Database, "wal", projectID)
+}
+
+func openWAL(dir string) (*writeAheadLog, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	wal := &writeAheadLog{
+		dir:      dir,
+		segments: make(map[uint64]uint64),
+	}
+	return wal, nil
+}
+
+// Append writes a batch as a single record and syncs it to disk. It returns
+// the sequence number assigned to the record.
+func (w *writeAheadLog) Append(batch []*events.EventInput) (uint64, error) {
+	if w == nil {
+		return 0, nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seq := w.seq + 1
+	line, err := json.Marshal(walRecord{Seq: seq, Events: batch})
+	if err != nil {
+		return 0, err
+	}
+
+	if w.file == nil {
+		if err := w.openSegment(seq); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := w.file.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	if err := w.file.Sync(); err != nil {
+		return 0, err
+	}
+
+	w.seq = seq
+	w.segments[w.segment] = seq
+	return seq, nil
+}
+
+// Commit marks every record up to and including seq as persisted and removes
+// segments that no longer contain uncommitted records.
+func (w *writeAheadLog) Commit(seq uint64) error {
+	if w == nil || seq == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if seq <= w.committed {
+		return nil
+	}
+	if err := w.writeCheckpoint(seq); err != nil {
+		return err
+	}
+	w.committed = seq
+
+	// Rotate to a fresh segment for new records, so the active segment can be
+	// removed as soon as everything written to it has been committed.
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+
+	for first, last := range w.segments {
+		if last > seq {
+			continue
+		}
+		if err := os.Remove(w.segmentPath(first)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(w.segments, first)
+	}
+	return nil
+}
+
+// Replay reads all records left over from a previous run in sequence order.
+// A truncated trailing record from an interrupted write is skipped.
+func (w *writeAheadLog) Replay() ([]walRecord, error) {
+	if w == nil {
+		return nil, nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	committed, err := w.readCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	w.committed = max(w.committed, committed)
+	w.seq = max(w.seq, committed)
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), walSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), walSuffix), 10, 64)
+		if err != nil {
+			log.Warn("WAL %s: Ignoring unexpected file %s", w.dir, entry.Name())
+			continue
+		}
+		segments = append(segments, first)
+	}
+	slices.Sort(segments)
+
+	records := make([]walRecord, 0)
+	for _, first := range segments {
+		segmentRecords, err := w.readSegment(first)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		for _, record := range segmentRecords {
+			last = max(last, record.Seq)
+			w.seq = max(w.seq, record.Seq)
+			if record.Seq > w.committed {
+				records = append(records, record)
+			}
+		}
+		w.segments[first] = last
+	}
+	return records, nil
+}
+
+// Erase removes the events matched by erased from the records that were not
+// committed yet, rewriting their segments. Records keep their sequence number,
+// also when no events are left. It returns the number of removed events and
+// the sequence number of the last record, which is the last one that can
+// contain erased events.
+func (w *writeAheadLog) Erase(erased func(*events.EventInput) bool) (int64, uint64, error) {
+	if w == nil {
+		return 0, 0, nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// The active segment is replaced, new records go to a fresh one.
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return 0, 0, err
+		}
+		w.file = nil
+	}
+
+	var removed int64
+	for first, last := range w.segments {
+		if last <= w.committed {
+			continue
+		}
+		records, err := w.readSegment(first)
+		if err != nil {
+			return removed, 0, err
+		}
+		changed := false
+		for i, record := range records {
+			kept := slices.DeleteFunc(record.Events, erased)
+			if len(kept) < len(record.Events) {
+				removed += int64(len(record.Events) - len(kept))
+				changed = true
+			}
+			records[i].Events = kept
+		}
+		if changed {
+			if err := w.rewriteSegment(first, records); err != nil {
+				return removed, 0, err
+			}
+		}
+	}
+	return removed, w.seq, nil
+}
+
+// rewriteSegment replaces a segment atomically.
+func (w *writeAheadLog) rewriteSegment(first uint64, records []walRecord) error {
+	tmp := w.segmentPath(first) + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if _, err := writer.Write(append(line, '\n')); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.segmentPath(first))
+}
+
+func (w *writeAheadLog) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *writeAheadLog) readSegment(first uint64) ([]walRecord, error) {
+	file, err := os.Open(w.segmentPath(first))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := make([]walRecord, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Warn("WAL %s: Skipping unreadable record in segment %d: %v", w.dir, first, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+func (w *writeAheadLog) openSegment(first uint64) error {
+	file, err := os.OpenFile(w.segmentPath(first), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.segment = first
+	return nil
+}
+
+func (w *writeAheadLog) segmentPath(first uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", first, walSuffix))
+}
+
+func (w *writeAheadLog) readCheckpoint() (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(w.dir, walCheckpointFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// writeCheckpoint replaces the checkpoint atomically, so a crash never leaves
+// a partially written sequence number behind.
+func (w *writeAheadLog) writeCheckpoint(seq uint64) error {
+	tmp := filepath.Join(w.dir, walCheckpointFile+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(strconv.FormatUint(seq, 10)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(w.dir, walCheckpointFile))
+}
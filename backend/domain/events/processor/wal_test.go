@@ -0,0 +1,41 @@
+package processor
+
+import (
+	"analytics/domain/events"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestWALReplaysOnlyUncommittedRecords(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := openWAL(dir)
+	assert.NoError(t, err)
+
+	first, err := wal.Append([]*events.EventInput{{EventType: "first"}})
+	assert.NoError(t, err)
+	_, err = wal.Append([]*events.EventInput{{EventType: "second"}, {EventType: "third"}})
+	assert.NoError(t, err)
+	assert.NoError(t, wal.Commit(first))
+	_, err = wal.Append([]*events.EventInput{{EventType: "fourth"}})
+	assert.NoError(t, err)
+	assert.NoError(t, wal.Close())
+
+	reopened, err := openWAL(dir)
+	assert.NoError(t, err)
+	records, err := reopened.Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "second", records[0].Events[0].EventType)
+	assert.Equal(t, "third", records[0].Events[1].EventType)
+	assert.Equal(t, "fourth", records[1].Events[0].EventType)
+
+	next, err := reopened.Append([]*events.EventInput{{EventType: "fifth"}})
+	assert.NoError(t, err)
+	assert.True(t, next > records[1].Seq)
+
+	assert.NoError(t, reopened.Commit(next))
+	records, err = reopened.Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(records))
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"analytics/database/analyticsdb"
+)
+
+// storedPropertiesSQL reads the stored properties as an object, whether they
+// were stored as an object or as a string holding the encoded object.
+const storedPropertiesSQL = "json(json_extract_string(properties, '$'))"
+
+// PropertyValuesInUse returns which of the values of a property events of the
+// type still have. Values are compared as the schemas format them, values
+// formatted differently, e.g. lists, are reported as not in use.
+func PropertyValuesInUse(dbd analyticsdb.DuckDB, eventType string, key string, values []string) ([]string, error) {
+	inUse := make([]string, 0)
+	if len(values) == 0 {
+		return inUse, nil
+	}
+	tx, err := dbd.Tx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	rows, err := tx.Query(`
+		SELECT DISTINCT value FROM (
+			SELECT json_extract_string(`+storedPropertiesSQL+`, $1) AS value
+			FROM events
+			WHERE event_type = $2
+		)
+		WHERE list_contains($3, value)
+	`, `$."`+key+`"`, eventType, values)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		inUse = append(inUse, value)
+	}
+	return inUse, rows.Err()
+}
@@ -40,6 +40,49 @@ func QueryEvents(dbd analyticsdb.DuckDB, params *queries.QueryParams) (*[]EventO
 	return events, nil
 }
 
+// QueryPersonTimeline returns a page of the events of a person, the latest
+// first, and the number of all its events. Events of the ids merged into the
+// person and of its sessions before it was identified are included.
+func QueryPersonTimeline(dbd analyticsdb.DuckDB, personId string, limit int, offset int) (*[]EventOutput, int64, error) {
+	tx, err := dbd.Tx()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Commit()
+
+	var total int64
+	countQuery := "select count(*) " + queries.EventsFromSQL + " where " + queries.PersonIdSQL + " = $1"
+	if err := tx.QueryRow(countQuery, personId).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := tx.Query(`
+select events.id,
+       events.timestamp,
+       events.event_type,
+       events.session_id,
+       `+queries.PersonIdSQL+` as person_id,
+       events.properties
+`+queries.EventsFromSQL+`
+where `+queries.PersonIdSQL+` = $1
+order by events.timestamp desc, events.id
+limit $2 offset $3
+`, personId, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events, err := parseEvents(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	if *events == nil {
+		*events = make([]EventOutput, 0)
+	}
+	return events, total, nil
+}
+
 func parseEvents(rows *sql.Rows) (*[]EventOutput, error) {
 	var resultSet []EventOutput
 	for rows.Next() {
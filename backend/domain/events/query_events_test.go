@@ -33,6 +33,12 @@ create table sessions
     first_seen timestamp not null,
     last_seen  timestamp not null
 );
+create table person_distinct_ids
+(
+    distinct_id text primary key,
+    person_id   text      not null,
+    merged_at   timestamp not null
+);
 insert into sessions values ('session-1', 'person-1', now(), now());
 insert into events values (uuid(), now(), 'click', 'session-1', null, '{"path":"/docs","count":2}', '{}');
 `)
@@ -0,0 +1,46 @@
+package segment
+
+import "encoding/json"
+
+// Message is a single call of the Segment HTTP Tracking API. The fields that
+// are only used by some call types are empty for the others.
+type Message struct {
+	Type              string          `json:"type"`
+	MessageId         string          `json:"messageId"`
+	UserId            json.RawMessage `json:"userId"`
+	AnonymousId       json.RawMessage `json:"anonymousId"`
+	PreviousId        json.RawMessage `json:"previousId"`
+	GroupId           json.RawMessage `json:"groupId"`
+	Event             string          `json:"event"`
+	Name              string          `json:"name"`
+	Category          string          `json:"category"`
+	Properties        map[string]any  `json:"properties"`
+	Traits            map[string]any  `json:"traits"`
+	Context           map[string]any  `json:"context"`
+	Timestamp         string          `json:"timestamp"`
+	OriginalTimestamp string          `json:"originalTimestamp"`
+	SentAt            string          `json:"sentAt"`
+	WriteKey          string          `json:"writeKey"`
+}
+
+// BatchPayload is the body of the batch endpoint. Its context is shared by all
+// messages of the batch.
+type BatchPayload struct {
+	Batch    []Message      `json:"batch"`
+	Context  map[string]any `json:"context"`
+	SentAt   string         `json:"sentAt"`
+	WriteKey string         `json:"writeKey"`
+}
+
+type Response struct {
+	Success bool `json:"success"`
+}
+
+const (
+	TypeTrack    = "track"
+	TypeIdentify = "identify"
+	TypePage     = "page"
+	TypeScreen   = "screen"
+	TypeAlias    = "alias"
+	TypeGroup    = "group"
+)
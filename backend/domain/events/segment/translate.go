@@ -0,0 +1,215 @@
+package segment
+
+import (
+	"analytics/domain/events"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+var eventTypes = map[string]string{
+	TypeIdentify: "$identify",
+	TypePage:     "$pageview",
+	TypeScreen:   "$screen",
+	TypeAlias:    "$alias",
+	TypeGroup:    "$groupidentify",
+}
+
+// contextProperties maps the context fields set by the Segment libraries to
+// the property names used by the PostHog integration, so that events of both
+// sources can be queried the same way.
+var contextProperties = map[string]string{
+	"ip":        "$ip",
+	"userAgent": "$user_agent",
+	"locale":    "$locale",
+	"timezone":  "$timezone",
+}
+
+var nestedContextProperties = map[string]map[string]string{
+	"page":     {"url": "$current_url", "path": "$pathname", "referrer": "$referrer", "title": "$title"},
+	"library":  {"name": "$lib", "version": "$lib_version"},
+	"app":      {"name": "$app_name", "version": "$app_version", "build": "$app_build"},
+	"os":       {"name": "$os", "version": "$os_version"},
+	"device":   {"type": "$device_type", "manufacturer": "$device_manufacturer", "model": "$device_model"},
+	"screen":   {"width": "$screen_width", "height": "$screen_height"},
+	"campaign": {"name": "utm_campaign", "source": "utm_source", "medium": "utm_medium", "term": "utm_term", "content": "utm_content"},
+}
+
+// ToEventInput maps a Segment call onto an event. The userId becomes the
+// person id and the anonymousId the session id, so that sending both links the
+// anonymous history to the person. Traits become person properties, except
+// for group calls where they describe the group.
+func ToEventInput(message Message, batchContext map[string]any) (*events.EventInput, *events.ValidationError) {
+	messageType := strings.ToLower(strings.TrimSpace(message.Type))
+	if messageType == "" {
+		return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: "type is required"}
+	}
+	eventType, ok := eventTypes[messageType]
+	if messageType == TypeTrack {
+		if strings.TrimSpace(message.Event) == "" {
+			return nil, &events.ValidationError{Reason: events.MissingEventType, Message: "event is required"}
+		}
+		eventType, ok = message.Event, true
+	}
+	if !ok {
+		return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: fmt.Sprintf("type %q is not supported", message.Type)}
+	}
+
+	userId, err := parseId("userId", message.UserId)
+	if err != nil {
+		return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: err.Error()}
+	}
+	anonymousId, err := parseId("anonymousId", message.AnonymousId)
+	if err != nil {
+		return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: err.Error()}
+	}
+
+	context := mergeMaps(batchContext, message.Context)
+	input := &events.EventInput{
+		EventType:  eventType,
+		Properties: contextToProperties(context),
+	}
+	for key, value := range message.Properties {
+		input.Properties[key] = value
+	}
+
+	personProperties, _ := context["traits"].(map[string]any)
+	switch messageType {
+	case TypeIdentify:
+		personProperties = mergeMaps(personProperties, message.Traits)
+	case TypePage, TypeScreen:
+		if message.Name != "" {
+			input.Properties["name"] = message.Name
+		}
+		if message.Category != "" {
+			input.Properties["category"] = message.Category
+		}
+	case TypeGroup:
+		groupId, err := parseId("groupId", message.GroupId)
+		if err != nil || groupId == "" {
+			return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: "groupId is required"}
+		}
+		input.Properties["$group_id"] = groupId
+		if len(message.Traits) > 0 {
+			input.Properties["$group_set"] = message.Traits
+		}
+	case TypeAlias:
+		previousId, err := parseId("previousId", message.PreviousId)
+		if err != nil || previousId == "" {
+			return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: "previousId is required"}
+		}
+		if userId == "" {
+			return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: "userId is required"}
+		}
+		anonymousId = previousId
+	}
+	if len(personProperties) > 0 {
+		input.PersonProperties = personProperties
+	}
+
+	if userId == "" && anonymousId == "" {
+		return nil, &events.ValidationError{Reason: events.InvalidEvent, Message: "userId or anonymousId is required"}
+	}
+	if userId != "" {
+		input.PersonId = &userId
+	}
+	if anonymousId != "" {
+		input.SessionId = &anonymousId
+	}
+
+	timestamp, err := parseTimestamp(message)
+	if err != nil {
+		return nil, &events.ValidationError{Reason: events.InvalidTimestamp, Message: err.Error()}
+	}
+	input.Timestamp = timestamp
+
+	id := messageUuid(message.MessageId)
+	input.Uuid = &id
+
+	return input, nil
+}
+
+func contextToProperties(context map[string]any) map[string]any {
+	properties := make(map[string]any)
+	remaining := make(map[string]any)
+	for key, value := range context {
+		if key == "traits" {
+			continue
+		}
+		if name, ok := contextProperties[key]; ok {
+			properties[name] = value
+			continue
+		}
+		nested, isMap := value.(map[string]any)
+		names, ok := nestedContextProperties[key]
+		if !ok || !isMap {
+			remaining[key] = value
+			continue
+		}
+		for field, fieldValue := range nested {
+			if name, ok := names[field]; ok {
+				properties[name] = fieldValue
+			}
+		}
+	}
+	if len(remaining) > 0 {
+		properties["$context"] = remaining
+	}
+	return properties
+}
+
+func parseId(field string, raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return strings.TrimSpace(value), nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err == nil {
+		return number.String(), nil
+	}
+	return "", errors.New(field + " must be a string or a number")
+}
+
+func parseTimestamp(message Message) (time.Time, error) {
+	value := message.Timestamp
+	if value == "" {
+		value = message.OriginalTimestamp
+	}
+	if value == "" {
+		return time.Time{}, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timestamp %q is not in ISO 8601 format", value)
+	}
+	return timestamp, nil
+}
+
+// messageUuid keeps message ids that are uuids and derives one from any other
+// message id, so that retried messages are deduplicated.
+func messageUuid(messageId string) uuid.UUID {
+	if messageId == "" {
+		return uuid.New()
+	}
+	if id, err := uuid.Parse(messageId); err == nil && id != uuid.Nil {
+		return id
+	}
+	return events.DedupKeyUuid(messageId)
+}
+
+func mergeMaps(base map[string]any, override map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,61 @@
+package segment
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestToEventInputMapsCalls(t *testing.T) {
+	var payload BatchPayload
+	err := json.Unmarshal([]byte(`{
+		"context": {"library": {"name": "analytics-node", "version": "1.0"}},
+		"batch": [
+			{"type": "track", "event": "Order Completed", "userId": "user", "anonymousId": "anon",
+			 "messageId": "msg-1", "timestamp": "2025-01-01T12:00:00Z",
+			 "properties": {"total": 10}, "context": {"ip": "1.2.3.4", "traits": {"plan": "pro"}}},
+			{"type": "identify", "userId": 42, "traits": {"email": "test@example.com"}},
+			{"type": "page", "anonymousId": "anon", "name": "Home", "context": {"page": {"url": "https://example.com"}}},
+			{"type": "alias", "userId": "user", "previousId": "anon"},
+			{"type": "track", "userId": "user"}
+		]
+	}`), &payload)
+	assert.NoError(t, err)
+
+	track, validationErr := ToEventInput(payload.Batch[0], payload.Context)
+	assert.Nil(t, validationErr)
+	assert.Equal(t, "Order Completed", track.EventType)
+	assert.Equal(t, "user", *track.PersonId)
+	assert.Equal(t, "anon", *track.SessionId)
+	assert.Equal(t, 10.0, track.Properties["total"])
+	assert.Equal(t, "1.2.3.4", track.Properties["$ip"])
+	assert.Equal(t, "analytics-node", track.Properties["$lib"])
+	assert.Equal(t, "pro", track.PersonProperties["plan"])
+	assert.Equal(t, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), track.Timestamp)
+
+	retried, _ := ToEventInput(payload.Batch[0], payload.Context)
+	assert.Equal(t, *track.Uuid, *retried.Uuid)
+
+	identify, validationErr := ToEventInput(payload.Batch[1], payload.Context)
+	assert.Nil(t, validationErr)
+	assert.Equal(t, "$identify", identify.EventType)
+	assert.Equal(t, "42", *identify.PersonId)
+	assert.Equal(t, "test@example.com", identify.PersonProperties["email"])
+
+	page, validationErr := ToEventInput(payload.Batch[2], payload.Context)
+	assert.Nil(t, validationErr)
+	assert.Equal(t, "$pageview", page.EventType)
+	assert.Nil(t, page.PersonId)
+	assert.Equal(t, "Home", page.Properties["name"])
+	assert.Equal(t, "https://example.com", page.Properties["$current_url"])
+
+	alias, validationErr := ToEventInput(payload.Batch[3], payload.Context)
+	assert.Nil(t, validationErr)
+	assert.Equal(t, "user", *alias.PersonId)
+	assert.Equal(t, "anon", *alias.SessionId)
+
+	_, validationErr = ToEventInput(payload.Batch[4], payload.Context)
+	assert.NotNil(t, validationErr)
+}
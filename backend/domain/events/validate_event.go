@@ -0,0 +1,272 @@
+package events
+
+import (
+	"analytics/domain/groups"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+type RejectionReason string
+
+const (
+	InvalidEvent        RejectionReason = "invalid_event"
+	UnknownField        RejectionReason = "unknown_field"
+	MissingEventType    RejectionReason = "missing_event_type"
+	InvalidTimestamp    RejectionReason = "invalid_timestamp"
+	InvalidUuid         RejectionReason = "invalid_uuid"
+	PropertiesTooLarge  RejectionReason = "properties_too_large"
+	TimestampOutOfRange RejectionReason = "timestamp_out_of_range"
+	// TrackingPlanViolation rejects events that violate the tracking plan of
+	// a project in reject mode.
+	TrackingPlanViolation RejectionReason = "tracking_plan_violation"
+)
+
+var dedupNamespace = uuid.MustParse("5c5b9c52-7b5f-4f0e-9a43-3f0f6f2b7a61")
+
+// ValidationError describes why a single event of a batch was rejected.
+type ValidationError struct {
+	Reason  RejectionReason
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// EventResult is the outcome of ingesting the event at Index of a batch.
+type EventResult struct {
+	Index    int             `json:"index"`
+	Accepted bool            `json:"accepted"`
+	Id       *uuid.UUID      `json:"id,omitempty"`
+	Reason   RejectionReason `json:"reason,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+type IngestionResponse struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Results  []EventResult `json:"results"`
+}
+
+// rawEventInput mirrors the client facing fields of EventInput, but keeps the
+// timestamp and uuid raw so their parse errors can be told apart from other
+// errors.
+type rawEventInput struct {
+	Uuid                   json.RawMessage           `json:"uuid"`
+	DedupKey               string                    `json:"dedupKey"`
+	EventType              string                    `json:"eventType"`
+	SessionId              *string                   `json:"sessionId"`
+	PersonId               *string                   `json:"personId"`
+	Timestamp              json.RawMessage           `json:"timestamp"`
+	Properties             map[string]any            `json:"properties"`
+	PersonProperties       map[string]any            `json:"personProperties"`
+	PersonPropertiesOnce   map[string]any            `json:"personPropertiesOnce"`
+	PersonPropertiesUnset  []string                  `json:"personPropertiesUnset"`
+	PersonPropertiesAdd    map[string]float64        `json:"personPropertiesAdd"`
+	PersonPropertiesAppend map[string]any            `json:"personPropertiesAppend"`
+	PersonPropertiesUnion  map[string]any            `json:"personPropertiesUnion"`
+	Groups                 map[string]string         `json:"groups"`
+	GroupProperties        map[string]map[string]any `json:"groupProperties"`
+}
+
+// EventBatch is a batch of raw events together with the time the client sent
+// it at, if the client reported it.
+type EventBatch struct {
+	SentAt string            `json:"sentAt"`
+	Events []json.RawMessage `json:"events"`
+}
+
+// SplitEventPayload accepts a single event object, an array of events or a
+// batch object with the events and their sentAt time. It returns the raw
+// events, so that each of them can be validated on its own.
+func SplitEventPayload(payload json.RawMessage) (EventBatch, error) {
+	trimmed := bytes.TrimSpace(payload)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(trimmed, &batch); err != nil {
+			return EventBatch{}, err
+		}
+		return EventBatch{Events: batch}, nil
+	}
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var envelope struct {
+			EventBatch
+			EventType *json.RawMessage `json:"eventType"`
+		}
+		if err := json.Unmarshal(trimmed, &envelope); err == nil && envelope.Events != nil && envelope.EventType == nil {
+			return envelope.EventBatch, nil
+		}
+		return EventBatch{Events: []json.RawMessage{trimmed}}, nil
+	}
+	return EventBatch{}, errors.New("payload must be an event object, an array of events or a batch object")
+}
+
+// DecodeEventInput strictly decodes and validates a single event. The event is
+// stored with the uuid sent by the client, one derived from its dedupKey or a
+// newly generated one, in that order. A maxPropertiesLength of zero disables
+// the size check.
+func DecodeEventInput(raw json.RawMessage, maxPropertiesLength int) (*EventInput, *ValidationError) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+
+	var input rawEventInput
+	if err := decoder.Decode(&input); err != nil {
+		if strings.HasPrefix(err.Error(), "json: unknown field") {
+			return nil, &ValidationError{Reason: UnknownField, Message: err.Error()}
+		}
+		return nil, &ValidationError{Reason: InvalidEvent, Message: err.Error()}
+	}
+
+	if strings.TrimSpace(input.EventType) == "" {
+		return nil, &ValidationError{Reason: MissingEventType, Message: "eventType is required"}
+	}
+
+	event := &EventInput{
+		EventType:              input.EventType,
+		SessionId:              input.SessionId,
+		PersonId:               input.PersonId,
+		Properties:             input.Properties,
+		PersonProperties:       input.PersonProperties,
+		PersonPropertiesOnce:   input.PersonPropertiesOnce,
+		PersonPropertiesUnset:  input.PersonPropertiesUnset,
+		PersonPropertiesAdd:    input.PersonPropertiesAdd,
+		PersonPropertiesAppend: input.PersonPropertiesAppend,
+		PersonPropertiesUnion:  input.PersonPropertiesUnion,
+		Groups:                 input.Groups,
+		GroupProperties:        input.GroupProperties,
+	}
+	if err := checkGroups(event); err != nil {
+		return nil, &ValidationError{Reason: InvalidEvent, Message: err.Error()}
+	}
+
+	timestamp, err := parseTimestamp(input.Timestamp)
+	if err != nil {
+		return nil, &ValidationError{Reason: InvalidTimestamp, Message: err.Error()}
+	}
+	event.Timestamp = timestamp
+
+	id, err := parseUuid(input.Uuid, input.DedupKey)
+	if err != nil {
+		return nil, &ValidationError{Reason: InvalidUuid, Message: err.Error()}
+	}
+	event.Uuid = &id
+
+	if validationErr := CheckPropertiesLength(event, maxPropertiesLength); validationErr != nil {
+		return nil, validationErr
+	}
+
+	return event, nil
+}
+
+// checkGroups requires valid group types and keys, and group properties only
+// for groups of the event.
+func checkGroups(event *EventInput) error {
+	for groupType, key := range event.Groups {
+		if !groups.ValidTypeName(groupType) {
+			return fmt.Errorf("group type %q must be 1 to 64 letters, digits, '_' or '-'", groupType)
+		}
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("group key of %q is empty", groupType)
+		}
+	}
+	for groupType := range event.GroupProperties {
+		if _, ok := event.Groups[groupType]; !ok {
+			return fmt.Errorf("groupProperties of %q need a group of that type", groupType)
+		}
+	}
+	return nil
+}
+
+func parseTimestamp(raw json.RawMessage) (time.Time, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return time.Time{}, nil
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return time.Time{}, errors.New("timestamp must be an ISO 8601 string")
+	}
+	if value == "" {
+		return time.Time{}, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timestamp %q is not in ISO 8601 format", value)
+	}
+	return timestamp, nil
+}
+
+func parseUuid(raw json.RawMessage, dedupKey string) (uuid.UUID, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		if dedupKey != "" {
+			return DedupKeyUuid(dedupKey), nil
+		}
+		return uuid.New(), nil
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return uuid.Nil, errors.New("uuid must be a string")
+	}
+	id, err := uuid.Parse(value)
+	if err != nil || id == uuid.Nil {
+		return uuid.Nil, fmt.Errorf("uuid %q is not valid", value)
+	}
+	return id, nil
+}
+
+// DedupKeyUuid derives a stable event uuid from a client supplied key, so that
+// every retry of an event is stored under the same id.
+func DedupKeyUuid(key string) uuid.UUID {
+	return uuid.NewSHA1(dedupNamespace, []byte(key))
+}
+
+// CheckPropertiesLength rejects events whose json encoded properties exceed
+// maxPropertiesLength bytes. A maxPropertiesLength of zero disables the check.
+func CheckPropertiesLength(event *EventInput, maxPropertiesLength int) *ValidationError {
+	if maxPropertiesLength <= 0 {
+		return nil
+	}
+	length, err := propertiesLength(event)
+	if err != nil {
+		return &ValidationError{Reason: InvalidEvent, Message: err.Error()}
+	}
+	if length > maxPropertiesLength {
+		return &ValidationError{
+			Reason:  PropertiesTooLarge,
+			Message: fmt.Sprintf("properties are %d bytes, the limit is %d bytes", length, maxPropertiesLength),
+		}
+	}
+	return nil
+}
+
+func propertiesLength(event *EventInput) (int, error) {
+	properties, err := json.Marshal(event.Properties)
+	if err != nil {
+		return 0, err
+	}
+	personProperties, err := json.Marshal(event.PersonProperties)
+	if err != nil {
+		return 0, err
+	}
+	length := len(properties) + len(personProperties)
+	for _, operation := range []any{
+		event.PersonPropertiesOnce,
+		event.PersonPropertiesUnset,
+		event.PersonPropertiesAdd,
+		event.PersonPropertiesAppend,
+		event.PersonPropertiesUnion,
+		event.Groups,
+		event.GroupProperties,
+	} {
+		encoded, err := json.Marshal(operation)
+		if err != nil {
+			return 0, err
+		}
+		length += len(encoded)
+	}
+	return length, nil
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestDecodeEventInputRejectsInvalidEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		reason RejectionReason
+	}{
+		{"missing type", `{"properties":{"a":1}}`, MissingEventType},
+		{"blank type", `{"eventType":"  "}`, MissingEventType},
+		{"bad timestamp", `{"eventType":"click","timestamp":"yesterday"}`, InvalidTimestamp},
+		{"numeric timestamp", `{"eventType":"click","timestamp":12}`, InvalidTimestamp},
+		{"invalid uuid", `{"eventType":"click","uuid":"not-a-uuid"}`, InvalidUuid},
+		{"unknown field", `{"eventType":"click","event":"typo"}`, UnknownField},
+		{"wrong type", `{"eventType":"click","properties":[]}`, InvalidEvent},
+		{"oversized properties", `{"eventType":"click","properties":{"a":"` + strings.Repeat("x", 64) + `"}}`, PropertiesTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := DecodeEventInput(json.RawMessage(test.raw), 32)
+			assert.Nil(t, event)
+			assert.NotNil(t, err)
+			assert.Equal(t, test.reason, err.Reason)
+		})
+	}
+}
+
+func TestDecodeEventInputAssignsUuid(t *testing.T) {
+	event, err := DecodeEventInput(json.RawMessage(`{"eventType":"click","timestamp":"2025-02-23T10:00:00Z"}`), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "click", event.EventType)
+	assert.NotNil(t, event.Uuid)
+	assert.Equal(t, 2025, event.Timestamp.Year())
+}
+
+func TestDecodeEventInputUsesClientUuidOrDedupKey(t *testing.T) {
+	event, err := DecodeEventInput(json.RawMessage(`{"eventType":"click","uuid":"0f8fad5b-d9cb-469f-a165-70867728950e"}`), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "0f8fad5b-d9cb-469f-a165-70867728950e", event.Uuid.String())
+
+	first, err := DecodeEventInput(json.RawMessage(`{"eventType":"click","dedupKey":"order-42"}`), 0)
+	assert.Nil(t, err)
+	retry, err := DecodeEventInput(json.RawMessage(`{"eventType":"click","dedupKey":"order-42"}`), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, *first.Uuid, *retry.Uuid)
+}
+
+func TestSplitEventPayloadAcceptsSingleEventsAndBatches(t *testing.T) {
+	single, err := SplitEventPayload(json.RawMessage(` {"eventType":"click"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(single.Events))
+
+	batch, err := SplitEventPayload(json.RawMessage(`[{"eventType":"a"},{"eventType":"b"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(batch.Events))
+
+	envelope, err := SplitEventPayload(json.RawMessage(`{"sentAt":"2025-02-23T10:00:00Z","events":[{"eventType":"a"}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(envelope.Events))
+	assert.Equal(t, "2025-02-23T10:00:00Z", envelope.SentAt)
+
+	_, err = SplitEventPayload(json.RawMessage(`"click"`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,60 @@
+package filecatalog
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// InvalidateSegments marks the entries whose files were exported without some
+// of the given event timestamps as stale, by letting them expire at now. It
+// returns the number of entries that became stale.
+//
+// Only entries that cover a timestamp are affected. Events after the end of
+// the latest file, the usual case, leave it valid.
+func InvalidateSegments(db *gorm.DB, timestamps []time.Time, now time.Time) (int64, error) {
+	var invalidated int64
+	for _, span := range daySpans(timestamps) {
+		result := db.Model(&FileCatalogEntry{}).
+			Where("valid_until is null or valid_until > ?", now).
+			Where("start <= ? and (\"end\" is null or \"end\" >= ?)", span.last, span.first).
+			Update("valid_until", now)
+		if result.Error != nil {
+			return invalidated, result.Error
+		}
+		invalidated += result.RowsAffected
+	}
+	return invalidated, nil
+}
+
+type timeSpan struct {
+	first time.Time
+	last  time.Time
+}
+
+// daySpans reduces timestamps to their earliest and latest value per day, so
+// that a batch needs a handful of updates instead of one per event.
+func daySpans(timestamps []time.Time) []timeSpan {
+	spans := make(map[string]*timeSpan)
+	var days []string
+	for _, timestamp := range timestamps {
+		timestamp = timestamp.UTC()
+		day := timestamp.Format(time.DateOnly)
+		span, ok := spans[day]
+		if !ok {
+			spans[day] = &timeSpan{first: timestamp, last: timestamp}
+			days = append(days, day)
+			continue
+		}
+		if timestamp.Before(span.first) {
+			span.first = timestamp
+		}
+		if timestamp.After(span.last) {
+			span.last = timestamp
+		}
+	}
+	result := make([]timeSpan, len(days))
+	for i, day := range days {
+		result[i] = *spans[day]
+	}
+	return result
+}
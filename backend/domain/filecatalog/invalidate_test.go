@@ -0,0 +1,44 @@
+package filecatalog
+
+import (
+	"analytics/database/testsetup"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestInvalidateSegmentsExpiresCoveringEntries(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{ProjectDB: true})
+	db := setup.ProjectDB
+	assert.NoError(t, db.AutoMigrate(&FileCatalogEntry{}))
+
+	now := time.Date(2025, 5, 10, 12, 0, 0, 0, time.UTC)
+	segments := GenerateTimeFragments(now, now.AddDate(0, -6, 0))
+	for _, segment := range segments {
+		assert.NoError(t, db.Create(&FileCatalogEntry{
+			Name:       segment.Filename,
+			Start:      &segment.StartDate,
+			End:        &segment.EndDate,
+			ValidUntil: segment.ValidUntil,
+		}).Error)
+	}
+
+	invalidated, err := InvalidateSegments(db, []time.Time{
+		now.Add(time.Hour),
+		time.Date(2025, 2, 3, 8, 0, 0, 0, time.UTC),
+		time.Date(2025, 2, 3, 9, 0, 0, 0, time.UTC),
+	}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), invalidated)
+
+	var entries []FileCatalogEntry
+	assert.NoError(t, db.Order("start").Find(&entries).Error)
+	var stale []string
+	for _, entry := range entries {
+		if entry.ValidUntil != nil && entry.ValidUntil.Equal(now) {
+			stale = append(stale, entry.Name)
+		}
+	}
+	assert.Equal(t, []string{"2025-q1.parquet"}, stale)
+}
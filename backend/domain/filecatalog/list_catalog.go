@@ -20,3 +20,27 @@ func ListAll(db *gorm.DB) ([]FileCatalogEntry, error) {
 
 	return entries, nil
 }
+
+// ListCovering returns the entries whose files were exported from a time
+// range that contains any of the timestamps, including stale entries whose
+// files are still on disk.
+func ListCovering(db *gorm.DB, timestamps []time.Time) ([]FileCatalogEntry, error) {
+	entries := make([]FileCatalogEntry, 0)
+	seen := make(map[uint]bool)
+	for _, span := range daySpans(timestamps) {
+		var covering []FileCatalogEntry
+		err := db.Where("start <= ? and (\"end\" is null or \"end\" >= ?)", span.last, span.first).
+			Order("id").
+			Find(&covering).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range covering {
+			if !seen[entry.ID] {
+				seen[entry.ID] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, nil
+}
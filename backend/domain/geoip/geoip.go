@@ -0,0 +1,93 @@
+package geoip
+
+import (
+	"analytics/log"
+	"github.com/oschwald/maxminddb-golang/v2"
+	"net/netip"
+)
+
+// Location is the part of a GeoLite2/GeoIP2 City record that events are
+// enriched with.
+type Location struct {
+	Country  string
+	City     string
+	Region   string
+	Timezone string
+}
+
+type cityRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Subdivisions []struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	Location struct {
+		TimeZone string `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+}
+
+var reader *maxminddb.Reader
+
+// Load opens the MMDB file at path. An empty path leaves GeoIP enrichment
+// disabled.
+func Load(path string) error {
+	if path == "" {
+		return nil
+	}
+	opened, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	reader = opened
+	log.Info("Loaded GeoIP database %s (%s)", path, opened.Metadata.DatabaseType)
+	return nil
+}
+
+func Enabled() bool {
+	return reader != nil
+}
+
+// Lookup returns the location of ip. It reports false if no database is
+// loaded, the ip is invalid or not part of the database.
+func Lookup(ip string) (Location, bool) {
+	if reader == nil {
+		return Location{}, false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Location{}, false
+	}
+	result := reader.Lookup(addr.Unmap())
+	if !result.Found() {
+		return Location{}, false
+	}
+	var record cityRecord
+	if err := result.Decode(&record); err != nil {
+		log.Warn("Could not decode GeoIP record for %s: %v", ip, err)
+		return Location{}, false
+	}
+
+	location := Location{
+		Country:  record.Country.IsoCode,
+		City:     record.City.Names["en"],
+		Timezone: record.Location.TimeZone,
+	}
+	if len(record.Subdivisions) > 0 {
+		location.Region = record.Subdivisions[0].Names["en"]
+	}
+	return location, true
+}
+
+func Close() error {
+	if reader == nil {
+		return nil
+	}
+	err := reader.Close()
+	reader = nil
+	return err
+}
@@ -0,0 +1,32 @@
+package groups
+
+import (
+	"analytics/database/testsetup"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestRegisterTypesAssignsIndexesUpToTheLimit(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{ProjectDB: true})
+	db := setup.ProjectDB
+	assert.NoError(t, db.AutoMigrate(&GroupType{}))
+
+	types, refused, err := RegisterTypes(db, []string{"company", "workspace"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(refused))
+	assert.Equal(t, 2, len(types))
+	assert.Equal(t, "workspace", types[1].Name)
+	assert.Equal(t, 1, types[1].Index)
+
+	types, refused, err = RegisterTypes(db, []string{"company", "team", "project", "region", "country"})
+	assert.NoError(t, err)
+	assert.Equal(t, MaxGroupTypes, len(types))
+	assert.Equal(t, []string{"country"}, refused)
+	assert.Equal(t, "region", types[4].Name)
+
+	renamed, err := UpdateDisplayName(db, 0, "Companies")
+	assert.NoError(t, err)
+	assert.Equal(t, "company", renamed.Name)
+	assert.Equal(t, "Companies", renamed.DisplayName)
+}
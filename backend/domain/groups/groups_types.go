@@ -0,0 +1,56 @@
+package groups
+
+import (
+	"analytics/domain/person"
+	"regexp"
+	"time"
+)
+
+// MaxGroupTypes is the number of group types a project can have. Each type
+// gets one of the group columns of the parquet export.
+const MaxGroupTypes = 5
+
+var typeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidTypeName reports whether name can be used as group type, e.g. company.
+func ValidTypeName(name string) bool {
+	return typeNamePattern.MatchString(name)
+}
+
+// GroupType is a kind of group events are attributed to, such as company or
+// workspace. Types are registered when the first event of a group of that
+// type is stored. The index is the group column of the type in the parquet
+// export and never changes. It is stored in the project database.
+type GroupType struct {
+	Index       int       `gorm:"primaryKey;autoIncrement:false" json:"index"`
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"`
+	DisplayName string    `json:"displayName"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Group is a group of a type, identified by its key. Its properties are kept
+// like the properties of a person, the latest value by event timestamp wins.
+type Group struct {
+	Type               string                    `json:"type"`
+	Key                string                    `json:"key"`
+	FirstSeen          time.Time                 `json:"firstSeen"`
+	Properties         person.PersonProperties   `json:"properties"`
+	PropertyTimestamps person.PropertyTimestamps `json:"propertyTimestamps"`
+}
+
+// Apply sets the properties that were not set after eventTime.
+func (g *Group) Apply(props map[string]any, eventTime time.Time) {
+	if g.Properties == nil {
+		g.Properties = make(person.PersonProperties)
+	}
+	if g.PropertyTimestamps == nil {
+		g.PropertyTimestamps = make(person.PropertyTimestamps)
+	}
+	for key, value := range props {
+		if setAt, exists := g.PropertyTimestamps[key]; exists && eventTime.Before(setAt) {
+			continue
+		}
+		g.Properties[key] = value
+		g.PropertyTimestamps[key] = eventTime
+	}
+}
@@ -0,0 +1,31 @@
+package groups
+
+import (
+	"analytics/database/analyticsdb"
+	"database/sql"
+	"errors"
+)
+
+// GetGroup reads a group from the analytics database, nil if no event was
+// attributed to it yet.
+func GetGroup(dbd analyticsdb.DuckDB, groupType, key string) (*Group, error) {
+	tx, err := dbd.Tx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	group := Group{Type: groupType, Key: key}
+	err = tx.QueryRow(`
+		SELECT first_seen, properties, property_timestamps
+		FROM groups
+		WHERE group_type = $1 AND group_key = $2
+	`, groupType, key).Scan(&group.FirstSeen, &group.Properties, &group.PropertyTimestamps)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
@@ -0,0 +1,77 @@
+package groups
+
+import (
+	"gorm.io/gorm"
+	"slices"
+)
+
+// ListTypes returns the group types of the project by their index.
+func ListTypes(db *gorm.DB) ([]GroupType, error) {
+	types := make([]GroupType, 0)
+	if err := db.Order("`index`").Find(&types).Error; err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// RegisterTypes registers the group types that are not known yet, each at the
+// lowest free index. Types beyond MaxGroupTypes are refused and returned, the
+// events keep their groups but they are not exported.
+func RegisterTypes(db *gorm.DB, names []string) ([]GroupType, []string, error) {
+	var types []GroupType
+	var refused []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		types, err = ListTypes(tx)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if slices.ContainsFunc(types, func(groupType GroupType) bool { return groupType.Name == name }) {
+				continue
+			}
+			index := freeIndex(types)
+			if index < 0 {
+				refused = append(refused, name)
+				continue
+			}
+			groupType := GroupType{Index: index, Name: name}
+			if err := tx.Create(&groupType).Error; err != nil {
+				return err
+			}
+			types = append(types, groupType)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	slices.SortFunc(types, func(a, b GroupType) int { return a.Index - b.Index })
+	return types, refused, nil
+}
+
+func freeIndex(types []GroupType) int {
+	for index := 0; index < MaxGroupTypes; index++ {
+		if !slices.ContainsFunc(types, func(groupType GroupType) bool { return groupType.Index == index }) {
+			return index
+		}
+	}
+	return -1
+}
+
+// UpdateDisplayName renames a group type in the UI. The name events refer to
+// the type by cannot be changed.
+func UpdateDisplayName(db *gorm.DB, index int, displayName string) (*GroupType, error) {
+	result := db.Model(&GroupType{}).Where("`index` = ?", index).Update("display_name", displayName)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var groupType GroupType
+	if err := db.Where("`index` = ?", index).First(&groupType).Error; err != nil {
+		return nil, err
+	}
+	return &groupType, nil
+}
@@ -0,0 +1,157 @@
+package imports
+
+import (
+	"analytics/domain/events"
+	"analytics/domain/events/mixpanel"
+	"analytics/domain/events/posthog"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// exportSource reads the events of a vendor export. Exports hold one json
+// object per line or a json array of objects and may be gzip compressed.
+type exportSource struct {
+	path     string
+	convert  func(record json.RawMessage) (*events.EventInput, error)
+	idPrefix string
+	file     *os.File
+	decoder  *json.Decoder
+}
+
+func openExport(format Format, path string, checksum string) (*exportSource, error) {
+	source := &exportSource{path: path, idPrefix: checksum}
+	switch format {
+	case MixpanelExport:
+		source.convert = convertMixpanel
+	case PostHogExport:
+		source.convert = convertPostHog
+	default:
+		return nil, fmt.Errorf("format %q is not an export", format)
+	}
+	var err error
+	source.file, source.decoder, err = openRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// Count reads the file once, as exports do not record their length.
+func (s *exportSource) Count() (int64, error) {
+	file, decoder, err := openRecords(s.path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var count int64
+	for decoder.More() {
+		var record json.RawMessage
+		if err := decoder.Decode(&record); err != nil {
+			return 0, fmt.Errorf("could not read record %d: %w", count+1, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s *exportSource) Next(row int64) (*events.EventInput, error) {
+	if !s.decoder.More() {
+		return nil, io.EOF
+	}
+	var record json.RawMessage
+	if err := s.decoder.Decode(&record); err != nil {
+		return nil, fmt.Errorf("could not read record %d: %w", row, err)
+	}
+	event, err := s.convert(record)
+	if err != nil {
+		return nil, rowError{err}
+	}
+	// Without an id of the vendor, the id is derived from the file contents
+	// and the row like for other files.
+	if event.Uuid == nil {
+		id := events.DedupKeyUuid(fmt.Sprintf("import:%s:%d", s.idPrefix, row))
+		event.Uuid = &id
+	}
+	return event, nil
+}
+
+func (s *exportSource) Close() error {
+	return s.file.Close()
+}
+
+// openRecords returns a decoder positioned at the first record of an export.
+func openRecords(path string) (*os.File, *json.Decoder, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	buffered := bufio.NewReader(file)
+	if magic, _ := buffered.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		decompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		buffered = bufio.NewReader(decompressed)
+	}
+
+	for {
+		next, err := buffered.Peek(1)
+		if err != nil || !isJSONSpace(next[0]) {
+			break
+		}
+		buffered.ReadByte()
+	}
+	decoder := json.NewDecoder(buffered)
+	if next, err := buffered.Peek(1); err == nil && next[0] == '[' {
+		if _, err := decoder.Token(); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+	return file, decoder, nil
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func convertMixpanel(raw json.RawMessage) (*events.EventInput, error) {
+	var record mixpanel.ExportRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return nil, err
+	}
+	event, validationErr := mixpanel.ToEventInput(record)
+	if validationErr != nil {
+		return nil, validationErr
+	}
+	return event, nil
+}
+
+// convertPostHog keeps the uuid of exported events, which PostHog
+// deduplicates by.
+func convertPostHog(raw json.RawMessage) (*events.EventInput, error) {
+	var exported posthog.ExportEvent
+	if err := json.Unmarshal(raw, &exported); err != nil {
+		return nil, err
+	}
+	captured, err := exported.ToCaptureEvent()
+	if err != nil {
+		return nil, err
+	}
+	hasUuid := captured.Uuid != ""
+	event, validationErr := posthog.ToEventInput(captured, time.Time{})
+	if validationErr != nil {
+		return nil, validationErr
+	}
+	if !hasUuid {
+		event.Uuid = nil
+	}
+	return event, nil
+}
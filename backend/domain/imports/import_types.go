@@ -0,0 +1,141 @@
+package imports
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	CSV     Format = "csv"
+	NDJSON  Format = "ndjson"
+	Parquet Format = "parquet"
+	// MixpanelExport and PostHogExport are the event exports of these
+	// vendors, as json lines or a json array, optionally gzip compressed.
+	MixpanelExport Format = "mixpanel"
+	PostHogExport  Format = "posthog"
+)
+
+func ParseFormat(value string) (Format, bool) {
+	switch format := Format(strings.ToLower(value)); format {
+	case CSV, NDJSON, Parquet, MixpanelExport, PostHogExport:
+		return format, true
+	}
+	return "", false
+}
+
+// NeedsMapping tells whether the columns of a format are mapped by a
+// ColumnMapping. The layout of vendor exports is known.
+func (f Format) NeedsMapping() bool {
+	return f == CSV || f == NDJSON || f == Parquet
+}
+
+// FormatOf derives the format of a file from its extension. Vendor exports
+// cannot be told apart from other json files.
+func FormatOf(filename string) (Format, bool) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return CSV, true
+	case ".ndjson", ".jsonl", ".json":
+		return NDJSON, true
+	case ".parquet":
+		return Parquet, true
+	}
+	return "", false
+}
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+)
+
+// ColumnMapping names the columns of an import file that hold the fields of
+// the events. EventType and Timestamp are required.
+type ColumnMapping struct {
+	EventType string `json:"eventType"`
+	Timestamp string `json:"timestamp"`
+	PersonId  string `json:"personId,omitempty"`
+	SessionId string `json:"sessionId,omitempty"`
+	// Uuid holds the event ids. Without it, the ids are derived from the file
+	// contents and the row number, so importing a file twice does not
+	// duplicate its events.
+	Uuid string `json:"uuid,omitempty"`
+	// Properties lists the columns that are stored as properties. If it is
+	// empty, all columns not mapped otherwise are.
+	Properties []string `json:"properties,omitempty"`
+	// PropertiesColumn and PersonPropertiesColumn hold objects or json
+	// encoded objects that are merged into the properties and person
+	// properties.
+	PropertiesColumn       string `json:"propertiesColumn,omitempty"`
+	PersonPropertiesColumn string `json:"personPropertiesColumn,omitempty"`
+}
+
+func (m *ColumnMapping) Scan(src any) error {
+	return scanJSON(src, m)
+}
+
+func (m ColumnMapping) Value() (driver.Value, error) {
+	return json.Marshal(m)
+}
+
+// RowError tells why a row of an import file was skipped. Rows are counted
+// from 1, the header of a csv file is not counted.
+type RowError struct {
+	Row   int64  `json:"row"`
+	Error string `json:"error"`
+}
+
+type RowErrors []RowError
+
+func (e *RowErrors) Scan(src any) error {
+	return scanJSON(src, e)
+}
+
+func (e RowErrors) Value() (driver.Value, error) {
+	return json.Marshal(e)
+}
+
+// ImportJob tracks the import of a single file.
+type ImportJob struct {
+	ID       uint          `gorm:"primarykey" json:"id"`
+	Filename string        `gorm:"not null" json:"filename"`
+	Format   Format        `gorm:"not null" json:"format"`
+	Mapping  ColumnMapping `gorm:"type:json" json:"mapping"`
+	Status   JobStatus     `gorm:"not null;default:pending" json:"status"`
+	// TotalRows is known once the file was scanned, ProcessedRows counts the
+	// rows handled so far, either imported or failed.
+	TotalRows     int64 `gorm:"not null;default:0" json:"totalRows"`
+	ProcessedRows int64 `gorm:"not null;default:0" json:"processedRows"`
+	ImportedRows  int64 `gorm:"not null;default:0" json:"importedRows"`
+	FailedRows    int64 `gorm:"not null;default:0" json:"failedRows"`
+	// Errors keeps the first maxRowErrors failed rows.
+	Errors     RowErrors  `gorm:"type:json" json:"errors"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+func scanJSON(src any, target any) error {
+	if src == nil {
+		return nil
+	}
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, target)
+	case string:
+		return json.Unmarshal([]byte(v), target)
+	default:
+		return fmt.Errorf("unsupported type for json column: %T", src)
+	}
+}
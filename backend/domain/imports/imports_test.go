@@ -0,0 +1,133 @@
+package imports
+
+import (
+	"analytics/database/testsetup"
+	"analytics/domain/events"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestRunImportsMappedColumns(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{ProjectDB: true})
+	db := setup.ProjectDB
+	assert.NoError(t, db.AutoMigrate(&ImportJob{}))
+
+	path := filepath.Join(t.TempDir(), "history.csv")
+	assert.NoError(t, os.WriteFile(path, []byte(
+		"event,time,user,plan,price\n"+
+			"signup,2023-01-02 10:00:00,u1,pro,9.5\n"+
+			",2023-01-02 11:00:00,u2,free,0\n"+
+			"purchase,1672657200,u1,pro,20\n"), 0644))
+
+	job, err := CreateJob(db, "history.csv", CSV, ColumnMapping{
+		EventType: "event",
+		Timestamp: "time",
+		PersonId:  "user",
+	})
+	assert.NoError(t, err)
+
+	var imported []*events.EventInput
+	err = Run(db, job, path, func(chunk []*events.EventInput) error {
+		imported = append(imported, chunk...)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	stored, err := GetJob(db, job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, JobCompleted, stored.Status)
+	assert.Equal(t, int64(3), stored.TotalRows)
+	assert.Equal(t, int64(3), stored.ProcessedRows)
+	assert.Equal(t, int64(2), stored.ImportedRows)
+	assert.Equal(t, int64(1), stored.FailedRows)
+	assert.Equal(t, RowErrors{{Row: 2, Error: "event type is empty"}}, stored.Errors)
+
+	assert.Equal(t, 2, len(imported))
+	assert.Equal(t, "signup", imported[0].EventType)
+	assert.Equal(t, time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC), imported[0].Timestamp)
+	assert.Equal(t, "u1", *imported[0].PersonId)
+	assert.Nil(t, imported[0].SessionId)
+	assert.Equal(t, map[string]any{"plan": "pro", "price": 9.5}, imported[0].Properties)
+	assert.Equal(t, time.Date(2023, 1, 2, 11, 0, 0, 0, time.UTC), imported[1].Timestamp)
+
+	again, err := CreateJob(db, "history.csv", CSV, job.Mapping)
+	assert.NoError(t, err)
+	var reimported []*events.EventInput
+	assert.NoError(t, Run(db, again, path, func(chunk []*events.EventInput) error {
+		reimported = append(reimported, chunk...)
+		return nil
+	}))
+	assert.Equal(t, *imported[0].Uuid, *reimported[0].Uuid)
+}
+
+func TestRunFailsOnUnknownColumns(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{ProjectDB: true})
+	db := setup.ProjectDB
+	assert.NoError(t, db.AutoMigrate(&ImportJob{}))
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"name":"click","at":"2023-01-02T10:00:00Z"}`+"\n"), 0644))
+
+	job, err := CreateJob(db, "events.ndjson", NDJSON, ColumnMapping{EventType: "event", Timestamp: "at"})
+	assert.NoError(t, err)
+	err = Run(db, job, path, func(chunk []*events.EventInput) error { return nil })
+	assert.Error(t, err)
+
+	stored, err := GetJob(db, job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, JobFailed, stored.Status)
+	assert.NotNil(t, stored.FinishedAt)
+}
+
+func TestRunImportsVendorExports(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{ProjectDB: true})
+	db := setup.ProjectDB
+	assert.NoError(t, db.AutoMigrate(&ImportJob{}))
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte(
+		`{"event":"Signed Up","properties":{"time":1672653600,"distinct_id":"u1","$insert_id":"a"}}` + "\n" +
+			`{"event":"Signed Up","properties":{"distinct_id":"u1"}}` + "\n" +
+			`{"event":"Viewed","properties":{"time":1672653600,"distinct_id":"u1"}}` + "\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	mixpanelPath := filepath.Join(t.TempDir(), "export.json.gz")
+	assert.NoError(t, os.WriteFile(mixpanelPath, compressed.Bytes(), 0644))
+
+	job, err := CreateJob(db, "export.json.gz", MixpanelExport, ColumnMapping{})
+	assert.NoError(t, err)
+	var imported []*events.EventInput
+	assert.NoError(t, Run(db, job, mixpanelPath, func(chunk []*events.EventInput) error {
+		imported = append(imported, chunk...)
+		return nil
+	}))
+	assert.Equal(t, int64(3), job.TotalRows)
+	assert.Equal(t, int64(2), job.ImportedRows)
+	assert.Equal(t, RowErrors{{Row: 2, Error: "time is required"}}, job.Errors)
+	assert.Equal(t, "u1", *imported[0].PersonId)
+	assert.NotNil(t, imported[1].Uuid)
+
+	posthogPath := filepath.Join(t.TempDir(), "events.json")
+	assert.NoError(t, os.WriteFile(posthogPath, []byte(`[
+		{"event":"$pageview","distinct_id":"u1","timestamp":"2023-01-02T10:00:00Z","properties":{"$current_url":"https://example.com"}},
+		{"event":"$pageview","distinct_id":"u1","timestamp":"2023-01-02T11:00:00Z","properties":"{}"}
+	]`), 0644))
+	job, err = CreateJob(db, "events.json", PostHogExport, ColumnMapping{})
+	assert.NoError(t, err)
+	imported = nil
+	assert.NoError(t, Run(db, job, posthogPath, func(chunk []*events.EventInput) error {
+		imported = append(imported, chunk...)
+		return nil
+	}))
+	assert.Equal(t, int64(2), job.ImportedRows)
+	assert.Equal(t, "https://example.com", imported[0].Properties["$current_url"])
+	assert.Equal(t, time.Date(2023, 1, 2, 11, 0, 0, 0, time.UTC), imported[1].Timestamp)
+	assert.NotEqual(t, *imported[0].Uuid, *imported[1].Uuid)
+}
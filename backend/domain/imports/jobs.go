@@ -0,0 +1,41 @@
+package imports
+
+import (
+	"gorm.io/gorm"
+)
+
+func CreateJob(db *gorm.DB, filename string, format Format, mapping ColumnMapping) (*ImportJob, error) {
+	job := &ImportJob{
+		Filename: filename,
+		Format:   format,
+		Mapping:  mapping,
+		Status:   JobPending,
+	}
+	if err := db.Create(job).Error; err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ListJobs returns the jobs of a project, the latest first.
+func ListJobs(db *gorm.DB) ([]ImportJob, error) {
+	var jobs []ImportJob
+	err := db.Order("id desc").Find(&jobs).Error
+	return jobs, err
+}
+
+func GetJob(db *gorm.DB, id uint) (*ImportJob, error) {
+	var job ImportJob
+	if err := db.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FailInterruptedJobs marks jobs that were running when the server stopped as
+// failed. Importing their file again skips the events that were stored.
+func FailInterruptedJobs(db *gorm.DB) error {
+	return db.Model(&ImportJob{}).
+		Where("status in ?", []JobStatus{JobPending, JobRunning}).
+		Updates(map[string]any{"status": JobFailed, "error": "interrupted by a restart"}).Error
+}
@@ -0,0 +1,227 @@
+package imports
+
+import (
+	"analytics/domain/events"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+func (m ColumnMapping) Validate() error {
+	if m.EventType == "" {
+		return errors.New("eventType column is required")
+	}
+	if m.Timestamp == "" {
+		return errors.New("timestamp column is required")
+	}
+	return nil
+}
+
+// rowConverter turns the rows of a file into events according to a mapping.
+type rowConverter struct {
+	mapping    ColumnMapping
+	index      map[string]int
+	properties []int
+	// idPrefix is derived from the file contents, see ColumnMapping.Uuid.
+	idPrefix string
+}
+
+func newRowConverter(mapping ColumnMapping, columns []string, idPrefix string) (*rowConverter, error) {
+	index := make(map[string]int, len(columns))
+	for i, column := range columns {
+		index[column] = i
+	}
+	mapped := []string{
+		mapping.EventType, mapping.Timestamp, mapping.PersonId, mapping.SessionId, mapping.Uuid,
+		mapping.PropertiesColumn, mapping.PersonPropertiesColumn,
+	}
+	for _, column := range append(slices.Clone(mapped), mapping.Properties...) {
+		if _, ok := index[column]; column != "" && !ok {
+			return nil, fmt.Errorf("column %q not found, the file has %v", column, columns)
+		}
+	}
+
+	converter := &rowConverter{mapping: mapping, index: index, idPrefix: idPrefix}
+	if len(mapping.Properties) > 0 {
+		for _, column := range mapping.Properties {
+			converter.properties = append(converter.properties, index[column])
+		}
+	} else {
+		for i, column := range columns {
+			if !slices.Contains(mapped, column) {
+				converter.properties = append(converter.properties, i)
+			}
+		}
+	}
+	return converter, nil
+}
+
+func (c *rowConverter) convert(columns []string, values []any, row int64) (*events.EventInput, error) {
+	eventType := stringValue(c.value(values, c.mapping.EventType))
+	if eventType == "" {
+		return nil, errors.New("event type is empty")
+	}
+	timestamp, err := parseTimestamp(c.value(values, c.mapping.Timestamp))
+	if err != nil {
+		return nil, err
+	}
+	id, err := c.eventId(values, row)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &events.EventInput{
+		Uuid:             &id,
+		EventType:        eventType,
+		Timestamp:        timestamp,
+		PersonId:         optionalString(c.value(values, c.mapping.PersonId)),
+		SessionId:        optionalString(c.value(values, c.mapping.SessionId)),
+		Properties:       make(map[string]any, len(c.properties)),
+		PersonProperties: make(map[string]any),
+	}
+	for _, i := range c.properties {
+		if value := normalizeValue(values[i]); value != nil {
+			event.Properties[columns[i]] = value
+		}
+	}
+	if err := mergeObject(event.Properties, c.value(values, c.mapping.PropertiesColumn)); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.mapping.PropertiesColumn, err)
+	}
+	if err := mergeObject(event.PersonProperties, c.value(values, c.mapping.PersonPropertiesColumn)); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.mapping.PersonPropertiesColumn, err)
+	}
+	return event, nil
+}
+
+func (c *rowConverter) value(values []any, column string) any {
+	if column == "" {
+		return nil
+	}
+	return values[c.index[column]]
+}
+
+func (c *rowConverter) eventId(values []any, row int64) (uuid.UUID, error) {
+	if value := stringValue(c.value(values, c.mapping.Uuid)); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("uuid %q is not valid", value)
+		}
+		return id, nil
+	}
+	return events.DedupKeyUuid(fmt.Sprintf("import:%s:%d", c.idPrefix, row)), nil
+}
+
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", time.DateOnly}
+
+// parseTimestamp accepts timestamps, ISO 8601 strings and unix epochs in
+// seconds or milliseconds.
+func parseTimestamp(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case string:
+		for _, layout := range timestampLayouts {
+			if timestamp, err := time.Parse(layout, v); err == nil {
+				return timestamp.UTC(), nil
+			}
+		}
+		if epoch, err := strconv.ParseFloat(v, 64); err == nil {
+			return epochTime(epoch), nil
+		}
+		return time.Time{}, fmt.Errorf("timestamp %q is not in ISO 8601 format", v)
+	case int64:
+		return epochTime(float64(v)), nil
+	case int32:
+		return epochTime(float64(v)), nil
+	case float64:
+		return epochTime(v), nil
+	case nil:
+		return time.Time{}, errors.New("timestamp is empty")
+	}
+	return time.Time{}, fmt.Errorf("timestamp of type %T is not supported", value)
+}
+
+// epochTime reads epochs beyond the year 5000 in seconds as milliseconds.
+func epochTime(epoch float64) time.Time {
+	if math.Abs(epoch) > 1e11 {
+		return time.UnixMilli(int64(epoch)).UTC()
+	}
+	seconds, fraction := math.Modf(epoch)
+	return time.Unix(int64(seconds), int64(fraction*1e9)).UTC()
+}
+
+// normalizeValue converts the values read by DuckDB into values that are
+// stored as json. Missing fields of nested objects are dropped.
+func normalizeValue(value any) any {
+	switch v := value.(type) {
+	case nil, string, bool, int64, int32, int16, int8, float64, float32:
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case []byte:
+		return string(v)
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, nested := range v {
+			if normalized := normalizeValue(nested); normalized != nil {
+				result[key] = normalized
+			}
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, nested := range v {
+			result[i] = normalizeValue(nested)
+		}
+		return result
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+func stringValue(value any) string {
+	switch v := normalizeValue(value).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func optionalString(value any) *string {
+	if s := stringValue(value); s != "" {
+		return &s
+	}
+	return nil
+}
+
+func mergeObject(target map[string]any, value any) error {
+	var object map[string]any
+	switch v := normalizeValue(value).(type) {
+	case nil:
+		return nil
+	case map[string]any:
+		object = v
+	case string:
+		if v == "" {
+			return nil
+		}
+		if err := json.Unmarshal([]byte(v), &object); err != nil {
+			return errors.New("not a json object")
+		}
+	default:
+		return fmt.Errorf("expected an object, got %T", value)
+	}
+	for key, nested := range object {
+		target[key] = nested
+	}
+	return nil
+}
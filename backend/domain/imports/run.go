@@ -0,0 +1,259 @@
+package imports
+
+import (
+	"analytics/domain/events"
+	"analytics/log"
+	"analytics/util"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/duckdb/duckdb-go/v2"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChunkSize is the number of events handed to the sink at once. It is much
+// larger than the batches of the event queue, as imports are not latency
+// sensitive.
+const ChunkSize = 10000
+
+// maxRowErrors is the number of failed rows kept on a job.
+const maxRowErrors = 100
+
+// Sink stores a chunk of imported events.
+type Sink func(chunk []*events.EventInput) error
+
+// running counts the imports started by Start, so the server can wait for
+// them before it closes the databases.
+var running sync.WaitGroup
+
+// Start runs an import in the background and removes the file at path once it
+// finished.
+func Start(db *gorm.DB, job *ImportJob, path string, sink Sink) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer os.Remove(path)
+		Run(db, job, path, sink)
+	}()
+}
+
+// Wait waits for the imports started by Start. Their sinks fail once the
+// event processors stopped, which ends them at the next chunk.
+func Wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run imports the file at path into the sink and records the progress on the
+// job after every chunk. Rows that cannot be converted to events are skipped
+// and reported on the job. The import stops at the first error of the sink.
+func Run(db *gorm.DB, job *ImportJob, path string, sink Sink) error {
+	now := time.Now()
+	job.Status = JobRunning
+	job.StartedAt = &now
+	if err := db.Save(job).Error; err != nil {
+		return err
+	}
+
+	err := run(db, job, path, sink)
+	finished := time.Now()
+	job.FinishedAt = &finished
+	job.Status = JobCompleted
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+	}
+	if saveErr := db.Save(job).Error; saveErr != nil {
+		log.Error("Import %d: Error saving job: %v", job.ID, saveErr)
+	}
+	log.Info("Import %d: %s after %v, %d rows imported, %d failed",
+		job.ID, job.Status, finished.Sub(now), job.ImportedRows, job.FailedRows)
+	return err
+}
+
+// rowSource reads the events of an import file. Next returns io.EOF after the
+// last row and a rowError for rows that are skipped.
+type rowSource interface {
+	Count() (int64, error)
+	Next(row int64) (*events.EventInput, error)
+	Close() error
+}
+
+type rowError struct {
+	err error
+}
+
+func (e rowError) Error() string {
+	return e.err.Error()
+}
+
+func run(db *gorm.DB, job *ImportJob, path string, sink Sink) error {
+	checksum, err := util.CalculateFileChecksum(path)
+	if err != nil {
+		return err
+	}
+	var source rowSource
+	if job.Format.NeedsMapping() {
+		source, err = openTable(job, path, checksum)
+	} else {
+		source, err = openExport(job.Format, path, checksum)
+	}
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	if job.TotalRows, err = source.Count(); err != nil {
+		return err
+	}
+	if err := db.Save(job).Error; err != nil {
+		return err
+	}
+
+	chunk := make([]*events.EventInput, 0, ChunkSize)
+	flush := func() error {
+		if len(chunk) > 0 {
+			if err := sink(chunk); err != nil {
+				return err
+			}
+			job.ImportedRows += int64(len(chunk))
+			chunk = chunk[:0]
+		}
+		log.Info("Import %d: %d of %d rows processed", job.ID, job.ProcessedRows, job.TotalRows)
+		return db.Save(job).Error
+	}
+
+	for {
+		event, err := source.Next(job.ProcessedRows + 1)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var skipped rowError
+		if err != nil && !errors.As(err, &skipped) {
+			return err
+		}
+		job.ProcessedRows++
+		if err != nil {
+			job.FailedRows++
+			if len(job.Errors) < maxRowErrors {
+				job.Errors = append(job.Errors, RowError{Row: job.ProcessedRows, Error: err.Error()})
+			}
+		} else {
+			chunk = append(chunk, event)
+		}
+		if job.ProcessedRows%ChunkSize == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// tableSource reads csv, ndjson and parquet files with DuckDB and maps their
+// columns to events.
+type tableSource struct {
+	reader    *sql.DB
+	source    string
+	rows      *sql.Rows
+	columns   []string
+	converter *rowConverter
+	values    []any
+	pointers  []any
+}
+
+func openTable(job *ImportJob, path string, checksum string) (*tableSource, error) {
+	reader, err := openReader()
+	if err != nil {
+		return nil, err
+	}
+	table := &tableSource{reader: reader, source: readFunction(job.Format, path)}
+	table.rows, err = reader.Query("SELECT * FROM " + table.source)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("could not read file: %w", err)
+	}
+	if table.columns, err = table.rows.Columns(); err != nil {
+		table.Close()
+		return nil, err
+	}
+	if table.converter, err = newRowConverter(job.Mapping, table.columns, checksum); err != nil {
+		table.Close()
+		return nil, err
+	}
+	table.values = make([]any, len(table.columns))
+	table.pointers = make([]any, len(table.columns))
+	for i := range table.values {
+		table.pointers[i] = &table.values[i]
+	}
+	return table, nil
+}
+
+func (t *tableSource) Count() (int64, error) {
+	var count int64
+	if err := t.reader.QueryRow("SELECT count(*) FROM " + t.source).Scan(&count); err != nil {
+		return 0, fmt.Errorf("could not read file: %w", err)
+	}
+	return count, nil
+}
+
+func (t *tableSource) Next(row int64) (*events.EventInput, error) {
+	if !t.rows.Next() {
+		if err := t.rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	if err := t.rows.Scan(t.pointers...); err != nil {
+		return nil, err
+	}
+	event, err := t.converter.convert(t.columns, t.values, row)
+	if err != nil {
+		return nil, rowError{err}
+	}
+	return event, nil
+}
+
+func (t *tableSource) Close() error {
+	if t.rows != nil {
+		t.rows.Close()
+	}
+	return t.reader.Close()
+}
+
+// openReader opens an in-memory DuckDB, which reads all supported formats and
+// streams the rows of large files.
+func openReader() (*sql.DB, error) {
+	connector, err := duckdb.NewConnector("", nil)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
+func readFunction(format Format, path string) string {
+	quoted := "'" + strings.ReplaceAll(path, "'", "''") + "'"
+	switch format {
+	case NDJSON:
+		return fmt.Sprintf("read_json_auto(%s, format = 'newline_delimited')", quoted)
+	case Parquet:
+		return fmt.Sprintf("read_parquet(%s)", quoted)
+	default:
+		return fmt.Sprintf("read_csv_auto(%s)", quoted)
+	}
+}
@@ -0,0 +1,114 @@
+package person
+
+import (
+	"analytics/database/analyticsdb"
+	"analytics/domain/queries"
+	"database/sql"
+	"time"
+)
+
+// Deletion counts what deleting a person removed. Timestamps are the first
+// and last time of the deleted events per day, which tell the exported files
+// that contained them. Ids are the id of the person, the ids merged into it
+// and the ids of its sessions, which its events were sent with.
+type Deletion struct {
+	Events     int64       `json:"events"`
+	Sessions   int64       `json:"sessions"`
+	Timestamps []time.Time `json:"-"`
+	Ids        []string    `json:"-"`
+}
+
+// Delete removes a person by its canonical id together with its events, its
+// sessions and the persons that were merged into it. The events of the
+// sessions of the person before it was identified are removed as well. It
+// must not run concurrently with batches of the project.
+func Delete(dbd analyticsdb.DuckDB, id string) (Deletion, error) {
+	var deletion Deletion
+	tx, err := dbd.Tx()
+	if err != nil {
+		return deletion, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT min(events.timestamp), max(events.timestamp)
+		`+queries.EventsFromSQL+`
+		WHERE `+queries.PersonIdSQL+` = $1
+		GROUP BY CAST(events.timestamp AS DATE)
+	`, id)
+	if err != nil {
+		return deletion, err
+	}
+	for rows.Next() {
+		var first, last time.Time
+		if err := rows.Scan(&first, &last); err != nil {
+			rows.Close()
+			return deletion, err
+		}
+		deletion.Timestamps = append(deletion.Timestamps, first, last)
+	}
+	rows.Close()
+
+	deletion.Ids, err = queryIds(tx, `
+		SELECT $1
+		UNION SELECT distinct_id FROM person_distinct_ids WHERE person_id = $1
+		UNION SELECT id FROM sessions WHERE person_id = $1
+	`, id)
+	if err != nil {
+		return deletion, err
+	}
+
+	result, err := tx.Exec(`
+		DELETE FROM events WHERE id IN (
+			SELECT events.id
+			`+queries.EventsFromSQL+`
+			WHERE `+queries.PersonIdSQL+` = $1
+		)
+	`, id)
+	if err != nil {
+		return deletion, err
+	}
+	deletion.Events, _ = result.RowsAffected()
+
+	result, err = tx.Exec("DELETE FROM sessions WHERE person_id = $1", id)
+	if err != nil {
+		return deletion, err
+	}
+	deletion.Sessions, _ = result.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return deletion, err
+	}
+
+	// DuckDB refuses to delete rows whose references were deleted in the same
+	// transaction. If this fails, deleting the person again removes them.
+	tx, err = dbd.Tx()
+	if err != nil {
+		return deletion, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM persons WHERE id = $1 OR id IN (SELECT distinct_id FROM person_distinct_ids WHERE person_id = $1)", id); err != nil {
+		return deletion, err
+	}
+	if _, err := tx.Exec("DELETE FROM person_distinct_ids WHERE person_id = $1", id); err != nil {
+		return deletion, err
+	}
+	return deletion, tx.Commit()
+}
+
+func queryIds(tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
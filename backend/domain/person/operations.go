@@ -0,0 +1,203 @@
+package person
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// PropertyOperations are the updates of an event to the properties of its
+// person besides setting them.
+type PropertyOperations struct {
+	Once   PersonProperties   `json:"once,omitempty"`
+	Unset  []string           `json:"unset,omitempty"`
+	Add    map[string]float64 `json:"add,omitempty"`
+	Append PersonProperties   `json:"append,omitempty"`
+	Union  PersonProperties   `json:"union,omitempty"`
+}
+
+func (o PropertyOperations) IsEmpty() bool {
+	return len(o.Once) == 0 && len(o.Unset) == 0 && len(o.Add) == 0 && len(o.Append) == 0 && len(o.Union) == 0
+}
+
+// Apply applies the updates of an event at eventTime in the order $set,
+// $set_once, $unset, $add, $append and $union.
+//
+// The timestamp of a property is the time of the $set, $set_once or $unset
+// that decided its value, so updates that arrive late do not overwrite newer
+// ones: $set keeps the latest value, $set_once the earliest, and an $unset
+// keeps its timestamp to refuse older values. Increments and appends are
+// applied to the current value unless it was decided after them. They do not
+// move its timestamp, so they are kept when older updates arrive late.
+func (p *Person) Apply(props PersonProperties, operations PropertyOperations, eventTime time.Time) {
+	p.ensureMaps()
+	for key, value := range props {
+		p.setLatest(key, value, eventTime)
+	}
+	for key, value := range operations.Once {
+		p.setOnce(key, value, eventTime)
+	}
+	for _, key := range operations.Unset {
+		p.unset(key, eventTime)
+	}
+	for key, amount := range operations.Add {
+		p.add(key, amount, eventTime)
+	}
+	for key, value := range operations.Append {
+		p.appendValues(key, value, eventTime, false)
+	}
+	for key, value := range operations.Union {
+		p.appendValues(key, value, eventTime, true)
+	}
+}
+
+// Merge moves the properties of a merged person into p, as if they were set
+// at the time the merged person got them.
+func (p *Person) Merge(source *Person) {
+	p.ensureMaps()
+	for key, value := range source.Properties {
+		setAt := source.PropertyTimestamps[key]
+		if onceAt, ok := source.OnceTimestamps[key]; ok {
+			p.setOnce(key, value, onceAt)
+			continue
+		}
+		p.setLatest(key, value, setAt)
+	}
+}
+
+func (p *Person) ensureMaps() {
+	if p.Properties == nil {
+		p.Properties = make(PersonProperties)
+	}
+	if p.PropertyTimestamps == nil {
+		p.PropertyTimestamps = make(PropertyTimestamps)
+	}
+	if p.OnceTimestamps == nil {
+		p.OnceTimestamps = make(PropertyTimestamps)
+	}
+}
+
+// decidedAfter reports whether the value of key was decided after eventTime.
+func (p *Person) decidedAfter(key string, eventTime time.Time) bool {
+	decidedAt, exists := p.PropertyTimestamps[key]
+	return exists && eventTime.Before(decidedAt)
+}
+
+// setLatest sets a property unless it was decided later. Values set by
+// $set_once are always replaced, as $set wins whatever the order.
+func (p *Person) setLatest(key string, value any, eventTime time.Time) {
+	if _, once := p.OnceTimestamps[key]; !once && p.decidedAfter(key, eventTime) {
+		return
+	}
+	p.Properties[key] = value
+	p.PropertyTimestamps[key] = eventTime
+	delete(p.OnceTimestamps, key)
+}
+
+// setOnce sets a property the person does not have, or replaces a value set
+// by a later $set_once.
+func (p *Person) setOnce(key string, value any, eventTime time.Time) {
+	if _, exists := p.Properties[key]; exists {
+		onceAt, once := p.OnceTimestamps[key]
+		if !once || !eventTime.Before(onceAt) {
+			return
+		}
+	} else if p.decidedAfter(key, eventTime) {
+		return
+	}
+	p.Properties[key] = value
+	p.PropertyTimestamps[key] = eventTime
+	p.OnceTimestamps[key] = eventTime
+}
+
+func (p *Person) unset(key string, eventTime time.Time) {
+	if p.decidedAfter(key, eventTime) {
+		return
+	}
+	delete(p.Properties, key)
+	delete(p.OnceTimestamps, key)
+	p.PropertyTimestamps[key] = eventTime
+}
+
+// add increments a numeric property, which starts at zero. Properties that
+// are not numbers are left alone.
+func (p *Person) add(key string, amount float64, eventTime time.Time) {
+	if p.decidedAfter(key, eventTime) {
+		return
+	}
+	current := 0.0
+	if value, exists := p.Properties[key]; exists {
+		number, ok := value.(float64)
+		if !ok {
+			return
+		}
+		current = number
+	}
+	p.Properties[key] = current + amount
+	delete(p.OnceTimestamps, key)
+}
+
+// appendValues appends a value, or each value of a list, to a list property,
+// which starts empty. With unique, values the list contains already are
+// skipped. Properties that are not lists are left alone.
+func (p *Person) appendValues(key string, value any, eventTime time.Time, unique bool) {
+	if p.decidedAfter(key, eventTime) {
+		return
+	}
+	list := make([]any, 0)
+	if current, exists := p.Properties[key]; exists {
+		existing, ok := current.([]any)
+		if !ok {
+			return
+		}
+		list = slices.Clone(existing)
+	}
+	values, ok := value.([]any)
+	if !ok {
+		values = []any{value}
+	}
+	for _, item := range values {
+		if unique && slices.ContainsFunc(list, func(existing any) bool { return reflect.DeepEqual(existing, item) }) {
+			continue
+		}
+		list = append(list, item)
+	}
+	p.Properties[key] = list
+	delete(p.OnceTimestamps, key)
+}
+
+func (o PropertyOperations) Value() (driver.Value, error) {
+	if o.IsEmpty() {
+		return nil, nil
+	}
+	data, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (o *PropertyOperations) Scan(value interface{}) error {
+	*o = PropertyOperations{}
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data = encoded
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("PropertyOperations has invalid type: %T", v)
+	}
+	return json.Unmarshal(data, o)
+}
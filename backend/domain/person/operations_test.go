@@ -0,0 +1,50 @@
+package person
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestApplyOrdersUpdatesByTimestamp(t *testing.T) {
+	t1 := time.Date(2026, 5, 9, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	t3 := t2.Add(time.Minute)
+	p := &Person{Id: "person"}
+
+	p.Apply(nil, PropertyOperations{Once: PersonProperties{"utm_source": "mail"}}, t2)
+	p.Apply(nil, PropertyOperations{Once: PersonProperties{"utm_source": "ads"}}, t1)
+	p.Apply(nil, PropertyOperations{Once: PersonProperties{"utm_source": "seo"}}, t3)
+	assert.Equal(t, "ads", p.Properties["utm_source"])
+
+	p.Apply(PersonProperties{"plan": "pro"}, PropertyOperations{}, t3)
+	p.Apply(nil, PropertyOperations{Unset: []string{"plan"}}, t2)
+	assert.Equal(t, "pro", p.Properties["plan"])
+	p.Apply(nil, PropertyOperations{Unset: []string{"utm_source"}}, t2)
+	p.Apply(nil, PropertyOperations{Once: PersonProperties{"utm_source": "ads"}}, t1)
+	_, exists := p.Properties["utm_source"]
+	assert.False(t, exists)
+
+	p.Apply(PersonProperties{"plan": "free"}, PropertyOperations{Once: PersonProperties{"plan": "trial"}}, t3.Add(time.Minute))
+	assert.Equal(t, "free", p.Properties["plan"])
+}
+
+func TestApplyIncrementsAndAppends(t *testing.T) {
+	t1 := time.Date(2026, 5, 9, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	p := &Person{Id: "person"}
+
+	p.Apply(PersonProperties{"visits": 10.0, "name": "Jon"}, PropertyOperations{}, t2)
+	p.Apply(nil, PropertyOperations{Add: map[string]float64{"visits": 5, "name": 1}}, t1)
+	p.Apply(nil, PropertyOperations{Add: map[string]float64{"visits": 1, "purchases": 2}}, t2)
+	assert.Equal(t, 11.0, p.Properties["visits"])
+	assert.Equal(t, 2.0, p.Properties["purchases"])
+	assert.Equal(t, "Jon", p.Properties["name"])
+
+	p.Apply(nil, PropertyOperations{Append: PersonProperties{"pages": []any{"/", "/pricing"}}}, t2)
+	p.Apply(nil, PropertyOperations{Append: PersonProperties{"pages": "/"}}, t2)
+	p.Apply(nil, PropertyOperations{Union: PersonProperties{"pages": []any{"/", "/docs"}, "name": "x"}}, t2)
+	assert.DeepEqual(t, []any{"/", "/pricing", "/", "/docs"}, p.Properties["pages"])
+	assert.Equal(t, "Jon", p.Properties["name"])
+}
@@ -11,24 +11,13 @@ type PersonProperties map[string]any
 type PropertyTimestamps map[string]time.Time
 
 type Person struct {
-	Id                 string
-	FirstSeen          time.Time
-	Properties         PersonProperties
-	PropertyTimestamps PropertyTimestamps
-}
-
-func (p PersonProperties) ApplyLatestProps(props PersonProperties, timestamps PropertyTimestamps, eventTime time.Time) bool {
-	updated := false
-	for key, value := range props {
-		currentTimestamp, exists := timestamps[key]
-		if exists && eventTime.Before(currentTimestamp) {
-			continue
-		}
-		p[key] = value
-		timestamps[key] = eventTime
-		updated = true
-	}
-	return updated
+	Id                 string             `json:"id"`
+	FirstSeen          time.Time          `json:"firstSeen"`
+	Properties         PersonProperties   `json:"properties"`
+	PropertyTimestamps PropertyTimestamps `json:"propertyTimestamps"`
+	// OnceTimestamps are the times of the properties whose value was set by
+	// $set_once, which an earlier $set_once may still replace.
+	OnceTimestamps PropertyTimestamps `json:"-"`
 }
 
 func (p PersonProperties) Value() (driver.Value, error) {
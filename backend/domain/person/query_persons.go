@@ -0,0 +1,232 @@
+package person
+
+import (
+	"analytics/database/analyticsdb"
+	"analytics/domain/queries"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PropertyFilter compares a person property with a value, e.g. the query
+// parameter properties.plan__eq=pro.
+type PropertyFilter struct {
+	Key       string
+	Operation queries.OperationType
+	Value     string
+}
+
+// ListParams select a page of persons. Search matches the id and the values of
+// all properties, ignoring case.
+type ListParams struct {
+	Search  string
+	Filters []PropertyFilter
+	Limit   int
+	Offset  int
+}
+
+// ParsePropertyFilter reads a filter from a query parameter of the form
+// properties.<key>__<operation>.
+func ParsePropertyFilter(param string, value string) (PropertyFilter, error) {
+	field, operation, ok := strings.Cut(param, "__")
+	key, found := strings.CutPrefix(field, "properties.")
+	if !ok || !found || key == "" {
+		return PropertyFilter{}, fmt.Errorf("unknown filter: %s", param)
+	}
+	if strings.ContainsAny(key, `"\`) {
+		return PropertyFilter{}, fmt.Errorf("property %q is not supported", key)
+	}
+	filter := PropertyFilter{Key: key, Operation: queries.OperationType(operation), Value: value}
+	if _, exists := queries.GetOperation(filter.Operation); !exists {
+		return PropertyFilter{}, fmt.Errorf("unknown operation: %s", operation)
+	}
+	if queries.NumericOperations[filter.Operation] {
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return PropertyFilter{}, fmt.Errorf("%s needs a number", param)
+		}
+	}
+	return filter, nil
+}
+
+// mergedIdsSQL excludes the persons that were merged into another person.
+const mergedIdsSQL = "id NOT IN (SELECT distinct_id FROM person_distinct_ids)"
+
+// List returns a page of the persons matching the params, the latest first,
+// and the number of all matching persons. Merged persons are left out, their
+// properties are part of the person they were merged into.
+func List(dbd analyticsdb.DuckDB, params ListParams) ([]Person, int64, error) {
+	conditions := []string{mergedIdsSQL}
+	args := make([]any, 0)
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if params.Search != "" {
+		pattern := arg("%" + params.Search + "%")
+		conditions = append(conditions, fmt.Sprintf("(id ILIKE %s OR CAST(properties AS TEXT) ILIKE %s)", pattern, pattern))
+	}
+	for _, filter := range params.Filters {
+		condition, err := filterSQL(filter, arg)
+		if err != nil {
+			return nil, 0, err
+		}
+		conditions = append(conditions, condition)
+	}
+	where := strings.Join(conditions, " AND ")
+
+	tx, err := dbd.Tx()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Commit()
+
+	var total int64
+	if err := tx.QueryRow("SELECT count(*) FROM persons WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, first_seen, properties, property_timestamps
+		FROM persons
+		WHERE %s
+		ORDER BY first_seen DESC, id
+		LIMIT %s OFFSET %s
+	`, where, arg(params.Limit), arg(params.Offset))
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	persons := make([]Person, 0)
+	for rows.Next() {
+		var person Person
+		if err := rows.Scan(&person.Id, &person.FirstSeen, &person.Properties, &person.PropertyTimestamps); err != nil {
+			return nil, 0, err
+		}
+		persons = append(persons, person)
+	}
+	return persons, total, rows.Err()
+}
+
+func filterSQL(filter PropertyFilter, arg func(value any) string) (string, error) {
+	operation, exists := queries.GetOperation(filter.Operation)
+	if !exists {
+		return "", fmt.Errorf("unknown operation: %s", filter.Operation)
+	}
+	value := fmt.Sprintf("json_extract_string(properties, %s)", arg(`$."`+filter.Key+`"`))
+	switch filter.Operation {
+	case queries.In, queries.NotIn:
+		values := strings.Split(filter.Value, ",")
+		placeholders := make([]string, len(values))
+		for i, item := range values {
+			placeholders[i] = arg(item)
+		}
+		return fmt.Sprintf("%s %s (%s)", value, operation.SQL, strings.Join(placeholders, ", ")), nil
+	case queries.Contains:
+		return fmt.Sprintf("%s ILIKE '%%' || %s || '%%'", value, arg(filter.Value)), nil
+	}
+	if queries.NumericOperations[filter.Operation] {
+		number, err := strconv.ParseFloat(filter.Value, 64)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("try_cast(%s AS DOUBLE) %s %s", value, operation.SQL, arg(number)), nil
+	}
+	return fmt.Sprintf("%s %s %s", value, operation.SQL, arg(filter.Value)), nil
+}
+
+// Session is a session of a person.
+type Session struct {
+	Id        string    `json:"id"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// Profile is a person together with the ids that were merged into it and its
+// latest sessions.
+type Profile struct {
+	Person
+	DistinctIds  []string  `json:"distinctIds"`
+	SessionCount int64     `json:"sessionCount"`
+	Sessions     []Session `json:"sessions"`
+}
+
+// CanonicalId returns the id of the person an id was merged into, or the id
+// itself.
+func CanonicalId(dbd analyticsdb.DuckDB, id string) (string, error) {
+	tx, err := dbd.Tx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Commit()
+	var personId string
+	err = tx.QueryRow("SELECT person_id FROM person_distinct_ids WHERE distinct_id = $1", id).Scan(&personId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return id, nil
+	}
+	return personId, err
+}
+
+// GetProfile reads the profile of a person by its canonical id with up to
+// sessionLimit of its sessions, the latest first. It is nil if there is no
+// such person.
+func GetProfile(dbd analyticsdb.DuckDB, id string, sessionLimit int) (*Profile, error) {
+	tx, err := dbd.Tx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	profile := Profile{DistinctIds: make([]string, 0), Sessions: make([]Session, 0)}
+	err = tx.QueryRow(`
+		SELECT id, first_seen, properties, property_timestamps
+		FROM persons
+		WHERE id = $1
+	`, id).Scan(&profile.Id, &profile.FirstSeen, &profile.Properties, &profile.PropertyTimestamps)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT distinct_id FROM person_distinct_ids WHERE person_id = $1 ORDER BY merged_at", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var distinctId string
+		if err := rows.Scan(&distinctId); err != nil {
+			return nil, err
+		}
+		profile.DistinctIds = append(profile.DistinctIds, distinctId)
+	}
+
+	if err := tx.QueryRow("SELECT count(*) FROM sessions WHERE person_id = $1", id).Scan(&profile.SessionCount); err != nil {
+		return nil, err
+	}
+	sessionRows, err := tx.Query(`
+		SELECT id, first_seen, last_seen
+		FROM sessions
+		WHERE person_id = $1
+		ORDER BY last_seen DESC
+		LIMIT $2
+	`, id, sessionLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer sessionRows.Close()
+	for sessionRows.Next() {
+		var session Session
+		if err := sessionRows.Scan(&session.Id, &session.FirstSeen, &session.LastSeen); err != nil {
+			return nil, err
+		}
+		profile.Sessions = append(profile.Sessions, session)
+	}
+	return &profile, sessionRows.Err()
+}
@@ -0,0 +1,93 @@
+package privacy
+
+import (
+	"analytics/domain/events"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Enforcer applies the property policies of a project to events.
+type Enforcer struct {
+	policies []PropertyPolicy
+	secret   []byte
+}
+
+func NewEnforcer(policies []PropertyPolicy, secret string) *Enforcer {
+	return &Enforcer{policies: policies, secret: []byte(secret)}
+}
+
+// Apply rewrites the properties, person properties and group properties of
+// event in place. The first matching policy wins. Maps that no policy matches
+// are searched for matching nested keys. Group properties are matched without
+// their group type.
+func (e *Enforcer) Apply(event *events.EventInput) {
+	e.applyToMap(event.Properties, PropertiesScope, "")
+	e.applyToMap(event.PersonProperties, PersonPropertiesScope, "")
+	e.applyToMap(event.PersonPropertiesOnce, PersonPropertiesScope, "")
+	e.applyToMap(event.PersonPropertiesAppend, PersonPropertiesScope, "")
+	e.applyToMap(event.PersonPropertiesUnion, PersonPropertiesScope, "")
+	for _, properties := range event.GroupProperties {
+		e.applyToMap(properties, GroupPropertiesScope, "")
+	}
+}
+
+func (e *Enforcer) applyToMap(properties map[string]any, scope PolicyScope, prefix string) {
+	for key, value := range properties {
+		fullKey := prefix + key
+		policy, found := e.find(scope, fullKey)
+		if !found {
+			if nested, ok := value.(map[string]any); ok {
+				e.applyToMap(nested, scope, fullKey+".")
+			}
+			continue
+		}
+		switch policy.Action {
+		case Drop:
+			delete(properties, key)
+		case Redact:
+			properties[key] = RedactedValue
+		case Truncate:
+			if text, ok := value.(string); ok {
+				properties[key] = truncate(text, policy.Length)
+			}
+		case Hash:
+			if value != nil {
+				properties[key] = e.hash(value)
+			}
+		}
+	}
+}
+
+func (e *Enforcer) find(scope PolicyScope, key string) (PropertyPolicy, bool) {
+	for _, policy := range e.policies {
+		if policy.Matches(scope, key) {
+			return policy, true
+		}
+	}
+	return PropertyPolicy{}, false
+}
+
+func (e *Enforcer) hash(value any) string {
+	text, ok := value.(string)
+	if !ok {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded = []byte(fmt.Sprint(value))
+		}
+		text = string(encoded)
+	}
+	mac := hmac.New(sha256.New, e.secret)
+	mac.Write([]byte(text))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length])
+}
@@ -0,0 +1,109 @@
+package privacy
+
+import (
+	"analytics/domain/projects"
+	"analytics/domain/schema"
+	"analytics/util"
+	"gorm.io/gorm"
+)
+
+func ListPolicies(db *gorm.DB) ([]PropertyPolicy, error) {
+	var policies []PropertyPolicy
+	err := db.Order("id").Find(&policies).Error
+	return policies, err
+}
+
+func GetPolicy(db *gorm.DB, id uint) (*PropertyPolicy, error) {
+	var policy PropertyPolicy
+	if err := db.First(&policy, id).Error; err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func CreatePolicy(db *gorm.DB, input PolicyInput) (*PropertyPolicy, error) {
+	policy := PropertyPolicy{PolicyInput: input}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&policy).Error; err != nil {
+			return err
+		}
+		return purgeSchemaValues(tx, policy)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func UpdatePolicy(db *gorm.DB, id uint, input PolicyInput) (*PropertyPolicy, error) {
+	policy, err := GetPolicy(db, id)
+	if err != nil {
+		return nil, err
+	}
+	policy.PolicyInput = input
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(policy).Error; err != nil {
+			return err
+		}
+		return purgeSchemaValues(tx, *policy)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func DeletePolicy(db *gorm.DB, id uint) error {
+	result := db.Delete(&PropertyPolicy{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// purgeSchemaValues removes the values that were recorded for the event
+// properties a policy matches before it existed. The schema only records
+// values of top level properties, which is what the policy is matched to.
+func purgeSchemaValues(db *gorm.DB, policy PropertyPolicy) error {
+	if policy.Scope == PersonPropertiesScope || policy.Scope == GroupPropertiesScope {
+		return nil
+	}
+	var properties []schema.EventSchemaProperty
+	if err := db.Find(&properties).Error; err != nil {
+		return err
+	}
+	ids := make([]int, 0)
+	for _, property := range properties {
+		if policy.Matches(PropertiesScope, property.Key) {
+			ids = append(ids, property.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return db.Where("event_schema_property_id IN ?", ids).Delete(&schema.EventSchemaPropertyValue{}).Error
+}
+
+// HashSecret returns the key used for hmac policies of a project. It is
+// generated when it is first needed and kept in the project settings, so the
+// same value always hashes to the same result.
+func HashSecret(projectId string, db *gorm.DB) (string, error) {
+	settings, err := projects.QuerySettings(projectId, db)
+	if err != nil {
+		return "", err
+	}
+	if secret := settings[projects.PropertyHashSecret]; secret != "" {
+		return secret, nil
+	}
+	secret, err := util.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+	if err := projects.UpdateSetting(db, projects.PropertyHashSecret, secret); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
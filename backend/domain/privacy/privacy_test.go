@@ -0,0 +1,97 @@
+package privacy
+
+import (
+	"analytics/database/testsetup"
+	"analytics/domain/events"
+	"analytics/domain/projects"
+	"analytics/domain/schema"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestEnforcerAppliesFirstMatchingPolicy(t *testing.T) {
+	enforcer := NewEnforcer([]PropertyPolicy{
+		{PolicyInput: PolicyInput{Pattern: "email", Action: Hash}},
+		{PolicyInput: PolicyInput{Pattern: "*.phone", Action: Redact}},
+		{PolicyInput: PolicyInput{Pattern: "$ip", Action: Drop}},
+		{PolicyInput: PolicyInput{Pattern: "name", Scope: PersonPropertiesScope, Action: Truncate, Length: 3}},
+	}, "secret")
+
+	event := &events.EventInput{
+		Properties: map[string]any{
+			"email":   "a@example.com",
+			"$ip":     "1.2.3.4",
+			"contact": map[string]any{"phone": "+49 123"},
+			"name":    "Jonathan",
+		},
+		PersonProperties:     map[string]any{"email": "a@example.com", "name": "Jonathan"},
+		PersonPropertiesOnce: map[string]any{"name": "Jonathan"},
+	}
+	enforcer.Apply(event)
+
+	hashed := event.Properties["email"].(string)
+	assert.Equal(t, 64, len(hashed))
+	assert.Equal(t, hashed, event.PersonProperties["email"])
+	_, hasIp := event.Properties["$ip"]
+	assert.False(t, hasIp)
+	assert.Equal(t, RedactedValue, event.Properties["contact"].(map[string]any)["phone"])
+	assert.Equal(t, "Jonathan", event.Properties["name"])
+	assert.Equal(t, "Jon", event.PersonProperties["name"])
+	assert.Equal(t, "Jon", event.PersonPropertiesOnce["name"])
+}
+
+func TestEnforcerAppliesPoliciesToGroupProperties(t *testing.T) {
+	enforcer := NewEnforcer([]PropertyPolicy{
+		{PolicyInput: PolicyInput{Pattern: "email", Action: Redact}},
+		{PolicyInput: PolicyInput{Pattern: "name", Scope: GroupPropertiesScope, Action: Truncate, Length: 3}},
+		{PolicyInput: PolicyInput{Pattern: "plan", Scope: PersonPropertiesScope, Action: Drop}},
+	}, "secret")
+
+	event := &events.EventInput{
+		Properties: map[string]any{"name": "Jonathan"},
+		GroupProperties: map[string]map[string]any{
+			"company": {"email": "billing@example.com", "name": "Example Inc", "plan": "pro"},
+		},
+	}
+	enforcer.Apply(event)
+
+	company := event.GroupProperties["company"]
+	assert.Equal(t, RedactedValue, company["email"])
+	assert.Equal(t, "Exa", company["name"])
+	assert.Equal(t, "pro", company["plan"])
+	assert.Equal(t, "Jonathan", event.Properties["name"])
+}
+
+func TestCreatePolicyPurgesSchemaValues(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{ProjectDB: true})
+	db := setup.ProjectDB
+	assert.NoError(t, db.AutoMigrate(
+		&PropertyPolicy{},
+		&projects.ProjectSetting{},
+		&schema.EventSchema{},
+		&schema.EventSchemaProperty{},
+		&schema.EventSchemaPropertyValue{},
+	))
+	assert.NoError(t, db.Create(&schema.EventSchema{
+		EventType: "signup",
+		Properties: []schema.EventSchemaProperty{
+			{Key: "email", Type: "string", Values: []schema.EventSchemaPropertyValue{{Value: "a@example.com"}}},
+			{Key: "plan", Type: "string", Values: []schema.EventSchemaPropertyValue{{Value: "pro"}}},
+		},
+	}).Error)
+
+	_, err := CreatePolicy(db, PolicyInput{Pattern: "email", Action: Redact})
+	assert.NoError(t, err)
+
+	var values []schema.EventSchemaPropertyValue
+	assert.NoError(t, db.Find(&values).Error)
+	assert.Equal(t, 1, len(values))
+	assert.Equal(t, "pro", values[0].Value)
+
+	first, err := HashSecret("project", db)
+	assert.NoError(t, err)
+	second, err := HashSecret("project", db)
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+}
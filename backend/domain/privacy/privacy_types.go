@@ -0,0 +1,85 @@
+package privacy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+type PolicyAction string
+
+const (
+	// Redact replaces the value with RedactedValue.
+	Redact PolicyAction = "redact"
+	// Drop removes the property.
+	Drop PolicyAction = "drop"
+	// Truncate keeps the first Length characters of string values.
+	Truncate PolicyAction = "truncate"
+	// Hash replaces the value with its HMAC-SHA256, so it can still be
+	// counted and compared without being readable.
+	Hash PolicyAction = "hmac"
+)
+
+const RedactedValue = "[redacted]"
+
+type PolicyScope string
+
+const (
+	// AllScopes applies a policy to properties, person properties and group
+	// properties.
+	AllScopes             PolicyScope = ""
+	PropertiesScope       PolicyScope = "properties"
+	PersonPropertiesScope PolicyScope = "personProperties"
+	GroupPropertiesScope  PolicyScope = "groupProperties"
+)
+
+type PolicyInput struct {
+	// Pattern is matched against the property key. Nested keys are joined by
+	// dots and * matches any sequence of characters, e.g. "*.phone".
+	Pattern string       `json:"pattern" gorm:"not null"`
+	Scope   PolicyScope  `json:"scope"`
+	Action  PolicyAction `json:"action" gorm:"not null"`
+	Length  int          `json:"length"`
+}
+
+type PropertyPolicy struct {
+	ID uint `gorm:"primarykey" json:"id"`
+	PolicyInput
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (input PolicyInput) Validate() error {
+	if strings.TrimSpace(input.Pattern) == "" {
+		return errors.New("pattern is required")
+	}
+	if _, err := path.Match(input.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", input.Pattern, err)
+	}
+	switch input.Scope {
+	case AllScopes, PropertiesScope, PersonPropertiesScope, GroupPropertiesScope:
+	default:
+		return fmt.Errorf("scope must be empty, %q, %q or %q", PropertiesScope, PersonPropertiesScope, GroupPropertiesScope)
+	}
+	switch input.Action {
+	case Redact, Drop, Hash:
+		return nil
+	case Truncate:
+		if input.Length <= 0 {
+			return errors.New("truncate needs a positive length")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", input.Action)
+}
+
+// Matches tells whether the policy applies to the property at key in scope.
+func (p PropertyPolicy) Matches(scope PolicyScope, key string) bool {
+	if p.Scope != AllScopes && p.Scope != scope {
+		return false
+	}
+	matched, _ := path.Match(p.Pattern, key)
+	return matched
+}
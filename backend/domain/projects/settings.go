@@ -10,6 +10,22 @@ const (
 	Partition     ProjectSettingKey = "partition"
 	AutoLoadRange ProjectSettingKey = "autoload"
 	CorsOrigins   ProjectSettingKey = "cors_origins"
+	QueueCapacity ProjectSettingKey = "queue_capacity"
+	BotFilter     ProjectSettingKey = "bot_filter"
+	// RateLimit overrides the configured number of events the project can
+	// send per second.
+	RateLimit ProjectSettingKey = "rate_limit"
+	// MonthlyQuota overrides the configured number of events the project can
+	// send per calendar month.
+	MonthlyQuota ProjectSettingKey = "monthly_quota"
+	// TimestampPolicy, TimestampMaxAge and TimestampMaxFuture override the
+	// configured handling of implausible event timestamps.
+	TimestampPolicy    ProjectSettingKey = "timestamp_policy"
+	TimestampMaxAge    ProjectSettingKey = "timestamp_max_age"
+	TimestampMaxFuture ProjectSettingKey = "timestamp_max_future"
+	// PropertyHashSecret is the key of the hmac property policies. It is
+	// generated on first use.
+	PropertyHashSecret ProjectSettingKey = "property_hash_secret"
 )
 
 func QuerySettings(projectId string, db *gorm.DB) (map[ProjectSettingKey]string, error) {
@@ -24,10 +40,18 @@ func QuerySettings(projectId string, db *gorm.DB) (map[ProjectSettingKey]string,
 	}
 
 	defaults := map[ProjectSettingKey]string{
-		Name:          projectId,
-		Partition:     "",
-		AutoLoadRange: "6",
-		CorsOrigins:   "",
+		Name:               projectId,
+		Partition:          "",
+		AutoLoadRange:      "6",
+		CorsOrigins:        "",
+		QueueCapacity:      "",
+		BotFilter:          "drop",
+		PropertyHashSecret: "",
+		RateLimit:          "",
+		MonthlyQuota:       "",
+		TimestampPolicy:    "",
+		TimestampMaxAge:    "",
+		TimestampMaxFuture: "",
 	}
 
 	for key, defaultValue := range defaults {
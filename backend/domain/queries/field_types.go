@@ -67,7 +67,7 @@ func (h JSONFieldHandler) Parse(value string, operation OperationType) (interfac
 
 func (h StringFieldHandler) FormatSQL(field, _ string, _ OperationType) string {
 	if field == "person_id" {
-		return "coalesce(events.person_id, sessions.person_id)"
+		return PersonIdSQL
 	}
 	return field
 }
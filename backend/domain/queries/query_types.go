@@ -77,16 +77,27 @@ func createCondition(field string, operation OperationType, value string) (Query
 		JSONProperty: jsonProperty,
 	}, nil
 }
+
+// PersonIdSQL resolves the person of an event to the person it was merged
+// into. Anonymous events get the person their session was linked to or their
+// anonymous id was merged into.
+const PersonIdSQL = "coalesce(merged.person_id, events.person_id, sessions.person_id)"
+
+// EventsFromSQL joins the sessions and merged ids PersonIdSQL needs to the
+// events.
+const EventsFromSQL = `from events events
+left join sessions sessions on sessions.id = events.session_id
+left join person_distinct_ids merged on merged.distinct_id = coalesce(events.person_id, sessions.person_id, events.session_id)`
+
 func BuildSQL(params *QueryParams) (string, []interface{}) {
 	query := `
 select events.id,
        events.timestamp,
        events.event_type,
        events.session_id,
-       coalesce(events.person_id, sessions.person_id) as person_id,
+       ` + PersonIdSQL + ` as person_id,
        events.properties
-from events events
-left join sessions sessions on sessions.id = events.session_id
+` + EventsFromSQL + `
 where 1=1
 `
 	var args []interface{}
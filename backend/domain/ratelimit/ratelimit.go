@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket that refills with Rate tokens per second up to Burst
+// tokens. A Rate of 0 disables the limit.
+type Limit struct {
+	Key   string
+	Rate  float64
+	Burst float64
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter keeps the token buckets of all limits by their key.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+// pruneInterval is how often buckets that refilled completely are removed, so
+// that keys that stopped sending do not pile up.
+const pruneInterval = time.Minute
+
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// Take removes n tokens from the buckets of all limits, or from none of them if
+// one of the buckets does not hold enough tokens. It then reports how long
+// the caller should wait before trying again.
+//
+// A bucket that is full always admits, even if n exceeds its burst. The
+// tokens go negative and the bucket stays closed until the debt is repaid, so
+// large batches are slowed down instead of being rejected forever.
+func (l *Limiter) Take(now time.Time, n int, limits ...Limit) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+
+	var retryAfter time.Duration
+	for _, limit := range limits {
+		if limit.Rate <= 0 {
+			continue
+		}
+		b := l.refill(now, limit)
+		needed := math.Min(float64(n), limit.Burst)
+		if b.tokens >= needed {
+			continue
+		}
+		wait := time.Duration((needed - b.tokens) / limit.Rate * float64(time.Second))
+		if wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	for _, limit := range limits {
+		if limit.Rate <= 0 {
+			continue
+		}
+		l.buckets[limit.Key].tokens -= float64(n)
+	}
+	return true, 0
+}
+
+// Return gives back n tokens that Take removed from the buckets of the limits,
+// e.g. when the events they were taken for could not be ingested.
+func (l *Limiter) Return(n int, limits ...Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, limit := range limits {
+		if limit.Rate <= 0 {
+			continue
+		}
+		if b, ok := l.buckets[limit.Key]; ok {
+			b.tokens = math.Min(limit.Burst, b.tokens+float64(n))
+		}
+	}
+}
+
+func (l *Limiter) refill(now time.Time, limit Limit) *bucket {
+	b, ok := l.buckets[limit.Key]
+	if !ok {
+		b = &bucket{tokens: limit.Burst, updated: now}
+		l.buckets[limit.Key] = b
+		return b
+	}
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(limit.Burst, b.tokens+elapsed*limit.Rate)
+		b.updated = now
+	}
+	return b
+}
+
+// prune removes the buckets that were not used for longer than pruneInterval
+// and are not in debt. They are recreated full on their next use.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < pruneInterval {
+		return
+	}
+	l.pruned = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) > pruneInterval && b.tokens >= 0 {
+			delete(l.buckets, key)
+		}
+	}
+}
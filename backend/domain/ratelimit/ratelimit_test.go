@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestTakeRefillsAtRate(t *testing.T) {
+	limiter := NewLimiter()
+	limit := Limit{Key: "key", Rate: 10, Burst: 20}
+	now := time.Now()
+
+	ok, _ := limiter.Take(now, 20, limit)
+	assert.True(t, ok)
+	ok, retryAfter := limiter.Take(now, 5, limit)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	ok, _ = limiter.Take(now.Add(500*time.Millisecond), 5, limit)
+	assert.True(t, ok)
+}
+
+func TestTakeAdmitsLargeBatchIntoDebt(t *testing.T) {
+	limiter := NewLimiter()
+	limit := Limit{Key: "key", Rate: 10, Burst: 20}
+	now := time.Now()
+
+	ok, _ := limiter.Take(now, 50, limit)
+	assert.True(t, ok)
+	ok, retryAfter := limiter.Take(now, 1, limit)
+	assert.False(t, ok)
+	assert.Equal(t, 3100*time.Millisecond, retryAfter)
+}
+
+func TestTakeConsumesAllOrNothing(t *testing.T) {
+	limiter := NewLimiter()
+	key := Limit{Key: "key", Rate: 100, Burst: 100}
+	project := Limit{Key: "project", Rate: 10, Burst: 10}
+	disabled := Limit{Key: "disabled"}
+	now := time.Now()
+
+	ok, _ := limiter.Take(now, 10, key, project, disabled)
+	assert.True(t, ok)
+	ok, _ = limiter.Take(now, 10, key, project, disabled)
+	assert.False(t, ok)
+
+	ok, _ = limiter.Take(now, 90, key)
+	assert.True(t, ok)
+}
+
+func TestReturnedTokensCanBeTakenAgain(t *testing.T) {
+	limiter := NewLimiter()
+	limit := Limit{Key: "key", Rate: 1, Burst: 10}
+	now := time.Now()
+
+	ok, _ := limiter.Take(now, 10, limit)
+	assert.True(t, ok)
+	limiter.Return(10, limit)
+	ok, _ = limiter.Take(now, 10, limit)
+	assert.True(t, ok)
+
+	limiter.Return(20, limit)
+	ok, _ = limiter.Take(now, 11, limit)
+	assert.True(t, ok)
+	ok, _ = limiter.Take(now, 1, limit)
+	assert.False(t, ok)
+}
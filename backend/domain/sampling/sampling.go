@@ -0,0 +1,104 @@
+package sampling
+
+import (
+	"analytics/domain/events"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// WeightProperty holds the number of events a sampled event stands for.
+// Aggregations multiply by it to scale counts back up.
+const WeightProperty = "$sample_weight"
+
+// RateProperty holds the rate an event was sampled at by the server. It marks
+// events whose weight already includes the rate.
+const RateProperty = "$sample_rate"
+
+// SamplingRule keeps the share Rate of the events of EventType.
+type SamplingRule struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	EventType string    `gorm:"uniqueIndex;not null" json:"eventType"`
+	Rate      float64   `gorm:"not null" json:"rate"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (r SamplingRule) Validate() error {
+	if r.EventType == "" {
+		return errors.New("eventType is required")
+	}
+	if r.Rate <= 0 || r.Rate > 1 {
+		return errors.New("rate must be greater than 0 and at most 1")
+	}
+	return nil
+}
+
+func ListRules(db *gorm.DB) ([]SamplingRule, error) {
+	var rules []SamplingRule
+	err := db.Order("event_type").Find(&rules).Error
+	return rules, err
+}
+
+// SaveRule creates the rule for an event type or replaces its rate.
+func SaveRule(db *gorm.DB, rule SamplingRule) (*SamplingRule, error) {
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "event_type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+	}).Create(&rule).Error
+	if err != nil {
+		return nil, err
+	}
+	var saved SamplingRule
+	if err := db.Where("event_type = ?", rule.EventType).First(&saved).Error; err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func DeleteRule(db *gorm.DB, id uint) error {
+	result := db.Delete(&SamplingRule{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Keep decides whether an event is kept at rate. The decision is derived from
+// the event's uuid, so retries and replays of an event are sampled the same
+// way.
+func Keep(event *events.EventInput, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if event.Uuid == nil {
+		return false
+	}
+	// The uuid's version and variant bits are fixed, so it is hashed to
+	// spread the ids evenly.
+	hash := fnv.New64a()
+	hash.Write(event.Uuid[:])
+	return float64(hash.Sum64())/math.MaxUint64 < rate
+}
+
+// SetWeight records on a kept event how many events it stands for. Weights
+// of events that were already sampled by the client are multiplied. Events
+// that were sampled by the server before keep their weight, so running the
+// pipeline on an event again does not apply the rate twice.
+func SetWeight(event *events.EventInput, rate float64) {
+	if _, sampled := event.Properties[RateProperty]; sampled {
+		return
+	}
+	weight := 1 / rate
+	if existing, ok := event.Properties[WeightProperty].(float64); ok && existing > 0 {
+		weight *= existing
+	}
+	event.Properties[WeightProperty] = weight
+	event.Properties[RateProperty] = rate
+}
@@ -0,0 +1,58 @@
+package sampling
+
+import (
+	"analytics/database/testsetup"
+	"analytics/domain/events"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zeebo/assert"
+)
+
+func TestKeepSamplesByRate(t *testing.T) {
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		id := uuid.New()
+		event := &events.EventInput{Uuid: &id}
+		if Keep(event, 0.1) {
+			kept++
+		}
+		assert.Equal(t, Keep(event, 0.1), Keep(event, 0.1))
+	}
+	assert.True(t, kept > 800 && kept < 1200)
+}
+
+func TestSetWeightMultipliesClientWeights(t *testing.T) {
+	event := &events.EventInput{Properties: map[string]any{}}
+	SetWeight(event, 0.25)
+	assert.Equal(t, 4.0, event.Properties[WeightProperty])
+
+	clientSampled := &events.EventInput{Properties: map[string]any{WeightProperty: 2.0}}
+	SetWeight(clientSampled, 0.5)
+	assert.Equal(t, 4.0, clientSampled.Properties[WeightProperty])
+	assert.Equal(t, 0.5, clientSampled.Properties[RateProperty])
+}
+
+func TestSetWeightAppliesTheRateOnce(t *testing.T) {
+	event := &events.EventInput{Properties: map[string]any{WeightProperty: 2.0}}
+	SetWeight(event, 0.5)
+	SetWeight(event, 0.5)
+	assert.Equal(t, 4.0, event.Properties[WeightProperty])
+}
+
+func TestSaveRuleReplacesRate(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{ProjectDB: true})
+	db := setup.ProjectDB
+	assert.NoError(t, db.AutoMigrate(&SamplingRule{}))
+
+	first, err := SaveRule(db, SamplingRule{EventType: "scroll", Rate: 0.1})
+	assert.NoError(t, err)
+	second, err := SaveRule(db, SamplingRule{EventType: "scroll", Rate: 0.5})
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+
+	rules, err := ListRules(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rules))
+	assert.Equal(t, 0.5, rules[0].Rate)
+}
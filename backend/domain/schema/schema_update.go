@@ -0,0 +1,131 @@
+package schema
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"time"
+)
+
+// SchemaUpdate holds what a batch of events adds to the schemas, the
+// properties seen per event type. Applying it only adds what is missing, so
+// applying it again changes nothing.
+type SchemaUpdate map[string][]PropertyUpdate
+
+type PropertyUpdate struct {
+	Key    string   `json:"key"`
+	Type   string   `json:"type"`
+	Values []string `json:"values"`
+}
+
+func (u *SchemaUpdate) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, u)
+	case string:
+		return json.Unmarshal([]byte(v), u)
+	}
+	return fmt.Errorf("unsupported type for json column: %T", src)
+}
+
+func (u SchemaUpdate) Value() (driver.Value, error) {
+	return json.Marshal(u)
+}
+
+// PendingSchemaUpdate is recorded before the events of a batch are committed
+// and removed once the update is applied. An update left behind by a crash is
+// applied if its batch was committed and discarded otherwise.
+type PendingSchemaUpdate struct {
+	BatchId   string       `gorm:"primaryKey"`
+	Update    SchemaUpdate `gorm:"type:json;not null"`
+	CreatedAt time.Time
+}
+
+// UpdateOf collects the properties of schemas built from a batch.
+func UpdateOf(schemasByType map[string]*EventSchema) SchemaUpdate {
+	update := make(SchemaUpdate, len(schemasByType))
+	for eventType, eventSchema := range schemasByType {
+		properties := make([]PropertyUpdate, 0, len(eventSchema.Properties))
+		for _, property := range eventSchema.Properties {
+			values := make([]string, len(property.Values))
+			for i, value := range property.Values {
+				values[i] = value.Value
+			}
+			properties = append(properties, PropertyUpdate{Key: property.Key, Type: property.Type, Values: values})
+		}
+		sort.Slice(properties, func(i, j int) bool { return properties[i].Key < properties[j].Key })
+		update[eventType] = properties
+	}
+	return update
+}
+
+// SavePendingUpdate records the update of a batch. Saving a retried batch
+// again replaces its update.
+func SavePendingUpdate(db *gorm.DB, batchId string, update SchemaUpdate) error {
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&PendingSchemaUpdate{BatchId: batchId, Update: update}).Error
+}
+
+func DiscardPendingUpdate(db *gorm.DB, batchId string) error {
+	return db.Delete(&PendingSchemaUpdate{}, "batch_id = ?", batchId).Error
+}
+
+func ListPendingUpdates(db *gorm.DB) ([]PendingSchemaUpdate, error) {
+	var pending []PendingSchemaUpdate
+	err := db.Order("created_at").Find(&pending).Error
+	return pending, err
+}
+
+// ApplyPendingUpdate applies the update of a batch and removes it in one
+// transaction. Updates that were applied already are skipped.
+func ApplyPendingUpdate(db *gorm.DB, batchId string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var pending PendingSchemaUpdate
+		err := tx.First(&pending, "batch_id = ?", batchId).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := applyUpdate(tx, pending.Update); err != nil {
+			return err
+		}
+		return tx.Delete(&pending).Error
+	})
+}
+
+// applyUpdate creates missing schemas, properties and values. The type of
+// existing properties is kept.
+func applyUpdate(tx *gorm.DB, update SchemaUpdate) error {
+	for eventType, properties := range update {
+		var eventSchema EventSchema
+		if err := tx.Where(EventSchema{EventType: eventType}).FirstOrCreate(&eventSchema).Error; err != nil {
+			return err
+		}
+		for _, update := range properties {
+			var property EventSchemaProperty
+			err := tx.Where(EventSchemaProperty{EventSchemaID: eventSchema.ID, Key: update.Key}).
+				Attrs(EventSchemaProperty{Type: update.Type}).
+				FirstOrCreate(&property).Error
+			if err != nil {
+				return err
+			}
+			if len(update.Values) == 0 {
+				continue
+			}
+			values := make([]EventSchemaPropertyValue, len(update.Values))
+			for i, value := range update.Values {
+				values[i] = EventSchemaPropertyValue{EventSchemaPropertyID: property.ID, Value: value}
+			}
+			if err := PersistValues(values, tx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package schema
+
+import (
+	"gorm.io/gorm"
+)
+
+// ScrubValues removes the values of an update from the schemas, the reverse
+// of applying it. Schemas and properties are kept. It returns the number of
+// removed values.
+func ScrubValues(db *gorm.DB, update SchemaUpdate) (int64, error) {
+	var scrubbed int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for eventType, properties := range update {
+			for _, property := range properties {
+				if len(property.Values) == 0 {
+					continue
+				}
+				propertyIds := tx.Model(&EventSchemaProperty{}).
+					Select("event_schema_properties.id").
+					Joins("JOIN event_schemas ON event_schemas.id = event_schema_properties.event_schema_id").
+					Where("event_schemas.event_type = ? AND event_schema_properties.key = ?", eventType, property.Key)
+				result := tx.Where("event_schema_property_id IN (?) AND value IN ?", propertyIds, property.Values).
+					Delete(&EventSchemaPropertyValue{})
+				if result.Error != nil {
+					return result.Error
+				}
+				scrubbed += result.RowsAffected
+			}
+		}
+		return nil
+	})
+	return scrubbed, err
+}
@@ -0,0 +1,217 @@
+package trackingplan
+
+import (
+	"analytics/domain/events"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+type ViolationKind string
+
+const (
+	UnplannedEvent     ViolationKind = "unplanned_event"
+	MissingProperty    ViolationKind = "missing_property"
+	WrongType          ViolationKind = "wrong_type"
+	InvalidValue       ViolationKind = "invalid_value"
+	UnexpectedProperty ViolationKind = "unexpected_property"
+)
+
+// Violation is a way an event differs from the tracking plan. Property is the
+// path of the property, nested keys are joined by dots and array items are
+// marked by [].
+type Violation struct {
+	Kind     ViolationKind `json:"kind"`
+	Property string        `json:"property,omitempty"`
+	Message  string        `json:"message"`
+}
+
+// Checker checks events against the tracking plan of a project.
+type Checker struct {
+	plan    *TrackingPlan
+	planned map[string]*PropertySchema
+}
+
+// NewChecker prepares the plan for checking events. A nil plan checks
+// nothing.
+func NewChecker(plan *TrackingPlan) *Checker {
+	if plan == nil {
+		return nil
+	}
+	checker := &Checker{plan: plan, planned: make(map[string]*PropertySchema, len(plan.Events))}
+	for i := range plan.Events {
+		checker.planned[plan.Events[i].EventType] = &plan.Events[i].Schema
+	}
+	return checker
+}
+
+func (c *Checker) Mode() Mode {
+	if c == nil {
+		return Allow
+	}
+	return c.plan.Mode
+}
+
+// Check returns the violations of event, none if it follows the plan.
+func (c *Checker) Check(event *events.EventInput) []Violation {
+	if c == nil {
+		return nil
+	}
+	schema, ok := c.planned[event.EventType]
+	if !ok {
+		if c.plan.AllowUnplannedEvents {
+			return nil
+		}
+		return []Violation{{
+			Kind:    UnplannedEvent,
+			Message: fmt.Sprintf("event %q is not in the tracking plan", event.EventType),
+		}}
+	}
+	var violations []Violation
+	checkObject(schema, event.Properties, "", true, &violations)
+	return violations
+}
+
+// Messages lists the messages of violations.
+func Messages(violations []Violation) []string {
+	messages := make([]string, len(violations))
+	for i, violation := range violations {
+		messages[i] = violation.Message
+	}
+	return messages
+}
+
+func checkValue(schema *PropertySchema, value any, path string, violations *[]Violation) {
+	if len(schema.Type) > 0 && !slices.ContainsFunc(schema.Type, func(name string) bool { return hasType(value, name) }) {
+		*violations = append(*violations, Violation{
+			Kind:     WrongType,
+			Property: path,
+			Message:  fmt.Sprintf("property %q must be of type %s", path, strings.Join(schema.Type, " or ")),
+		})
+		return
+	}
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(allowed any) bool { return equalValues(allowed, value) }) {
+		*violations = append(*violations, Violation{
+			Kind:     InvalidValue,
+			Property: path,
+			Message:  fmt.Sprintf("property %q must be one of %s", path, formatEnum(schema.Enum)),
+		})
+		return
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		checkObject(schema, v, path+".", false, violations)
+	case []any:
+		if schema.Items != nil {
+			for _, item := range v {
+				checkValue(schema.Items, item, path+"[]", violations)
+			}
+		}
+	}
+}
+
+// checkObject checks the properties of an object. The top level properties of
+// an event may have reserved properties that start with $ in addition to the
+// planned ones.
+func checkObject(schema *PropertySchema, properties map[string]any, prefix string, topLevel bool, violations *[]Violation) {
+	for _, key := range schema.Required {
+		if _, ok := properties[key]; !ok {
+			*violations = append(*violations, Violation{
+				Kind:     MissingProperty,
+				Property: prefix + key,
+				Message:  fmt.Sprintf("property %q is required", prefix+key),
+			})
+		}
+	}
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		property, ok := schema.Properties[key]
+		if ok {
+			checkValue(property, properties[key], prefix+key, violations)
+			continue
+		}
+		if topLevel && strings.HasPrefix(key, "$") {
+			continue
+		}
+		if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+			*violations = append(*violations, Violation{
+				Kind:     UnexpectedProperty,
+				Property: prefix + key,
+				Message:  fmt.Sprintf("property %q is not in the tracking plan", prefix+key),
+			})
+		}
+	}
+}
+
+func hasType(value any, name string) bool {
+	switch name {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := numberOf(value)
+		return ok
+	case "integer":
+		number, ok := numberOf(value)
+		return ok && number == math.Trunc(number)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+func numberOf(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		number, err := v.Float64()
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// equalValues compares values by their json encoding, so that numbers of
+// different Go types are equal.
+func equalValues(a any, b any) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+func formatEnum(values []any) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded = []byte(fmt.Sprint(value))
+		}
+		formatted[i] = string(encoded)
+	}
+	return strings.Join(formatted, ", ")
+}
@@ -0,0 +1,48 @@
+package trackingplan
+
+import (
+	"errors"
+	"gorm.io/gorm"
+)
+
+// GetPlan returns the tracking plan of the project, nil if it has none.
+func GetPlan(db *gorm.DB) (*TrackingPlan, error) {
+	var plan TrackingPlan
+	err := db.Order("id").First(&plan).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// SavePlan replaces the tracking plan of the project.
+func SavePlan(db *gorm.DB, plan TrackingPlan) (*TrackingPlan, error) {
+	existing, err := GetPlan(db)
+	if err != nil {
+		return nil, err
+	}
+	plan.ID = 0
+	if existing != nil {
+		plan.ID = existing.ID
+	}
+	if err := db.Save(&plan).Error; err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// DeletePlan removes the tracking plan, after which events are no longer
+// checked.
+func DeletePlan(db *gorm.DB) error {
+	result := db.Where("1 = 1").Delete(&TrackingPlan{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
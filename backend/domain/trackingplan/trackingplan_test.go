@@ -0,0 +1,105 @@
+package trackingplan
+
+import (
+	"analytics/database/testsetup"
+	"analytics/domain/events"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+	"gorm.io/gorm"
+)
+
+func testPlan(t *testing.T, mode Mode) *TrackingPlan {
+	var plan TrackingPlan
+	err := json.Unmarshal([]byte(`{
+		"mode": "`+string(mode)+`",
+		"events": [{
+			"eventType": "purchase",
+			"schema": {
+				"type": "object",
+				"required": ["plan", "total"],
+				"additionalProperties": false,
+				"properties": {
+					"plan": {"type": "string", "enum": ["free", "pro"]},
+					"total": {"type": "number"},
+					"items": {"type": "array", "items": {"type": "object", "required": ["sku"]}}
+				}
+			}
+		}]
+	}`), &plan)
+	assert.NoError(t, err)
+	assert.NoError(t, plan.Validate())
+	return &plan
+}
+
+func TestCheckReportsViolations(t *testing.T) {
+	checker := NewChecker(testPlan(t, Allow))
+
+	valid := &events.EventInput{EventType: "purchase", Properties: map[string]any{
+		"plan": "pro", "total": 10.5, "$lib": "web", "items": []any{map[string]any{"sku": "a"}},
+	}}
+	assert.Equal(t, 0, len(checker.Check(valid)))
+
+	invalid := &events.EventInput{EventType: "purchase", Properties: map[string]any{
+		"plan": "gold", "total": "10", "coupon": "x", "items": []any{map[string]any{}},
+	}}
+	violations := checker.Check(invalid)
+	assert.Equal(t, 4, len(violations))
+	assert.Equal(t, UnexpectedProperty, violations[0].Kind)
+	assert.Equal(t, "coupon", violations[0].Property)
+	assert.Equal(t, MissingProperty, violations[1].Kind)
+	assert.Equal(t, "items[].sku", violations[1].Property)
+	assert.Equal(t, InvalidValue, violations[2].Kind)
+	assert.Equal(t, "plan", violations[2].Property)
+	assert.Equal(t, WrongType, violations[3].Kind)
+	assert.Equal(t, "total", violations[3].Property)
+
+	unplanned := checker.Check(&events.EventInput{EventType: "refund"})
+	assert.Equal(t, 1, len(unplanned))
+	assert.Equal(t, UnplannedEvent, unplanned[0].Kind)
+}
+
+func TestValidateRefusesUnknownTypes(t *testing.T) {
+	plan := TrackingPlan{Mode: Reject, Events: PlannedEvents{{
+		EventType: "purchase",
+		Schema: PropertySchema{Properties: map[string]*PropertySchema{
+			"total": {Type: SchemaTypes{"decimal"}},
+		}},
+	}}}
+	assert.Error(t, plan.Validate())
+	plan.Mode = "drop"
+	assert.Error(t, plan.Validate())
+}
+
+func TestReportGroupsFlushedAndPendingViolations(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{ProjectDB: true})
+	db := setup.ProjectDB
+	assert.NoError(t, db.AutoMigrate(&ViolationCount{}))
+
+	now := time.Date(2026, 5, 9, 12, 0, 0, 0, time.UTC)
+	checker := NewChecker(testPlan(t, Reject))
+	oldApp := &events.EventInput{EventType: "purchase", Properties: map[string]any{
+		"plan": "pro", "$lib": "posthog-js", "$lib_version": "1.0.0", "$app_version": "2.1",
+	}}
+	newApp := &events.EventInput{EventType: "signup", Properties: map[string]any{
+		"$lib": "posthog-js", "$lib_version": "1.2.0", "$app_version": "2.2",
+	}}
+	Record("report-test", now, oldApp, checker.Check(oldApp))
+	Record("report-test", now, oldApp, checker.Check(oldApp))
+	assert.NoError(t, Flush(map[string]*gorm.DB{"report-test": db}))
+	Record("report-test", now, oldApp, checker.Check(oldApp))
+	Record("report-test", now, newApp, checker.Check(newApp))
+
+	report, err := QueryReport(db, "report-test", now.AddDate(0, 0, -1))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), report.Total)
+	assert.Equal(t, 2, len(report.ByEventType))
+	assert.Equal(t, "purchase", report.ByEventType[0].EventType)
+	assert.Equal(t, int64(3), report.ByEventType[0].Problems[0].Count)
+	assert.Equal(t, 2, len(report.BySource))
+	assert.Equal(t, "2.1", report.BySource[0].AppVersion)
+	assert.Equal(t, int64(3), report.BySource[0].Count)
+	assert.Equal(t, "1.2.0", report.BySource[1].LibVersion)
+}
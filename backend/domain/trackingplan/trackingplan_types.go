@@ -0,0 +1,154 @@
+package trackingplan
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Mode is what happens to events that violate the tracking plan.
+type Mode string
+
+const (
+	// Allow stores violating events and lists the violations in the
+	// ViolationsProperty of the event.
+	Allow Mode = "allow"
+	// Quarantine keeps violating events as dead letters, from where they can
+	// be inspected and retried.
+	Quarantine Mode = "quarantine"
+	// Reject refuses violating events, the client gets the violations in the
+	// result of the event.
+	Reject Mode = "reject"
+)
+
+// ViolationsProperty lists the violations of events stored in Allow mode.
+const ViolationsProperty = "$plan_violations"
+
+// TrackingPlan declares the events a project expects. A project has at most
+// one plan.
+type TrackingPlan struct {
+	ID   uint `gorm:"primarykey" json:"-"`
+	Mode Mode `gorm:"not null" json:"mode"`
+	// AllowUnplannedEvents accepts event types the plan does not declare
+	// without a violation.
+	AllowUnplannedEvents bool          `json:"allowUnplannedEvents"`
+	Events               PlannedEvents `gorm:"type:json;not null" json:"events"`
+	UpdatedAt            time.Time     `json:"updatedAt"`
+}
+
+// PlannedEvent declares the properties of an event type with a JSON Schema of
+// type object.
+type PlannedEvent struct {
+	EventType   string         `json:"eventType"`
+	Description string         `json:"description,omitempty"`
+	Schema      PropertySchema `json:"schema"`
+}
+
+type PlannedEvents []PlannedEvent
+
+func (e *PlannedEvents) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, e)
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	}
+	return fmt.Errorf("unsupported type for json column: %T", src)
+}
+
+func (e PlannedEvents) Value() (driver.Value, error) {
+	return json.Marshal(e)
+}
+
+// PropertySchema is the subset of JSON Schema that tracking plans support.
+// Properties whose key starts with $ are set by SDKs and the pipeline, they
+// are allowed on every event even if additionalProperties is false.
+type PropertySchema struct {
+	Schema               string                     `json:"$schema,omitempty"`
+	Title                string                     `json:"title,omitempty"`
+	Description          string                     `json:"description,omitempty"`
+	Type                 SchemaTypes                `json:"type,omitempty"`
+	Enum                 []any                      `json:"enum,omitempty"`
+	Properties           map[string]*PropertySchema `json:"properties,omitempty"`
+	Required             []string                   `json:"required,omitempty"`
+	AdditionalProperties *bool                      `json:"additionalProperties,omitempty"`
+	Items                *PropertySchema            `json:"items,omitempty"`
+}
+
+// SchemaTypes is the type of a schema, which JSON Schema allows to be a
+// single type or a list of types.
+type SchemaTypes []string
+
+var schemaTypes = []string{"string", "number", "integer", "boolean", "object", "array", "null"}
+
+func (t SchemaTypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *SchemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = SchemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("type must be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+func (p TrackingPlan) Validate() error {
+	switch p.Mode {
+	case Allow, Quarantine, Reject:
+	default:
+		return fmt.Errorf("mode must be %q, %q or %q", Allow, Quarantine, Reject)
+	}
+	seen := make(map[string]bool, len(p.Events))
+	for _, event := range p.Events {
+		if event.EventType == "" {
+			return errors.New("eventType is required")
+		}
+		if seen[event.EventType] {
+			return fmt.Errorf("event %q is declared twice", event.EventType)
+		}
+		seen[event.EventType] = true
+		if len(event.Schema.Type) > 0 && !slices.Equal(event.Schema.Type, SchemaTypes{"object"}) {
+			return fmt.Errorf("schema of event %q must be of type object", event.EventType)
+		}
+		if err := event.Schema.validate(); err != nil {
+			return fmt.Errorf("schema of event %q: %w", event.EventType, err)
+		}
+	}
+	return nil
+}
+
+func (s *PropertySchema) validate() error {
+	for _, name := range s.Type {
+		if !slices.Contains(schemaTypes, name) {
+			return fmt.Errorf("unknown type %q", name)
+		}
+	}
+	if s.Enum != nil && len(s.Enum) == 0 {
+		return errors.New("enum must not be empty")
+	}
+	for key, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("property %q has no schema", key)
+		}
+		if err := property.validate(); err != nil {
+			return fmt.Errorf("property %q: %w", key, err)
+		}
+	}
+	if s.Items != nil {
+		return s.Items.validate()
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package trackingplan
+
+import (
+	"analytics/domain/events"
+	"cmp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ViolationCount counts a kind of violation per day (UTC), event type and the
+// SDK and app version that sent the events. It is stored in the project
+// database.
+type ViolationCount struct {
+	Day        string        `gorm:"primaryKey" json:"day"`
+	EventType  string        `gorm:"primaryKey" json:"eventType"`
+	Kind       ViolationKind `gorm:"primaryKey" json:"kind"`
+	Property   string        `gorm:"primaryKey" json:"property"`
+	Lib        string        `gorm:"primaryKey" json:"lib"`
+	LibVersion string        `gorm:"primaryKey" json:"libVersion"`
+	AppVersion string        `gorm:"primaryKey" json:"appVersion"`
+	Count      int64         `gorm:"not null;default:0" json:"count"`
+	// Example is the message of the latest violation.
+	Example string `json:"example"`
+}
+
+type countKey struct {
+	project    string
+	day        string
+	eventType  string
+	kind       ViolationKind
+	property   string
+	lib        string
+	libVersion string
+	appVersion string
+}
+
+// Counts are kept in memory and written to the project databases by Flush,
+// so that ingestion requests do not write to SQLite.
+var (
+	mu      sync.Mutex
+	flushMu sync.Mutex
+	pending = make(map[countKey]*ViolationCount)
+)
+
+// Record counts the violations of an event on the day of now.
+func Record(projectId string, now time.Time, event *events.EventInput, violations []Violation) {
+	if len(violations) == 0 {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, violation := range violations {
+		key := countKey{
+			project:    projectId,
+			day:        now.UTC().Format(time.DateOnly),
+			eventType:  event.EventType,
+			kind:       violation.Kind,
+			property:   violation.Property,
+			lib:        stringProperty(event, "$lib"),
+			libVersion: stringProperty(event, "$lib_version"),
+			appVersion: stringProperty(event, "$app_version"),
+		}
+		entry, ok := pending[key]
+		if !ok {
+			entry = &ViolationCount{
+				Day:        key.day,
+				EventType:  key.eventType,
+				Kind:       key.kind,
+				Property:   key.property,
+				Lib:        key.lib,
+				LibVersion: key.libVersion,
+				AppVersion: key.appVersion,
+			}
+			pending[key] = entry
+		}
+		entry.Count++
+		entry.Example = violation.Message
+	}
+}
+
+func stringProperty(event *events.EventInput, key string) string {
+	value, _ := event.Properties[key].(string)
+	return value
+}
+
+// Flush adds the counts recorded since the last flush to the databases of
+// their projects. Counts that could not be written are kept for the next
+// flush.
+func Flush(projectDbs map[string]*gorm.DB) error {
+	flushMu.Lock()
+	defer flushMu.Unlock()
+	mu.Lock()
+	flushed := pending
+	pending = make(map[countKey]*ViolationCount)
+	mu.Unlock()
+
+	for key, entry := range flushed {
+		db, ok := projectDbs[key.project]
+		if !ok {
+			delete(flushed, key)
+			continue
+		}
+		err := db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{
+				{Name: "day"}, {Name: "event_type"}, {Name: "kind"}, {Name: "property"},
+				{Name: "lib"}, {Name: "lib_version"}, {Name: "app_version"},
+			},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"count":   gorm.Expr("count + ?", entry.Count),
+				"example": entry.Example,
+			}),
+		}).Create(entry).Error
+		if err != nil {
+			restore(flushed)
+			return err
+		}
+		delete(flushed, key)
+	}
+	return nil
+}
+
+func restore(entries map[countKey]*ViolationCount) {
+	mu.Lock()
+	defer mu.Unlock()
+	for key, entry := range entries {
+		if existing, ok := pending[key]; ok {
+			existing.Count += entry.Count
+			continue
+		}
+		pending[key] = entry
+	}
+}
+
+// ViolationReport groups the violations of a period once by event type and
+// once by the SDK and app version that sent them.
+type ViolationReport struct {
+	Total       int64             `json:"total"`
+	ByEventType []EventViolations `json:"byEventType"`
+	BySource    []SourceViolation `json:"bySource"`
+}
+
+type EventViolations struct {
+	EventType string         `json:"eventType"`
+	Count     int64          `json:"count"`
+	Problems  []ProblemCount `json:"problems"`
+}
+
+type SourceViolation struct {
+	Lib        string         `json:"lib"`
+	LibVersion string         `json:"libVersion"`
+	AppVersion string         `json:"appVersion"`
+	Count      int64          `json:"count"`
+	Problems   []ProblemCount `json:"problems"`
+}
+
+// ProblemCount counts a kind of violation of a property. The event type is
+// only set when grouping by source.
+type ProblemCount struct {
+	EventType string        `json:"eventType,omitempty"`
+	Kind      ViolationKind `json:"kind"`
+	Property  string        `json:"property,omitempty"`
+	Count     int64         `json:"count"`
+	Example   string        `json:"example"`
+}
+
+// QueryReport reports the violations of the project since the given time,
+// including counts that were not flushed yet. Groups and problems are
+// ordered by their count, the most frequent first.
+func QueryReport(db *gorm.DB, projectId string, since time.Time) (ViolationReport, error) {
+	flushMu.Lock()
+	defer flushMu.Unlock()
+	sinceDay := since.UTC().Format(time.DateOnly)
+	var counts []ViolationCount
+	if err := db.Where("day >= ?", sinceDay).Order("day").Find(&counts).Error; err != nil {
+		return ViolationReport{}, err
+	}
+	mu.Lock()
+	for key, entry := range pending {
+		if key.project == projectId && key.day >= sinceDay {
+			counts = append(counts, *entry)
+		}
+	}
+	mu.Unlock()
+	return buildReport(counts), nil
+}
+
+func buildReport(counts []ViolationCount) ViolationReport {
+	report := ViolationReport{ByEventType: []EventViolations{}, BySource: []SourceViolation{}}
+	byEventType := make(map[string]*EventViolations)
+	bySource := make(map[[3]string]*SourceViolation)
+	var eventTypes []string
+	var sources [][3]string
+	for _, count := range counts {
+		report.Total += count.Count
+
+		event, ok := byEventType[count.EventType]
+		if !ok {
+			event = &EventViolations{EventType: count.EventType}
+			byEventType[count.EventType] = event
+			eventTypes = append(eventTypes, count.EventType)
+		}
+		event.Count += count.Count
+		event.Problems = addProblem(event.Problems, ProblemCount{
+			Kind: count.Kind, Property: count.Property, Count: count.Count, Example: count.Example,
+		})
+
+		sourceKey := [3]string{count.Lib, count.LibVersion, count.AppVersion}
+		source, ok := bySource[sourceKey]
+		if !ok {
+			source = &SourceViolation{Lib: count.Lib, LibVersion: count.LibVersion, AppVersion: count.AppVersion}
+			bySource[sourceKey] = source
+			sources = append(sources, sourceKey)
+		}
+		source.Count += count.Count
+		source.Problems = addProblem(source.Problems, ProblemCount{
+			EventType: count.EventType, Kind: count.Kind, Property: count.Property, Count: count.Count, Example: count.Example,
+		})
+	}
+
+	for _, eventType := range eventTypes {
+		event := byEventType[eventType]
+		sortProblems(event.Problems)
+		report.ByEventType = append(report.ByEventType, *event)
+	}
+	slices.SortStableFunc(report.ByEventType, func(a, b EventViolations) int { return cmp.Compare(b.Count, a.Count) })
+	for _, sourceKey := range sources {
+		source := bySource[sourceKey]
+		sortProblems(source.Problems)
+		report.BySource = append(report.BySource, *source)
+	}
+	slices.SortStableFunc(report.BySource, func(a, b SourceViolation) int { return cmp.Compare(b.Count, a.Count) })
+	return report
+}
+
+// addProblem adds a count to the problem of the same kind, property and event
+// type. Counts are added in the order of their day, so the example of the
+// latest day is kept.
+func addProblem(problems []ProblemCount, problem ProblemCount) []ProblemCount {
+	index := slices.IndexFunc(problems, func(existing ProblemCount) bool {
+		return existing.EventType == problem.EventType && existing.Kind == problem.Kind && existing.Property == problem.Property
+	})
+	if index < 0 {
+		return append(problems, problem)
+	}
+	problems[index].Count += problem.Count
+	problems[index].Example = problem.Example
+	return problems
+}
+
+func sortProblems(problems []ProblemCount) {
+	slices.SortStableFunc(problems, func(a, b ProblemCount) int { return cmp.Compare(b.Count, a.Count) })
+}
@@ -0,0 +1,175 @@
+package transformations
+
+import (
+	"analytics/domain/events"
+	"analytics/domain/queries"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Apply runs the enabled rules in order on event and returns the indexes of
+// the rules that were applied. Processing stops at the first rule that drops
+// the event. Rules that would write below a property that is not an object
+// are skipped for the event, so they do not destroy its value.
+func Apply(rules []TransformationRule, event *events.EventInput) ([]int, bool) {
+	applied := make([]int, 0)
+	for i, rule := range rules {
+		if rule.Enabled != nil && !*rule.Enabled {
+			continue
+		}
+		if !rule.matches(event) {
+			continue
+		}
+		if rule.Action == DropEvent {
+			return append(applied, i), true
+		}
+		if rule.apply(event) {
+			applied = append(applied, i)
+		}
+	}
+	return applied, false
+}
+
+func (r TransformationRule) matches(event *events.EventInput) bool {
+	if r.EventType != "" && r.EventType != event.EventType {
+		return false
+	}
+	for _, condition := range r.Conditions {
+		if !condition.matches(event) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r TransformationRule) apply(event *events.EventInput) bool {
+	switch r.Action {
+	case RenameEvent:
+		event.EventType = r.Target
+	case RenameProperty:
+		value, exists := deletePath(event, r.Property)
+		if exists && !setPath(event, r.Target, value) {
+			setPath(event, r.Property, value)
+			return false
+		}
+	case DeleteProperty:
+		deletePath(event, r.Property)
+	case SetProperty:
+		return setPath(event, r.Property, r.Value.Constant)
+	case CoerceProperty:
+		value, exists := getPath(event, r.Property)
+		if !exists || value == nil {
+			return true
+		}
+		if coerced, ok := coerce(value, queries.FieldType(r.Target)); ok {
+			setPath(event, r.Property, coerced)
+		}
+	}
+	return true
+}
+
+func (c RuleCondition) matches(event *events.EventInput) bool {
+	value, exists := getPath(event, c.Property)
+	switch c.Operation {
+	case queries.Equals:
+		return exists && equalValues(value, c.Value)
+	case queries.NotEquals:
+		return !exists || !equalValues(value, c.Value)
+	case queries.GreaterThan, queries.LessThan, queries.GreaterEquals, queries.LessEquals:
+		if !exists {
+			return false
+		}
+		left, leftOk := toNumber(value)
+		right, rightOk := toNumber(c.Value)
+		if !leftOk || !rightOk {
+			return false
+		}
+		switch c.Operation {
+		case queries.GreaterThan:
+			return left > right
+		case queries.LessThan:
+			return left < right
+		case queries.GreaterEquals:
+			return left >= right
+		default:
+			return left <= right
+		}
+	case queries.In:
+		return exists && containsValue(c.Value, value)
+	case queries.NotIn:
+		return !exists || !containsValue(c.Value, value)
+	case queries.Contains:
+		return exists && strings.Contains(toString(value), toString(c.Value))
+	}
+	return false
+}
+
+func equalValues(left any, right any) bool {
+	return toString(left) == toString(right)
+}
+
+func containsValue(list any, value any) bool {
+	values, ok := list.([]any)
+	if !ok {
+		return equalValues(list, value)
+	}
+	for _, candidate := range values {
+		if equalValues(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func toString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+func coerce(value any, fieldType queries.FieldType) (any, bool) {
+	switch fieldType {
+	case queries.StringField:
+		return toString(value), true
+	case queries.NumberField:
+		return toNumber(value)
+	case queries.BooleanField:
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			parsed, err := strconv.ParseBool(strings.TrimSpace(v))
+			return parsed, err == nil
+		default:
+			number, ok := toNumber(v)
+			return number != 0, ok
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,87 @@
+package transformations
+
+import (
+	"analytics/domain/events"
+	"strings"
+)
+
+const (
+	propertiesScope       = "properties"
+	personPropertiesScope = "personProperties"
+)
+
+// splitPath splits a property path into its scope and the keys within it.
+// Paths without a scope refer to the event properties, nested keys are
+// separated by dots: "address.city" equals "properties.address.city".
+func splitPath(path string) (string, []string) {
+	keys := strings.Split(path, ".")
+	if len(keys) > 1 && (keys[0] == propertiesScope || keys[0] == personPropertiesScope) {
+		return keys[0], keys[1:]
+	}
+	return propertiesScope, keys
+}
+
+func scopeMap(event *events.EventInput, scope string, create bool) map[string]any {
+	target := &event.Properties
+	if scope == personPropertiesScope {
+		target = &event.PersonProperties
+	}
+	if *target == nil && create {
+		*target = make(map[string]any)
+	}
+	return *target
+}
+
+// parentMap returns the map holding the last key of path. With create,
+// missing maps along the path are created. Values along the path that are not
+// maps are never replaced, the path cannot be resolved then.
+func parentMap(event *events.EventInput, path string, create bool) (map[string]any, string) {
+	scope, keys := splitPath(path)
+	current := scopeMap(event, scope, create)
+	if current == nil {
+		return nil, ""
+	}
+	for _, key := range keys[:len(keys)-1] {
+		value, exists := current[key]
+		next, ok := value.(map[string]any)
+		if !ok {
+			if !create || (exists && value != nil) {
+				return nil, ""
+			}
+			next = make(map[string]any)
+			current[key] = next
+		}
+		current = next
+	}
+	return current, keys[len(keys)-1]
+}
+
+func getPath(event *events.EventInput, path string) (any, bool) {
+	parent, key := parentMap(event, path, false)
+	if parent == nil {
+		return nil, false
+	}
+	value, exists := parent[key]
+	return value, exists
+}
+
+// setPath sets the value at path. It returns false if a value along the path
+// is not a map.
+func setPath(event *events.EventInput, path string, value any) bool {
+	parent, key := parentMap(event, path, true)
+	if parent == nil {
+		return false
+	}
+	parent[key] = value
+	return true
+}
+
+func deletePath(event *events.EventInput, path string) (any, bool) {
+	parent, key := parentMap(event, path, false)
+	if parent == nil {
+		return nil, false
+	}
+	value, exists := parent[key]
+	delete(parent, key)
+	return value, exists
+}
@@ -0,0 +1,108 @@
+package transformations
+
+import (
+	"analytics/domain/queries"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"slices"
+	"strings"
+)
+
+var coercionTypes = []queries.FieldType{queries.StringField, queries.NumberField, queries.BooleanField}
+
+func (input RuleInput) Validate() error {
+	if strings.TrimSpace(input.Name) == "" {
+		return errors.New("name is required")
+	}
+	for _, condition := range input.Conditions {
+		if condition.Property == "" {
+			return errors.New("conditions need a property")
+		}
+		if _, ok := queries.GetOperation(condition.Operation); !ok {
+			return fmt.Errorf("unknown operation %q", condition.Operation)
+		}
+	}
+
+	switch input.Action {
+	case DropEvent:
+		return nil
+	case RenameEvent:
+		if strings.TrimSpace(input.Target) == "" {
+			return errors.New("rename_event needs the new event type as target")
+		}
+		return nil
+	case RenameProperty:
+		if input.Property == "" || input.Target == "" {
+			return errors.New("rename_property needs a property and a target")
+		}
+		return nil
+	case DeleteProperty, SetProperty:
+		if input.Property == "" {
+			return fmt.Errorf("%s needs a property", input.Action)
+		}
+		return nil
+	case CoerceProperty:
+		if input.Property == "" {
+			return errors.New("coerce_property needs a property")
+		}
+		if !slices.Contains(coercionTypes, queries.FieldType(input.Target)) {
+			return fmt.Errorf("coerce_property target must be one of %v", coercionTypes)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", input.Action)
+}
+
+// ListRules returns the rules of a project in the order they are applied.
+func ListRules(db *gorm.DB) ([]TransformationRule, error) {
+	var rules []TransformationRule
+	err := db.Order("position, id").Find(&rules).Error
+	return rules, err
+}
+
+func GetRule(db *gorm.DB, id uint) (*TransformationRule, error) {
+	var rule TransformationRule
+	if err := db.First(&rule, id).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func CreateRule(db *gorm.DB, input RuleInput) (*TransformationRule, error) {
+	if input.Enabled == nil {
+		enabled := true
+		input.Enabled = &enabled
+	}
+	rule := TransformationRule{RuleInput: input}
+	if err := db.Create(&rule).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func UpdateRule(db *gorm.DB, id uint, input RuleInput) (*TransformationRule, error) {
+	rule, err := GetRule(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if input.Enabled == nil {
+		input.Enabled = rule.Enabled
+	}
+	rule.RuleInput = input
+	if err := db.Save(rule).Error; err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func DeleteRule(db *gorm.DB, id uint) error {
+	result := db.Delete(&TransformationRule{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
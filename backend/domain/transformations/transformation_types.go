@@ -0,0 +1,103 @@
+package transformations
+
+import (
+	"analytics/domain/queries"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type RuleAction string
+
+const (
+	// DropEvent discards matching events.
+	DropEvent RuleAction = "drop_event"
+	// RenameEvent sets the event type to Target.
+	RenameEvent RuleAction = "rename_event"
+	// RenameProperty moves the value at Property to the path in Target, which
+	// may be in the other scope, e.g. from properties to personProperties.
+	RenameProperty RuleAction = "rename_property"
+	// DeleteProperty removes the value at Property.
+	DeleteProperty RuleAction = "delete_property"
+	// SetProperty sets Property to the constant Value.
+	SetProperty RuleAction = "set_property"
+	// CoerceProperty converts the value at Property to the type in Target.
+	CoerceProperty RuleAction = "coerce_property"
+)
+
+// RuleCondition compares the value at a property path, e.g. "plan" or
+// "personProperties.email", using the operations of the query layer.
+type RuleCondition struct {
+	Property  string                `json:"property"`
+	Operation queries.OperationType `json:"operation"`
+	Value     any                   `json:"value"`
+}
+
+type RuleConditions []RuleCondition
+
+type RuleInput struct {
+	Name string `json:"name" gorm:"size:255;not null"`
+	// Position orders the rules of a project. Rules see the result of the
+	// rules before them.
+	Position int   `json:"position" gorm:"not null;default:0"`
+	Enabled  *bool `json:"enabled" gorm:"not null;default:true"`
+	// EventType restricts the rule to one event type. Empty matches all.
+	EventType  string         `json:"eventType"`
+	Conditions RuleConditions `json:"conditions" gorm:"type:json"`
+	Action     RuleAction     `json:"action" gorm:"not null"`
+	Property   string         `json:"property"`
+	Target     string         `json:"target"`
+	Value      RuleValue      `json:"value" gorm:"type:json"`
+}
+
+type TransformationRule struct {
+	ID uint `gorm:"primarykey" json:"id"`
+	RuleInput
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// RuleValue is the constant of a set_property rule. It keeps the json type
+// of the value, so numbers stay numbers.
+type RuleValue struct {
+	Constant any
+}
+
+func (v RuleValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Constant)
+}
+
+func (v *RuleValue) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &v.Constant)
+}
+
+func (v *RuleValue) Scan(src any) error {
+	return scanJSON(src, &v.Constant)
+}
+
+func (v RuleValue) Value() (driver.Value, error) {
+	return json.Marshal(v.Constant)
+}
+
+func (c *RuleConditions) Scan(src any) error {
+	return scanJSON(src, c)
+}
+
+func (c RuleConditions) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+func scanJSON(src any, target any) error {
+	if src == nil {
+		return nil
+	}
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, target)
+	case string:
+		return json.Unmarshal([]byte(v), target)
+	default:
+		return fmt.Errorf("unsupported type for json column: %T", src)
+	}
+}
@@ -0,0 +1,96 @@
+package transformations
+
+import (
+	"analytics/database/testsetup"
+	"analytics/domain/events"
+	"analytics/domain/queries"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestApplyRules(t *testing.T) {
+	rules := []TransformationRule{
+		{RuleInput: RuleInput{Name: "rename", EventType: "signup", Action: RenameEvent, Target: "user_signup"}},
+		{RuleInput: RuleInput{Name: "move email", Action: RenameProperty, Property: "email", Target: "personProperties.email"}},
+		{RuleInput: RuleInput{Name: "coerce", Action: CoerceProperty, Property: "amount", Target: string(queries.NumberField)}},
+		{RuleInput: RuleInput{Name: "source", Action: SetProperty, Property: "meta.source", Value: RuleValue{Constant: "web"}}},
+		{RuleInput: RuleInput{
+			Name:       "drop tests",
+			Action:     DropEvent,
+			Conditions: RuleConditions{{Property: "env", Operation: queries.Equals, Value: "test"}},
+		}},
+	}
+
+	event := &events.EventInput{
+		EventType:  "signup",
+		Properties: map[string]any{"email": "a@example.com", "amount": "12.5", "env": "prod"},
+	}
+	applied, dropped := Apply(rules, event)
+	assert.False(t, dropped)
+	assert.Equal(t, 4, len(applied))
+	assert.Equal(t, "user_signup", event.EventType)
+	assert.Equal(t, "a@example.com", event.PersonProperties["email"])
+	_, hasEmail := event.Properties["email"]
+	assert.False(t, hasEmail)
+	assert.Equal(t, 12.5, event.Properties["amount"])
+	assert.Equal(t, "web", event.Properties["meta"].(map[string]any)["source"])
+
+	testEvent := &events.EventInput{EventType: "signup", Properties: map[string]any{"env": "test"}}
+	_, dropped = Apply(rules, testEvent)
+	assert.True(t, dropped)
+}
+
+func TestRulesDoNotReplaceValuesWithObjects(t *testing.T) {
+	rules := []TransformationRule{
+		{RuleInput: RuleInput{Name: "source", Action: SetProperty, Property: "meta.source", Value: RuleValue{Constant: "web"}}},
+		{RuleInput: RuleInput{Name: "move plan", Action: RenameProperty, Property: "plan", Target: "meta.plan"}},
+		{RuleInput: RuleInput{Name: "channel", Action: SetProperty, Property: "utm.channel", Value: RuleValue{Constant: "ads"}}},
+	}
+
+	event := &events.EventInput{
+		EventType:  "signup",
+		Properties: map[string]any{"meta": "v2", "plan": "pro", "utm": nil},
+	}
+	applied, dropped := Apply(rules, event)
+	assert.False(t, dropped)
+	assert.Equal(t, []int{2}, applied)
+	assert.Equal(t, "v2", event.Properties["meta"])
+	assert.Equal(t, "pro", event.Properties["plan"])
+	assert.Equal(t, "ads", event.Properties["utm"].(map[string]any)["channel"])
+}
+
+func TestRuleStore(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{ProjectDB: true})
+	db := setup.ProjectDB
+	assert.NoError(t, db.AutoMigrate(&TransformationRule{}))
+
+	second, err := CreateRule(db, RuleInput{Name: "second", Position: 2, Action: SetProperty, Property: "a", Value: RuleValue{Constant: 1.0}})
+	assert.NoError(t, err)
+	_, err = CreateRule(db, RuleInput{
+		Name:       "first",
+		Position:   1,
+		Action:     DropEvent,
+		Conditions: RuleConditions{{Property: "a", Operation: queries.In, Value: []any{"x", "y"}}},
+	})
+	assert.NoError(t, err)
+
+	rules, err := ListRules(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(rules))
+	assert.Equal(t, "first", rules[0].Name)
+	assert.True(t, *rules[0].Enabled)
+	assert.Equal(t, 1, len(rules[0].Conditions))
+	assert.Equal(t, 1.0, rules[1].Value.Constant)
+
+	disabled := false
+	_, err = UpdateRule(db, second.ID, RuleInput{Name: "second", Enabled: &disabled, Action: DeleteProperty, Property: "a"})
+	assert.NoError(t, err)
+	updated, err := GetRule(db, second.ID)
+	assert.NoError(t, err)
+	assert.False(t, *updated.Enabled)
+	assert.Equal(t, DeleteProperty, updated.Action)
+
+	assert.NoError(t, DeleteRule(db, second.ID))
+	assert.Error(t, DeleteRule(db, second.ID))
+}
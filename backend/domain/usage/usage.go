@@ -0,0 +1,216 @@
+package usage
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DailyUsage counts the events a project sent on a single day (UTC). It is
+// stored in the app database.
+type DailyUsage struct {
+	Project string `gorm:"primaryKey" json:"-"`
+	Day     string `gorm:"primaryKey" json:"day"`
+	// Events were accepted for ingestion.
+	Events int64 `gorm:"not null;default:0" json:"events"`
+	// RateLimited events were refused because a rate limit was exceeded.
+	RateLimited int64 `gorm:"not null;default:0" json:"rateLimited"`
+	// OverQuota events were refused because the monthly quota was used up.
+	OverQuota int64 `gorm:"not null;default:0" json:"overQuota"`
+}
+
+func (u *DailyUsage) add(other DailyUsage) {
+	u.Events += other.Events
+	u.RateLimited += other.RateLimited
+	u.OverQuota += other.OverQuota
+}
+
+type dayKey struct {
+	project string
+	day     string
+}
+
+type monthTotal struct {
+	month  string
+	events int64
+}
+
+// Counts are kept in memory and written to the app database by Flush, so that
+// ingestion requests do not write to SQLite.
+var (
+	mu sync.Mutex
+	// flushMu keeps Flush from moving counts to the database while
+	// MonthToDate reads the database and the pending counts.
+	flushMu sync.Mutex
+	pending = make(map[dayKey]*DailyUsage)
+	totals  = make(map[string]*monthTotal)
+)
+
+// Record counts the events of a project on the day of now.
+func Record(projectId string, now time.Time, counts DailyUsage) {
+	mu.Lock()
+	defer mu.Unlock()
+	record(projectId, now, counts)
+}
+
+func record(projectId string, now time.Time, counts DailyUsage) {
+	key := dayKey{project: projectId, day: now.UTC().Format(time.DateOnly)}
+	entry, ok := pending[key]
+	if !ok {
+		entry = &DailyUsage{Project: key.project, Day: key.day}
+		pending[key] = entry
+	}
+	entry.add(counts)
+	if total, ok := totals[projectId]; ok && total.month == monthOf(now) {
+		total.events += counts.Events
+	}
+}
+
+// Reserve counts count events of a project on the day of now if they fit into
+// its monthly quota. The quota is checked and the events are counted at once,
+// so concurrent requests cannot exceed the quota together. It returns the
+// events that were used before.
+func Reserve(db *gorm.DB, projectId string, now time.Time, count int64, quota int64) (int64, bool, error) {
+	month := monthOf(now)
+	for {
+		if _, err := MonthToDate(db, projectId, now); err != nil {
+			return 0, false, err
+		}
+		mu.Lock()
+		total, ok := totals[projectId]
+		if !ok || total.month != month {
+			// A request of another month replaced the total in between.
+			mu.Unlock()
+			continue
+		}
+		used := total.events
+		if used+count > quota {
+			mu.Unlock()
+			return used, false, nil
+		}
+		record(projectId, now, DailyUsage{Events: count})
+		mu.Unlock()
+		return used, true, nil
+	}
+}
+
+// Release takes back count events that were counted on the day of now but
+// were not ingested after all.
+func Release(projectId string, now time.Time, count int64) {
+	Record(projectId, now, DailyUsage{Events: -count})
+}
+
+// MonthToDate returns the number of events the project sent in the calendar
+// month of now. The total is read from the database once per month and kept
+// up to date by Record.
+func MonthToDate(db *gorm.DB, projectId string, now time.Time) (int64, error) {
+	month := monthOf(now)
+	mu.Lock()
+	if total, ok := totals[projectId]; ok && total.month == month {
+		events := total.events
+		mu.Unlock()
+		return events, nil
+	}
+	mu.Unlock()
+
+	flushMu.Lock()
+	defer flushMu.Unlock()
+	var stored int64
+	err := db.Model(&DailyUsage{}).
+		Select("coalesce(sum(events), 0)").
+		Where("project = ? AND day >= ?", projectId, month+"-01").
+		Scan(&stored).Error
+	if err != nil {
+		return 0, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if total, ok := totals[projectId]; ok && total.month == month {
+		return total.events, nil
+	}
+	events := stored
+	for key, entry := range pending {
+		if key.project == projectId && key.day >= month+"-01" {
+			events += entry.Events
+		}
+	}
+	totals[projectId] = &monthTotal{month: month, events: events}
+	return events, nil
+}
+
+// Flush adds the counts recorded since the last flush to the database. Counts
+// that could not be written are kept for the next flush.
+func Flush(db *gorm.DB) error {
+	flushMu.Lock()
+	defer flushMu.Unlock()
+	mu.Lock()
+	flushed := pending
+	pending = make(map[dayKey]*DailyUsage)
+	mu.Unlock()
+
+	for key, entry := range flushed {
+		err := db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "project"}, {Name: "day"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"events":       gorm.Expr("events + ?", entry.Events),
+				"rate_limited": gorm.Expr("rate_limited + ?", entry.RateLimited),
+				"over_quota":   gorm.Expr("over_quota + ?", entry.OverQuota),
+			}),
+		}).Create(entry).Error
+		if err != nil {
+			restore(flushed)
+			return err
+		}
+		delete(flushed, key)
+	}
+	return nil
+}
+
+func restore(entries map[dayKey]*DailyUsage) {
+	mu.Lock()
+	defer mu.Unlock()
+	for key, entry := range entries {
+		if existing, ok := pending[key]; ok {
+			existing.add(*entry)
+			continue
+		}
+		pending[key] = entry
+	}
+}
+
+// QueryDailyUsage returns the usage of the project per day since the given
+// time, including counts that were not flushed yet.
+func QueryDailyUsage(db *gorm.DB, projectId string, since time.Time) ([]DailyUsage, error) {
+	flushMu.Lock()
+	defer flushMu.Unlock()
+	sinceDay := since.UTC().Format(time.DateOnly)
+	var stored []DailyUsage
+	err := db.Where("project = ? AND day >= ?", projectId, sinceDay).Order("day").Find(&stored).Error
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for key, entry := range pending {
+		if key.project != projectId || key.day < sinceDay {
+			continue
+		}
+		index := slices.IndexFunc(stored, func(day DailyUsage) bool { return day.Day == key.day })
+		if index < 0 {
+			stored = append(stored, *entry)
+			continue
+		}
+		stored[index].add(*entry)
+	}
+	slices.SortFunc(stored, func(a, b DailyUsage) int { return strings.Compare(a.Day, b.Day) })
+	return stored, nil
+}
+
+func monthOf(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
@@ -0,0 +1,72 @@
+package usage
+
+import (
+	"analytics/database/testsetup"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestUsageIsCountedPerDayAndMonth(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{ProjectDB: true})
+	db := setup.ProjectDB
+	assert.NoError(t, db.AutoMigrate(&DailyUsage{}))
+
+	lastMonth := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	firstDay := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	secondDay := firstDay.AddDate(0, 0, 1)
+
+	Record("project", lastMonth, DailyUsage{Events: 100})
+	Record("project", firstDay, DailyUsage{Events: 10, RateLimited: 2})
+	Record("other", firstDay, DailyUsage{Events: 50})
+	assert.NoError(t, Flush(db))
+	Record("project", firstDay, DailyUsage{Events: 5})
+
+	total, err := MonthToDate(db, "project", secondDay)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), total)
+
+	Record("project", secondDay, DailyUsage{Events: 1, OverQuota: 3})
+	total, err = MonthToDate(db, "project", secondDay)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(16), total)
+
+	days, err := QueryDailyUsage(db, "project", firstDay)
+	assert.NoError(t, err)
+	assert.Equal(t, []DailyUsage{
+		{Project: "project", Day: "2025-02-01", Events: 15, RateLimited: 2},
+		{Project: "project", Day: "2025-02-02", Events: 1, OverQuota: 3},
+	}, days)
+
+	assert.NoError(t, Flush(db))
+	flushed, err := QueryDailyUsage(db, "project", firstDay)
+	assert.NoError(t, err)
+	assert.Equal(t, days, flushed)
+}
+
+func TestReserveCountsEventsWithinTheQuota(t *testing.T) {
+	setup := testsetup.Setup(t, testsetup.TestSetupConfig{ProjectDB: true})
+	db := setup.ProjectDB
+	assert.NoError(t, db.AutoMigrate(&DailyUsage{}))
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	used, ok, err := Reserve(db, "reserved", now, 8, 10)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), used)
+
+	used, ok, err = Reserve(db, "reserved", now, 3, 10)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, int64(8), used)
+
+	Release("reserved", now, 8)
+	_, ok, err = Reserve(db, "reserved", now, 10, 10)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	total, err := MonthToDate(db, "reserved", now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), total)
+}
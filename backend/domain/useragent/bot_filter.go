@@ -0,0 +1,57 @@
+package useragent
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type BotFilterMode string
+
+const (
+	// BotFilterOff keeps events of bots as they are.
+	BotFilterOff BotFilterMode = "off"
+	// BotFilterFlag keeps events of bots, but sets their $is_bot property.
+	BotFilterFlag BotFilterMode = "flag"
+	// BotFilterDrop discards events of bots.
+	BotFilterDrop BotFilterMode = "drop"
+)
+
+func ParseBotFilterMode(value string) (BotFilterMode, bool) {
+	switch mode := BotFilterMode(value); mode {
+	case BotFilterOff, BotFilterFlag, BotFilterDrop:
+		return mode, true
+	}
+	return "", false
+}
+
+// FilteredEvents counts the events of bots that were flagged or dropped on a
+// single day.
+type FilteredEvents struct {
+	Day     string `gorm:"primaryKey" json:"day"`
+	Flagged int    `gorm:"not null;default:0" json:"flagged"`
+	Dropped int    `gorm:"not null;default:0" json:"dropped"`
+}
+
+func RecordFilteredEvents(db *gorm.DB, day time.Time, flagged int, dropped int) error {
+	if flagged == 0 && dropped == 0 {
+		return nil
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"flagged": gorm.Expr("flagged + ?", flagged),
+			"dropped": gorm.Expr("dropped + ?", dropped),
+		}),
+	}).Create(&FilteredEvents{
+		Day:     day.UTC().Format(time.DateOnly),
+		Flagged: flagged,
+		Dropped: dropped,
+	}).Error
+}
+
+func QueryFilteredEvents(db *gorm.DB, since time.Time) ([]FilteredEvents, error) {
+	var result []FilteredEvents
+	err := db.Where("day >= ?", since.UTC().Format(time.DateOnly)).Order("day").Find(&result).Error
+	return result, err
+}
@@ -0,0 +1,70 @@
+package useragent
+
+import (
+	"github.com/mileusna/useragent"
+	"strings"
+)
+
+type Info struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	DeviceType     string
+	Bot            bool
+}
+
+// botPatterns catch automated clients the parser does not flag as bots:
+// headless browsers, performance audits and uptime checkers.
+var botPatterns = []string{
+	"headlesschrome",
+	"phantomjs",
+	"lighthouse",
+	"pagespeed",
+	"gtmetrix",
+	"uptimerobot",
+	"pingdom",
+	"statuscake",
+	"site24x7",
+	"uptime-kuma",
+	"betteruptime",
+	"better uptime",
+	"checkly",
+	"datadog/synthetics",
+	"newrelicpinger",
+	"freshping",
+	"hetrixtools",
+	"crawler",
+	"spider",
+	"scrapy",
+}
+
+// Parse extracts the browser, operating system and device type of a
+// User-Agent header and tells whether it belongs to a bot.
+func Parse(header string) Info {
+	parsed := useragent.Parse(header)
+	info := Info{
+		Browser:        parsed.Name,
+		BrowserVersion: parsed.Version,
+		OS:             parsed.OS,
+		Bot:            parsed.Bot || matchesBotPattern(header),
+	}
+	switch {
+	case parsed.Tablet:
+		info.DeviceType = "Tablet"
+	case parsed.Mobile:
+		info.DeviceType = "Mobile"
+	case parsed.Desktop:
+		info.DeviceType = "Desktop"
+	}
+	return info
+}
+
+func matchesBotPattern(header string) bool {
+	lower := strings.ToLower(header)
+	for _, pattern := range botPatterns {
+		if strings.Contains(lower, pattern) {
+			return true
+		}
+	}
+	return false
+}
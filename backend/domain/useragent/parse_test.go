@@ -0,0 +1,33 @@
+package useragent
+
+import (
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestParseBrowsers(t *testing.T) {
+	desktop := Parse("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	assert.Equal(t, "Chrome", desktop.Browser)
+	assert.Equal(t, "Windows", desktop.OS)
+	assert.Equal(t, "Desktop", desktop.DeviceType)
+	assert.False(t, desktop.Bot)
+
+	mobile := Parse("Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1")
+	assert.Equal(t, "Safari", mobile.Browser)
+	assert.Equal(t, "iOS", mobile.OS)
+	assert.Equal(t, "Mobile", mobile.DeviceType)
+}
+
+func TestParseBots(t *testing.T) {
+	bots := []string{
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36",
+		"Mozilla/5.0+(compatible; UptimeRobot/2.0; http://www.uptimerobot.com/)",
+		"Pingdom.com_bot_version_1.4_(http://www.pingdom.com/)",
+	}
+	for _, header := range bots {
+		assert.True(t, Parse(header).Bot)
+	}
+	assert.False(t, Parse("posthog-node/4.2.0").Bot)
+}
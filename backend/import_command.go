@@ -0,0 +1,112 @@
+package main
+
+import (
+	"analytics/config"
+	"analytics/cron"
+	"analytics/database/analyticsdb"
+	"analytics/database/appdb"
+	"analytics/domain/events"
+	"analytics/domain/events/parquet"
+	"analytics/domain/events/processor"
+	"analytics/domain/geoip"
+	"analytics/domain/imports"
+	"analytics/domain/projects"
+	"analytics/log"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runImportCommand imports a file into a project without going through the
+// server, which must not be running as it holds the databases open:
+//
+//	analytics import -project default -event-type event -timestamp time history.csv
+func runImportCommand(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	projectId := flags.String("project", "", "id of the project to import into")
+	format := flags.String("format", "", "csv, ndjson, parquet, mixpanel or posthog, derived from the file extension if empty")
+	var mapping imports.ColumnMapping
+	flags.StringVar(&mapping.EventType, "event-type", "", "column of the event type")
+	flags.StringVar(&mapping.Timestamp, "timestamp", "", "column of the timestamp")
+	flags.StringVar(&mapping.PersonId, "person-id", "", "column of the person id")
+	flags.StringVar(&mapping.SessionId, "session-id", "", "column of the session id")
+	flags.StringVar(&mapping.Uuid, "uuid", "", "column of the event id")
+	properties := flags.String("properties", "", "comma separated columns stored as properties, all other columns if empty")
+	flags.StringVar(&mapping.PropertiesColumn, "properties-column", "", "column with an object of properties")
+	flags.StringVar(&mapping.PersonPropertiesColumn, "person-properties-column", "", "column with an object of person properties")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *properties != "" {
+		mapping.Properties = strings.Split(*properties, ",")
+	}
+	if flags.NArg() != 1 || *projectId == "" {
+		fmt.Fprintln(os.Stderr, "usage: analytics import -project <id> [-format <format>] [-event-type <column> -timestamp <column>] [flags] <file>")
+		flags.PrintDefaults()
+		return 2
+	}
+	path := flags.Arg(0)
+	fileFormat, ok := imports.ParseFormat(*format)
+	if *format == "" {
+		fileFormat, ok = imports.FormatOf(path)
+	}
+	if !ok {
+		fmt.Fprintln(os.Stderr, "format must be one of csv, ndjson, parquet, mixpanel or posthog")
+		return 2
+	}
+	if fileFormat.NeedsMapping() {
+		if err := mapping.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	config.Load()
+	log.Init()
+	registerTables()
+	projects.CreateDirectories()
+	cron.Init()
+	appDb := appdb.Init()
+	projects.Init()
+	if err := geoip.Load(config.Config.Ingestion.GeoIPDatabase); err != nil {
+		log.Fatal("Could not open GeoIP database: %v", err)
+	}
+	db, ok := appdb.ProjectDBs[*projectId]
+	if !ok {
+		log.Error("Project %s not found", *projectId)
+		return 1
+	}
+
+	job, err := imports.CreateJob(db, filepath.Base(path), fileFormat, mapping)
+	if err != nil {
+		log.Error("Could not create import: %v", err)
+		return 1
+	}
+	importErr := imports.Run(db, job, path, func(chunk []*events.EventInput) error {
+		return processor.ImportEvents(*projectId, chunk)
+	})
+	for _, rowErr := range job.Errors {
+		log.Warn("Row %d skipped: %s", rowErr.Row, rowErr.Error)
+	}
+	if importErr == nil {
+		parquet.GenerateParquetFiles(*projectId, db)
+	}
+
+	if err := processor.StopProcessors(context.Background()); err != nil {
+		log.Error("Error while stopping processors: %v", err)
+	}
+	if err := cron.Shutdown(); err != nil {
+		log.Error("Error while stopping scheduler: %v", err)
+	}
+	analyticsdb.CloseAll()
+	appdb.CloseAll(appDb)
+	geoip.Close()
+	if importErr != nil {
+		log.Error("Import failed: %v", importErr)
+		return 1
+	}
+	return 0
+}
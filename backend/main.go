@@ -4,24 +4,44 @@ import (
 	"analytics/auth"
 	"analytics/config"
 	"analytics/cron"
+	"analytics/database/analyticsdb"
 	"analytics/database/appdb"
 	"analytics/domain/apikeys"
 	"analytics/domain/dashboards"
+	"analytics/domain/deadletters"
+	"analytics/domain/erasure"
 	"analytics/domain/events/parquet"
+	"analytics/domain/events/processor"
 	"analytics/domain/filecatalog"
+	"analytics/domain/geoip"
+	"analytics/domain/groups"
+	"analytics/domain/imports"
 	"analytics/domain/insightmeta"
 	"analytics/domain/insights"
+	"analytics/domain/privacy"
 	"analytics/domain/projects"
+	"analytics/domain/sampling"
 	"analytics/domain/schema"
+	"analytics/domain/trackingplan"
+	"analytics/domain/transformations"
+	"analytics/domain/usage"
+	"analytics/domain/useragent"
 	"analytics/log"
 	"analytics/server"
+	"context"
 	_ "github.com/duckdb/duckdb-go/v2"
 	"gorm.io/gorm"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
 	os.Setenv("TZ", "Etc/UTC")
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImportCommand(os.Args[2:]))
+	}
 
 	config.Load()
 	log.Init()
@@ -30,20 +50,88 @@ func main() {
 	cron.Init()
 	appDb := appdb.Init()
 	projectDbs := projects.Init()
+	for projectId, db := range *projectDbs {
+		if err := imports.FailInterruptedJobs(db); err != nil {
+			log.Error("Project %s: Could not update interrupted imports: %v", projectId, err)
+		}
+	}
+	if err := geoip.Load(config.Config.Ingestion.GeoIPDatabase); err != nil {
+		log.Fatal("Could not open GeoIP database: %v", err)
+	}
 
-	initCronJobs(projectDbs)
+	initCronJobs(projectDbs, appDb)
+	processor.StartProcessors(projectDbs)
 
-	server.Start(appDb, projectDbs)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server.Start(ctx, appDb, projectDbs)
+	shutdown(appDb)
+}
+
+// shutdown runs after the server stopped accepting requests. It flushes the
+// event queues before closing the databases they write to.
+func shutdown(appDb *gorm.DB) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Config.ShutdownTimeout)
+	defer cancel()
+
+	drained := true
+	if err := processor.StopProcessors(ctx); err != nil {
+		log.Warn("Exiting with undrained event queues, they are replayed on next start: %v", err)
+		drained = false
+	}
+	if err := imports.Wait(ctx); err != nil {
+		log.Warn("Exiting with running imports, they are marked as failed on next start: %v", err)
+		drained = false
+	}
+	// Draining the queues records violations of tracking plans.
+	if err := usage.Flush(appDb); err != nil {
+		log.Error("Error while writing usage: %v", err)
+	}
+	if err := trackingplan.Flush(appdb.ProjectDBs); err != nil {
+		log.Error("Error while writing tracking plan violations: %v", err)
+	}
+	if err := cron.Shutdown(); err != nil {
+		log.Error("Error while stopping scheduler: %v", err)
+	}
+	// Workers and imports that did not finish may still be writing, their
+	// databases are left to be recovered on the next start.
+	if drained {
+		analyticsdb.CloseAll()
+		appdb.CloseAll(appDb)
+	}
+	geoip.Close()
+	log.Info("Shutdown complete")
 }
 
+// usageFlushInterval is how often the counted usage is written to the app
+// database, and the violations of tracking plans to the project databases.
+const usageFlushInterval = 10 * time.Second
+
 func initCronJobs(
 	projectDbs *appdb.ProjectDBLookup,
+	appDb *gorm.DB,
 ) {
 	for projectId, db := range *projectDbs {
 		cron.InitProjectCron(projectId, db, func(projectId string, db *gorm.DB) {
 			parquet.GenerateParquetFiles(projectId, db)
 		})
 	}
+	err := cron.InitIntervalCron(usageFlushInterval, func() {
+		if err := usage.Flush(appDb); err != nil {
+			log.Error("Error while writing usage: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatal("Could not schedule writing usage: %v", err)
+	}
+	err = cron.InitIntervalCron(usageFlushInterval, func() {
+		if err := trackingplan.Flush(*projectDbs); err != nil {
+			log.Error("Error while writing tracking plan violations: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatal("Could not schedule writing tracking plan violations: %v", err)
+	}
 }
 
 func registerTables() {
@@ -54,9 +142,20 @@ func registerTables() {
 		&schema.EventSchema{},
 		&schema.EventSchemaProperty{},
 		&schema.EventSchemaPropertyValue{},
+		&schema.PendingSchemaUpdate{},
 		&insightmeta.InsightMeta{},
 		&projects.ProjectSetting{},
 		&filecatalog.FileCatalogEntry{},
+		&useragent.FilteredEvents{},
+		&transformations.TransformationRule{},
+		&privacy.PropertyPolicy{},
+		&sampling.SamplingRule{},
+		&imports.ImportJob{},
+		&deadletters.DeadLetter{},
+		&trackingplan.TrackingPlan{},
+		&trackingplan.ViolationCount{},
+		&groups.GroupType{},
+		&erasure.Erasure{},
 	}
 
 	var appTablesRegistry = []interface{}{
@@ -65,6 +164,7 @@ func registerTables() {
 		&auth.RecoveryToken{},
 		&auth.RealtimeToken{},
 		&apikeys.ApiKey{},
+		&usage.DailyUsage{},
 	}
 	appdb.RegisterTables(projectTablesRegistry, appTablesRegistry)
 }
@@ -3,6 +3,7 @@ package server
 import (
 	"analytics/database/appdb"
 	"analytics/domain/projects"
+	"analytics/server/routes"
 	"net/http"
 	"strings"
 )
@@ -41,7 +42,8 @@ func setIngestionCorsHeaders(w http.ResponseWriter, origin string) {
 	header := w.Header()
 	header.Set("Access-Control-Allow-Origin", origin)
 	header.Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	header.Set("Access-Control-Allow-Headers", "Content-Type, X-API-KEY")
+	header.Set("Access-Control-Allow-Headers", "Content-Type, Content-Encoding, Authorization, X-API-KEY")
+	header.Set("Access-Control-Expose-Headers", "Retry-After, X-Queue-Depth, X-Queue-Capacity")
 	header.Set("Access-Control-Max-Age", "600")
 	header.Add("Vary", "Origin")
 	header.Add("Vary", "Access-Control-Request-Method")
@@ -75,9 +77,19 @@ func isOriginAllowedForProject(projectDbs *appdb.ProjectDBLookup, projectId stri
 }
 
 func isIngestionPath(path string) bool {
-	if path == "/event" {
+	if path == "/event" || path == "/decide" || path == "/decide/" {
 		return true
 	}
+	for _, postHogPath := range routes.PostHogPaths {
+		if path == postHogPath || path == postHogPath+"/" {
+			return true
+		}
+	}
+	for _, segmentPath := range routes.SegmentPaths {
+		if path == segmentPath {
+			return true
+		}
+	}
 	_, hasProjectId := projectIdFromIngestionPath(path)
 	return hasProjectId
 }
@@ -1,13 +1,20 @@
 package sv_mw
 
 import (
+	"analytics/config"
 	"compress/gzip"
+	"errors"
 	"io"
 	"net/http"
 )
 
+// DecompressionMiddleware decompresses gzip encoded bodies. Bodies are
+// limited to the configured maximum before and after decompression, so small
+// compressed requests cannot expand into large ones.
 func DecompressionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := config.Config.Ingestion.MaxBodyBytes
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
 		if r.URL.Query().Get("compression") == "gzip-js" || r.Header.Get("Content-Encoding") == "gzip" {
 			gzipReader, err := gzip.NewReader(r.Body)
 			if err != nil {
@@ -15,8 +22,18 @@ func DecompressionMiddleware(next http.Handler) http.Handler {
 				return
 			}
 			// Replace the request Body with the decompressed stream.
-			r.Body = io.NopCloser(gzipReader)
+			r.Body = http.MaxBytesReader(w, io.NopCloser(gzipReader), limit)
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// BodyErrorStatus answers bodies above the limit of DecompressionMiddleware
+// with 413 and other unreadable bodies with 400.
+func BodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -5,6 +5,7 @@ import (
 	svmw "analytics/server/middlewares"
 	"analytics/util"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"gorm.io/gorm"
@@ -53,10 +54,12 @@ func createKey(w http.ResponseWriter, r *http.Request) {
 	projectId := svmw.GetProjectID(r)
 	appdb := svmw.GetAppDB(r)
 
-	appdb.Create(&apikeys.ApiKey{
+	key := apikeys.ApiKey{
 		Key:     fmt.Sprintf("ds_%s_%s", projectId, util.RandSeq(20)),
 		Project: projectId,
-	})
+	}
+	appdb.Create(&key)
+	apikeys.ForgetAPIKey(key.Key)
 
 	keys := queryKeys(appdb, projectId)
 	w.WriteHeader(http.StatusOK)
@@ -105,5 +108,16 @@ func queryKeys(db *gorm.DB, projectId string) []apikeys.ApiKey {
 }
 
 func deleteKeyFromDb(db *gorm.DB, keyId int, projectId string) error {
-	return db.Delete(&apikeys.ApiKey{}, "id = ? and project = ?", keyId, projectId).Error
+	key, err := queryKey(db, keyId, projectId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := db.Delete(key).Error; err != nil {
+		return err
+	}
+	apikeys.ForgetAPIKey(key.Key)
+	return nil
 }
@@ -0,0 +1,192 @@
+package routes
+
+import (
+	"analytics/domain/deadletters"
+	"analytics/domain/events/processor"
+	"analytics/log"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// deadLetterPageSize is the default and maximum number of dead letters listed
+// or retried at once.
+const deadLetterPageSize = 1000
+
+func SetupDeadLetterRoutes(mux chi.Router) {
+	mux.Get("/dead-letters", listDeadLetters)
+	mux.Delete("/dead-letters", purgeDeadLetters)
+	mux.Post("/dead-letters/retry", retryDeadLetters)
+	mux.Get("/dead-letters/{id}", getDeadLetter)
+	mux.Delete("/dead-letters/{id}", deleteDeadLetter)
+}
+
+// listDeadLetters returns the dead letters without payloads, the latest
+// first. ?limit= and ?offset= page through them.
+func listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryInt(w, r, "limit", deadLetterPageSize)
+	if !ok {
+		return
+	}
+	if limit == 0 || limit > deadLetterPageSize {
+		limit = deadLetterPageSize
+	}
+	offset, ok := queryInt(w, r, "offset", 0)
+	if !ok {
+		return
+	}
+	letters, total, err := deadletters.List(sv_mw.GetProjectDB(r, w), limit, offset)
+	if err != nil {
+		log.Error("Error while listing dead letters: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, struct {
+		Total       int64                    `json:"total"`
+		DeadLetters []deadletters.DeadLetter `json:"deadLetters"`
+	}{
+		Total:       total,
+		DeadLetters: letters,
+	})
+}
+
+func getDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, ok := deadLetterId(w, r)
+	if !ok {
+		return
+	}
+	letter, err := deadletters.Get(sv_mw.GetProjectDB(r, w), id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			util.WriteError(w, http.StatusNotFound, "Dead letter not found")
+			return
+		}
+		log.Error("Error while loading dead letter: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, letter)
+}
+
+// retryDeadLetters stores the events of the dead letters with the given ids
+// again, or of all dead letters if the body has no ids.
+func retryDeadLetters(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Ids []uint `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	db := sv_mw.GetProjectDB(r, w)
+	projectId := sv_mw.GetProjectID(r)
+
+	var result deadletters.RetryResult
+	retry := func(letters []deadletters.DeadLetter) bool {
+		retried, err := processor.RetryDeadLetters(projectId, letters)
+		result.Retried += retried.Retried
+		result.Stored += retried.Stored
+		result.Duplicates += retried.Duplicates
+		result.Failed += retried.Failed
+		if err != nil {
+			log.Error("Project %s: Error while retrying dead letters: %v", projectId, err)
+			util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+			return false
+		}
+		return true
+	}
+
+	if len(input.Ids) > 0 {
+		letters, err := deadletters.Find(db, input.Ids)
+		if err != nil {
+			log.Error("Error while loading dead letters: %v", err)
+			util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if len(letters) > 0 && !retry(letters) {
+			return
+		}
+		util.WriteJSON(w, result)
+		return
+	}
+
+	lastId, err := deadletters.LastID(db)
+	if err != nil {
+		log.Error("Error while loading dead letters: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	var afterId uint
+	for {
+		letters, err := deadletters.Range(db, afterId, lastId, deadLetterPageSize)
+		if err != nil {
+			log.Error("Error while loading dead letters: %v", err)
+			util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if len(letters) == 0 {
+			break
+		}
+		if !retry(letters) {
+			return
+		}
+		afterId = letters[len(letters)-1].ID
+	}
+	util.WriteJSON(w, result)
+}
+
+func deleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, ok := deadLetterId(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := deadletters.Delete(sv_mw.GetProjectDB(r, w), []uint{id})
+	if err != nil {
+		log.Error("Error while deleting dead letter: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if deleted == 0 {
+		util.WriteError(w, http.StatusNotFound, "Dead letter not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func purgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	deleted, err := deadletters.Purge(sv_mw.GetProjectDB(r, w))
+	if err != nil {
+		log.Error("Error while purging dead letters: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, map[string]int64{"deleted": deleted})
+}
+
+func deadLetterId(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "Invalid dead letter id")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func queryInt(w http.ResponseWriter, r *http.Request, name string, fallback int) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, true
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		util.WriteError(w, http.StatusBadRequest, name+" must be a non-negative integer")
+		return 0, false
+	}
+	return parsed, true
+}
@@ -1,24 +1,32 @@
 package routes
 
 import (
+	"analytics/config"
 	"analytics/database/analyticsdb"
-	"analytics/database/appdb"
 	"analytics/domain/apikeys"
 	"analytics/domain/events"
 	"analytics/domain/events/processor"
 	"analytics/domain/projects"
 	"analytics/domain/queries"
+	"analytics/domain/useragent"
 	"analytics/log"
 	sv_mw "analytics/server/middlewares"
+	"analytics/util"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func SetupPrivateEventRoutes(mux chi.Router) {
 	mux.Get("/events", QueryEvents)
 	mux.Post("/events/dummy", GenerateDummyEvents)
+	mux.Get("/events/queue", QueueStatus)
+	mux.Get("/events/bots", FilteredBotEvents)
 }
 
 func AppendEvent(w http.ResponseWriter, r *http.Request) {
@@ -39,9 +47,10 @@ func AppendEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	event, err := decodeEventPayload(r)
+	receivedAt := time.Now()
+	payload, err := decodeEventPayload(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(sv_mw.BodyErrorStatus(err))
 		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
@@ -52,9 +61,163 @@ func AppendEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	processor.ProcessEvents(projectId, event)
+	clock, ok := newEventClock(w, r, projectId, payload.SentAt, receivedAt)
+	if !ok {
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
+	accepted, response := validateEvents(payload.Events, clock)
+	planned, ok := checkTrackingPlan(w, r, projectId, clock.receivedAt, accepted, &response)
+	if !ok {
+		return
+	}
+	if len(planned) > 0 {
+		admitted, ok := admitEvents(w, r, projectId, apikey, len(planned))
+		if !ok {
+			return
+		}
+		stampClient(r, planned)
+		if !processPlannedEvents(w, projectId, planned) {
+			admitted.refund()
+			return
+		}
+	}
+
+	setQueueHeaders(w, projectId)
+	if response.Accepted == 0 && response.Rejected > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// validateEvents checks every event of a batch on its own, so that invalid
+// events do not cause the valid ones to be dropped.
+func validateEvents(payload []json.RawMessage, clock eventClock) ([]*events.EventInput, events.IngestionResponse) {
+	return collectEvents(len(payload), func(i int) (*events.EventInput, *events.ValidationError) {
+		event, validationErr := events.DecodeEventInput(payload[i], config.Config.Ingestion.MaxPropertiesBytes)
+		if validationErr != nil {
+			return nil, validationErr
+		}
+		return event, clock.adjust(event, true)
+	})
+}
+
+// collectEvents converts the count events of a batch one by one and reports
+// the outcome of each of them.
+func collectEvents(count int, convert func(i int) (*events.EventInput, *events.ValidationError)) ([]*events.EventInput, events.IngestionResponse) {
+	accepted := make([]*events.EventInput, 0, count)
+	response := events.IngestionResponse{
+		Results: make([]events.EventResult, 0, count),
+	}
+	for i := 0; i < count; i++ {
+		event, validationErr := convert(i)
+		if validationErr != nil {
+			response.Rejected++
+			response.Results = append(response.Results, events.EventResult{
+				Index:  i,
+				Reason: validationErr.Reason,
+				Error:  validationErr.Message,
+			})
+			continue
+		}
+		accepted = append(accepted, event)
+		response.Accepted++
+		response.Results = append(response.Results, events.EventResult{
+			Index:    i,
+			Accepted: true,
+			Id:       event.Uuid,
+		})
+	}
+	return accepted, response
+}
+
+// stampClient records the client address resolved by the RealIP middleware and
+// the User-Agent header on the events, so they can be enriched with the
+// client's location, browser and device.
+func stampClient(r *http.Request, accepted []*events.EventInput) {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	for _, event := range accepted {
+		event.Ip = ip
+		event.UserAgent = r.UserAgent()
+	}
+}
+
+// respondIngestionError maps errors from queueing events to status codes
+// that tell clients whether and when to retry.
+func respondIngestionError(w http.ResponseWriter, projectId string, err error) {
+	retryAfter := strconv.Itoa(int(processor.RetryAfter.Seconds()))
+	switch {
+	case errors.Is(err, processor.ErrQueueFull):
+		setQueueHeaders(w, projectId)
+		w.Header().Set("Retry-After", retryAfter)
+		respondError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, processor.ErrBatchTooLarge):
+		setQueueHeaders(w, projectId)
+		respondError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, processor.ErrProcessorStopped):
+		w.Header().Set("Retry-After", retryAfter)
+		respondError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		respondError(w, http.StatusInternalServerError, "Events could not be stored")
+	}
+}
+
+func setQueueHeaders(w http.ResponseWriter, projectId string) {
+	stats := processor.QueueStatsFor(projectId)
+	w.Header().Set("X-Queue-Depth", strconv.Itoa(stats.Depth))
+	w.Header().Set("X-Queue-Capacity", strconv.Itoa(stats.Capacity))
+}
+
+func QueueStatus(w http.ResponseWriter, r *http.Request) {
+	projectId := sv_mw.GetProjectID(r)
+	util.WriteJSON(w, processor.QueueStatsFor(projectId))
+}
+
+// FilteredBotEvents reports per day how many events of bots were flagged or
+// dropped within the last 30 days, or the number of days given by ?days=.
+func FilteredBotEvents(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			util.WriteError(w, http.StatusBadRequest, "days must be a positive integer")
+			return
+		}
+		days = parsed
+	}
+	db := sv_mw.GetProjectDB(r, w)
+	since := time.Now().AddDate(0, 0, -days+1)
+	filtered, err := useragent.QueryFilteredEvents(db, since)
+	if err != nil {
+		log.Error("Error while querying filtered events: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	settings, err := projects.QuerySettings(sv_mw.GetProjectID(r), db)
+	if err != nil {
+		util.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := struct {
+		Mode    string                     `json:"mode"`
+		Flagged int                        `json:"flagged"`
+		Dropped int                        `json:"dropped"`
+		Days    []useragent.FilteredEvents `json:"days"`
+	}{
+		Mode: settings[projects.BotFilter],
+		Days: filtered,
+	}
+	for _, day := range filtered {
+		response.Flagged += day.Flagged
+		response.Dropped += day.Dropped
+	}
+	util.WriteJSON(w, response)
 }
 
 func allowIngestionOrigin(w http.ResponseWriter, r *http.Request, projectId string) bool {
@@ -62,11 +225,7 @@ func allowIngestionOrigin(w http.ResponseWriter, r *http.Request, projectId stri
 	if origin == "" {
 		return true
 	}
-	dbLookup, ok := r.Context().Value(sv_mw.ProjectDBLookupKey).(*appdb.ProjectDBLookup)
-	if !ok {
-		return false
-	}
-	projectDb, ok := (*dbLookup)[projectId]
+	projectDb, ok := ingestionProjectDB(r, projectId)
 	if !ok {
 		return false
 	}
@@ -79,22 +238,12 @@ func allowIngestionOrigin(w http.ResponseWriter, r *http.Request, projectId stri
 	return true
 }
 
-func decodeEventPayload(r *http.Request) ([]*events.EventInput, error) {
+func decodeEventPayload(r *http.Request) (events.EventBatch, error) {
 	var raw json.RawMessage
 	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
-		return nil, err
-	}
-
-	var batch []*events.EventInput
-	if err := json.Unmarshal(raw, &batch); err == nil {
-		return batch, nil
-	}
-
-	var single events.EventInput
-	if err := json.Unmarshal(raw, &single); err != nil {
-		return nil, err
+		return events.EventBatch{}, err
 	}
-	return []*events.EventInput{&single}, nil
+	return events.SplitEventPayload(raw)
 }
 
 func QueryEvents(w http.ResponseWriter, r *http.Request) {
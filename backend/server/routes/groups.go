@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"analytics/database/analyticsdb"
+	"analytics/domain/groups"
+	"analytics/log"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+func SetupGroupRoutes(mux chi.Router) {
+	mux.Get("/group-types", listGroupTypes)
+	mux.Patch("/group-types/{index}", updateGroupType)
+	mux.Get("/groups/{type}/{key}", getGroup)
+}
+
+func listGroupTypes(w http.ResponseWriter, r *http.Request) {
+	types, err := groups.ListTypes(sv_mw.GetProjectDB(r, w))
+	if err != nil {
+		log.Error("Error while listing group types: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, types)
+}
+
+// updateGroupType changes the display name of a group type. Types are created
+// by the events that use them.
+func updateGroupType(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "Invalid group type index")
+		return
+	}
+	var input struct {
+		DisplayName string `json:"displayName"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	groupType, err := groups.UpdateDisplayName(sv_mw.GetProjectDB(r, w), index, input.DisplayName)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			util.WriteError(w, http.StatusNotFound, "Group type not found")
+			return
+		}
+		log.Error("Error while updating group type: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, groupType)
+}
+
+func getGroup(w http.ResponseWriter, r *http.Request) {
+	analyticsDb := analyticsdb.LookupTable[sv_mw.GetProjectID(r)]
+	if analyticsDb == nil {
+		util.WriteError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+	group, err := groups.GetGroup(analyticsDb, chi.URLParam(r, "type"), chi.URLParam(r, "key"))
+	if err != nil {
+		log.Error("Error while loading group: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if group == nil {
+		util.WriteError(w, http.StatusNotFound, "Group not found")
+		return
+	}
+	util.WriteJSON(w, group)
+}
@@ -0,0 +1,187 @@
+package routes
+
+import (
+	"analytics/config"
+	"analytics/domain/events"
+	"analytics/domain/events/processor"
+	"analytics/domain/imports"
+	"analytics/log"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+)
+
+// maxImportFieldBytes limits the form fields next to the uploaded file.
+const maxImportFieldBytes = 64 * 1024
+
+func SetupImportRoutes(mux chi.Router) {
+	mux.Get("/imports", listImports)
+	mux.Post("/imports", createImport)
+	mux.Get("/imports/{id}", getImport)
+}
+
+func listImports(w http.ResponseWriter, r *http.Request) {
+	jobs, err := imports.ListJobs(sv_mw.GetProjectDB(r, w))
+	if err != nil {
+		log.Error("Error while listing imports: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, jobs)
+}
+
+func getImport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "Invalid import id")
+		return
+	}
+	job, err := imports.GetJob(sv_mw.GetProjectDB(r, w), uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			util.WriteError(w, http.StatusNotFound, "Import not found")
+			return
+		}
+		log.Error("Error while loading import: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, job)
+}
+
+// createImport accepts a multipart form with the file in the "file" field,
+// the column mapping as json in "mapping" and optionally the "format". Vendor
+// exports need no mapping. The
+// file is streamed to disk and imported in the background. The response is
+// the job, which reports the progress.
+func createImport(w http.ResponseWriter, r *http.Request) {
+	db := sv_mw.GetProjectDB(r, w)
+	projectId := sv_mw.GetProjectID(r)
+	upload, err := readImportUpload(r)
+	if err != nil {
+		if upload.path != "" {
+			os.Remove(upload.path)
+		}
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job, err := imports.CreateJob(db, upload.filename, upload.format, upload.mapping)
+	if err != nil {
+		os.Remove(upload.path)
+		log.Error("Error while creating import: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	imports.Start(db, job, upload.path, func(chunk []*events.EventInput) error {
+		return processor.ImportEvents(projectId, chunk)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+type importUpload struct {
+	filename string
+	path     string
+	format   imports.Format
+	mapping  imports.ColumnMapping
+}
+
+func readImportUpload(r *http.Request) (importUpload, error) {
+	var upload importUpload
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return upload, err
+	}
+	var mapping, format string
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return upload, err
+		}
+		switch part.FormName() {
+		case "mapping":
+			mapping, err = readImportField(part)
+		case "format":
+			format, err = readImportField(part)
+		case "file":
+			if upload.path != "" {
+				return upload, errors.New("only one file can be imported at once")
+			}
+			upload.filename = part.FileName()
+			upload.path, err = saveImportFile(part)
+		}
+		part.Close()
+		if err != nil {
+			return upload, err
+		}
+	}
+
+	if upload.path == "" {
+		return upload, errors.New("file is required")
+	}
+	if format == "" {
+		detected, ok := imports.FormatOf(upload.filename)
+		if !ok {
+			return upload, fmt.Errorf("format of %q is unknown, set it to csv, ndjson or parquet", upload.filename)
+		}
+		upload.format = detected
+	} else {
+		parsed, ok := imports.ParseFormat(format)
+		if !ok {
+			return upload, errors.New("format must be one of csv, ndjson, parquet, mixpanel or posthog")
+		}
+		upload.format = parsed
+	}
+	if !upload.format.NeedsMapping() {
+		return upload, nil
+	}
+	if err := json.Unmarshal([]byte(mapping), &upload.mapping); err != nil {
+		return upload, fmt.Errorf("mapping must be a json object: %w", err)
+	}
+	return upload, upload.mapping.Validate()
+}
+
+func readImportField(part io.Reader) (string, error) {
+	value, err := io.ReadAll(io.LimitReader(part, maxImportFieldBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(value) > maxImportFieldBytes {
+		return "", errors.New("form field is too large")
+	}
+	return string(value), nil
+}
+
+// saveImportFile streams an uploaded file to the imports directory, so files
+// larger than the memory can be imported.
+func saveImportFile(part io.Reader) (string, error) {
+	dir := path.Join(config.Config.Paths.Database, "imports")
+	if err := util.EnsureDirectory(dir); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, part); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,169 @@
+package routes
+
+import (
+	"analytics/config"
+	"analytics/database/appdb"
+	"analytics/domain/events"
+	"analytics/domain/projects"
+	"analytics/domain/trackingplan"
+	"analytics/domain/transformations"
+	"analytics/log"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"fmt"
+	"gorm.io/gorm"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ingestionSettingsTTL is how long the settings of a project are reused by
+// the ingestion routes before they are read again. Updating the settings
+// through the API drops them right away.
+const ingestionSettingsTTL = time.Minute
+
+type cachedSettings struct {
+	settings map[projects.ProjectSettingKey]string
+	plan     *trackingplan.Checker
+	rules    []transformations.TransformationRule
+	expires  time.Time
+}
+
+var (
+	settingsMu    sync.Mutex
+	settingsCache = make(map[string]cachedSettings)
+)
+
+// ingestionSettings returns the settings of a project that was resolved from
+// an api key.
+func ingestionSettings(r *http.Request, projectId string, now time.Time) (map[projects.ProjectSettingKey]string, error) {
+	cached, err := cachedIngestionSettings(r, projectId, now)
+	return cached.settings, err
+}
+
+func cachedIngestionSettings(r *http.Request, projectId string, now time.Time) (cachedSettings, error) {
+	settingsMu.Lock()
+	cached, ok := settingsCache[projectId]
+	settingsMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached, nil
+	}
+
+	db, ok := ingestionProjectDB(r, projectId)
+	if !ok {
+		return cachedSettings{}, fmt.Errorf("database of project %s not found", projectId)
+	}
+	settings, err := projects.QuerySettings(projectId, db)
+	if err != nil {
+		return cachedSettings{}, err
+	}
+	plan, err := trackingplan.GetPlan(db)
+	if err != nil {
+		return cachedSettings{}, err
+	}
+	cached = cachedSettings{settings: settings, plan: trackingplan.NewChecker(plan), expires: now.Add(ingestionSettingsTTL)}
+	// The rules are only needed to check events against plans in reject mode.
+	if cached.plan.Mode() == trackingplan.Reject {
+		if cached.rules, err = transformations.ListRules(db); err != nil {
+			return cachedSettings{}, err
+		}
+	}
+	settingsMu.Lock()
+	settingsCache[projectId] = cached
+	settingsMu.Unlock()
+	return cached, nil
+}
+
+// forgetIngestionSettings makes the next request of the project read its
+// settings, tracking plan and transformation rules again.
+func forgetIngestionSettings(projectId string) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	delete(settingsCache, projectId)
+}
+
+// timestampWindow returns the handling of implausible timestamps from the
+// project settings or the configured defaults.
+func timestampWindow(projectId string, settings map[projects.ProjectSettingKey]string) events.TimestampWindow {
+	window := events.TimestampWindow{
+		MaxAge:    config.Config.Ingestion.TimestampMaxAge,
+		MaxFuture: config.Config.Ingestion.TimestampMaxFuture,
+	}
+	policy := config.Config.Ingestion.TimestampPolicy
+	if value := settings[projects.TimestampPolicy]; value != "" {
+		policy = value
+	}
+	if parsed, ok := events.ParseTimestampPolicy(policy); ok {
+		window.Policy = parsed
+	} else {
+		log.Warn("Project %s: Invalid timestamp policy %q", projectId, policy)
+		window.Policy = events.TimestampPolicyOff
+	}
+	window.MaxAge = durationSetting(projectId, settings, projects.TimestampMaxAge, window.MaxAge)
+	window.MaxFuture = durationSetting(projectId, settings, projects.TimestampMaxFuture, window.MaxFuture)
+	return window
+}
+
+func durationSetting(projectId string, settings map[projects.ProjectSettingKey]string, key projects.ProjectSettingKey, fallback time.Duration) time.Duration {
+	value := settings[key]
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Warn("Project %s: Invalid %s setting %q", projectId, key, value)
+		return fallback
+	}
+	return duration
+}
+
+// ingestionProjectDB looks up the database of a project that was resolved from
+// an api key, as ingestion routes do not pass through the project middleware.
+func ingestionProjectDB(r *http.Request, projectId string) (*gorm.DB, bool) {
+	dbLookup, ok := r.Context().Value(sv_mw.ProjectDBLookupKey).(*appdb.ProjectDBLookup)
+	if !ok {
+		return nil, false
+	}
+	db, ok := (*dbLookup)[projectId]
+	return db, ok
+}
+
+// eventClock corrects the timestamps of the events of a single request.
+type eventClock struct {
+	sentAt     time.Time
+	receivedAt time.Time
+	window     events.TimestampWindow
+}
+
+// newEventClock prepares the timestamp correction for a request whose client
+// reported sending it at sentAt. If that fails, it responds to the request
+// and returns false.
+func newEventClock(w http.ResponseWriter, r *http.Request, projectId string, sentAt string, receivedAt time.Time) (eventClock, bool) {
+	parsed, err := events.ParseSentAt(sentAt)
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return eventClock{}, false
+	}
+	settings, err := ingestionSettings(r, projectId, receivedAt)
+	if err != nil {
+		log.Error("Project %s: Error while reading settings: %v", projectId, err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return eventClock{}, false
+	}
+	return eventClock{
+		sentAt:     parsed,
+		receivedAt: receivedAt,
+		window:     timestampWindow(projectId, settings),
+	}, true
+}
+
+// adjust corrects the timestamp of an event set by the client's clock and
+// checks it against the project's window. Timestamps that were derived from
+// the server's clock are only checked.
+func (c eventClock) adjust(event *events.EventInput, clientClock bool) *events.ValidationError {
+	sentAt := c.sentAt
+	if !clientClock {
+		sentAt = time.Time{}
+	}
+	return events.AdjustTimestamp(event, sentAt, c.receivedAt, c.window)
+}
@@ -0,0 +1,123 @@
+package routes
+
+import (
+	"analytics/config"
+	"analytics/domain/projects"
+	"analytics/domain/ratelimit"
+	"analytics/domain/usage"
+	"analytics/log"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"fmt"
+	"gorm.io/gorm"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var ingestionLimiter = ratelimit.NewLimiter()
+
+// ProjectLimits are the rate limit and monthly quota of a project, from its
+// settings or the configured defaults. 0 means unlimited.
+type ProjectLimits struct {
+	RateLimit    int   `json:"rateLimit"`
+	MonthlyQuota int64 `json:"monthlyQuota"`
+}
+
+// admission holds what admitEvents took for the events of a request.
+type admission struct {
+	projectId string
+	now       time.Time
+	count     int
+	limits    []ratelimit.Limit
+}
+
+// refund gives back the usage and rate limit tokens of events that could not
+// be queued.
+func (a admission) refund() {
+	usage.Release(a.projectId, a.now, int64(a.count))
+	ingestionLimiter.Return(a.count, a.limits...)
+}
+
+// admitEvents reserves count events of the monthly quota of the project and
+// takes them from the rate limits of the api key and the project. If the
+// events must not be ingested, it responds to the request and returns false.
+// Otherwise the events are counted towards the usage of the project until the
+// admission is refunded.
+func admitEvents(w http.ResponseWriter, r *http.Request, projectId string, apiKey string, count int) (admission, bool) {
+	now := time.Now()
+	settings, err := ingestionSettings(r, projectId, now)
+	if err != nil {
+		log.Error("Project %s: Error while reading limits: %v", projectId, err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return admission{}, false
+	}
+	limits := projectLimits(projectId, settings)
+
+	if limits.MonthlyQuota > 0 {
+		used, ok, err := usage.Reserve(sv_mw.GetAppDB(r), projectId, now, int64(count), limits.MonthlyQuota)
+		if err != nil {
+			log.Error("Project %s: Error while reading usage: %v", projectId, err)
+			util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+			return admission{}, false
+		}
+		if !ok {
+			usage.Record(projectId, now, usage.DailyUsage{OverQuota: int64(count)})
+			util.WriteError(w, http.StatusPaymentRequired, fmt.Sprintf(
+				"monthly quota of %d events exceeded, %d events were used", limits.MonthlyQuota, used))
+			return admission{}, false
+		}
+	} else {
+		usage.Record(projectId, now, usage.DailyUsage{Events: int64(count)})
+	}
+
+	burstSeconds := float64(max(config.Config.Ingestion.RateLimit.BurstSeconds, 1))
+	keyRate := float64(config.Config.Ingestion.RateLimit.Key)
+	projectRate := float64(limits.RateLimit)
+	rateLimits := []ratelimit.Limit{
+		{Key: "key:" + apiKey, Rate: keyRate, Burst: keyRate * burstSeconds},
+		{Key: "project:" + projectId, Rate: projectRate, Burst: projectRate * burstSeconds},
+	}
+	ok, retryAfter := ingestionLimiter.Take(now, count, rateLimits...)
+	if !ok {
+		usage.Release(projectId, now, int64(count))
+		usage.Record(projectId, now, usage.DailyUsage{RateLimited: int64(count)})
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		util.WriteError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return admission{}, false
+	}
+	return admission{projectId: projectId, now: now, count: count, limits: rateLimits}, true
+}
+
+func queryProjectLimits(projectId string, db *gorm.DB) (ProjectLimits, error) {
+	settings, err := projects.QuerySettings(projectId, db)
+	if err != nil {
+		return ProjectLimits{}, err
+	}
+	return projectLimits(projectId, settings), nil
+}
+
+func projectLimits(projectId string, settings map[projects.ProjectSettingKey]string) ProjectLimits {
+	limits := ProjectLimits{
+		RateLimit:    config.Config.Ingestion.RateLimit.Project,
+		MonthlyQuota: int64(config.Config.Ingestion.MonthlyQuota),
+	}
+	if value := settings[projects.RateLimit]; value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			log.Warn("Project %s: Invalid rate limit setting %q", projectId, value)
+		} else {
+			limits.RateLimit = limit
+		}
+	}
+	if value := settings[projects.MonthlyQuota]; value != "" {
+		quota, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Warn("Project %s: Invalid monthly quota setting %q", projectId, value)
+		} else {
+			limits.MonthlyQuota = quota
+		}
+	}
+	return limits
+}
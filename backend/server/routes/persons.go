@@ -1,16 +1,40 @@
 package routes
 
 import (
+	"analytics/database/analyticsdb"
+	"analytics/domain/erasure"
+	"analytics/domain/events"
+	"analytics/domain/events/processor"
+	"analytics/domain/person"
 	"analytics/domain/schemafixer"
+	"analytics/log"
 	sv_mw "analytics/server/middlewares"
+	"analytics/util"
 	"github.com/go-chi/chi/v5"
 	"net/http"
+	"strings"
 )
 
+// personPageSize is the default and maximum number of persons or events of a
+// person returned at once.
+const personPageSize = 100
+
+// profileSessionLimit is the number of sessions a profile lists.
+const profileSessionLimit = 100
+
 func SetupFixupRoute(mux chi.Router) {
 	mux.Patch("/", fixupPeronsAndSchema)
 }
 
+func SetupPersonRoutes(mux chi.Router) {
+	mux.Get("/persons", listPersons)
+	mux.Get("/persons/{id}", getPerson)
+	mux.Get("/persons/{id}/events", personTimeline)
+	mux.Delete("/persons/{id}", deletePerson)
+	mux.Post("/persons/{id}/erasure", erasePerson)
+	mux.Get("/erasures", listErasures)
+}
+
 func fixupPeronsAndSchema(w http.ResponseWriter, r *http.Request) {
 	projectId := sv_mw.GetProjectID(r)
 	if err := schemafixer.FixupPersonsAndSchema(projectId); err != nil {
@@ -20,3 +44,197 @@ func fixupPeronsAndSchema(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
 }
+
+// listPersons returns the persons matching ?search= and the property filters,
+// e.g. ?properties.plan__eq=pro, the latest first. ?limit= and ?offset= page
+// through them.
+func listPersons(w http.ResponseWriter, r *http.Request) {
+	analyticsDb, ok := personAnalyticsDB(w, r)
+	if !ok {
+		return
+	}
+	params := person.ListParams{Search: strings.TrimSpace(r.URL.Query().Get("search"))}
+	if params.Limit, ok = personPageLimit(w, r); !ok {
+		return
+	}
+	if params.Offset, ok = queryInt(w, r, "offset", 0); !ok {
+		return
+	}
+	for key, values := range r.URL.Query() {
+		if !strings.HasPrefix(key, "properties.") {
+			continue
+		}
+		for _, value := range values {
+			filter, err := person.ParsePropertyFilter(key, value)
+			if err != nil {
+				util.WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			params.Filters = append(params.Filters, filter)
+		}
+	}
+
+	persons, total, err := person.List(analyticsDb, params)
+	if err != nil {
+		log.Error("Error while listing persons: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, struct {
+		Total   int64           `json:"total"`
+		Persons []person.Person `json:"persons"`
+	}{
+		Total:   total,
+		Persons: persons,
+	})
+}
+
+// getPerson returns the profile of a person. Ids that were merged into
+// another person return the profile of that person.
+func getPerson(w http.ResponseWriter, r *http.Request) {
+	analyticsDb, personId, ok := personOfRequest(w, r)
+	if !ok {
+		return
+	}
+	profile, err := person.GetProfile(analyticsDb, personId, profileSessionLimit)
+	if err != nil {
+		log.Error("Error while loading person: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if profile == nil {
+		util.WriteError(w, http.StatusNotFound, "Person not found")
+		return
+	}
+	util.WriteJSON(w, profile)
+}
+
+// personTimeline returns the events of a person, the latest first. ?limit=
+// and ?offset= page through them.
+func personTimeline(w http.ResponseWriter, r *http.Request) {
+	analyticsDb, personId, ok := personOfRequest(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := personPageLimit(w, r)
+	if !ok {
+		return
+	}
+	offset, ok := queryInt(w, r, "offset", 0)
+	if !ok {
+		return
+	}
+	timeline, total, err := events.QueryPersonTimeline(analyticsDb, personId, limit, offset)
+	if err != nil {
+		log.Error("Error while querying events of person: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, struct {
+		Total  int64                `json:"total"`
+		Events []events.EventOutput `json:"events"`
+	}{
+		Total:  total,
+		Events: *timeline,
+	})
+}
+
+// deletePerson deletes a person with its events and sessions.
+func deletePerson(w http.ResponseWriter, r *http.Request) {
+	analyticsDb, personId, ok := personOfRequest(w, r)
+	if !ok {
+		return
+	}
+	profile, err := person.GetProfile(analyticsDb, personId, 0)
+	if err != nil {
+		log.Error("Error while loading person: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if profile == nil {
+		util.WriteError(w, http.StatusNotFound, "Person not found")
+		return
+	}
+	deletion, err := processor.DeletePerson(sv_mw.GetProjectID(r), personId)
+	if err != nil {
+		log.Error("Error while deleting person: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, deletion)
+}
+
+// erasePerson erases a person on request of its subject and returns the
+// record of the erasure. Unlike deletePerson it scrubs the schema values of
+// the person and rewrites the exported files before it responds.
+func erasePerson(w http.ResponseWriter, r *http.Request) {
+	analyticsDb, personId, ok := personOfRequest(w, r)
+	if !ok {
+		return
+	}
+	profile, err := person.GetProfile(analyticsDb, personId, 0)
+	if err != nil {
+		log.Error("Error while loading person: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if profile == nil {
+		util.WriteError(w, http.StatusNotFound, "Person not found")
+		return
+	}
+	record, err := processor.ErasePerson(sv_mw.GetProjectID(r), personId)
+	if err != nil {
+		log.Error("Error while erasing person in erasure %d: %v", record.ID, err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, record)
+}
+
+// listErasures returns the recorded erasures, the latest first. ?subject=
+// finds the erasures of a person id.
+func listErasures(w http.ResponseWriter, r *http.Request) {
+	db := sv_mw.GetProjectDB(r, w)
+	erasures, err := erasure.List(db, r.URL.Query().Get("subject"))
+	if err != nil {
+		log.Error("Error while listing erasures: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, erasures)
+}
+
+func personAnalyticsDB(w http.ResponseWriter, r *http.Request) (*analyticsdb.DuckDBConnection, bool) {
+	analyticsDb := analyticsdb.LookupTable[sv_mw.GetProjectID(r)]
+	if analyticsDb == nil {
+		util.WriteError(w, http.StatusNotFound, "Project not found")
+		return nil, false
+	}
+	return analyticsDb, true
+}
+
+// personOfRequest resolves the id of the request to the person it belongs to.
+func personOfRequest(w http.ResponseWriter, r *http.Request) (*analyticsdb.DuckDBConnection, string, bool) {
+	analyticsDb, ok := personAnalyticsDB(w, r)
+	if !ok {
+		return nil, "", false
+	}
+	personId, err := person.CanonicalId(analyticsDb, chi.URLParam(r, "id"))
+	if err != nil {
+		log.Error("Error while resolving person: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return nil, "", false
+	}
+	return analyticsDb, personId, true
+}
+
+func personPageLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limit, ok := queryInt(w, r, "limit", personPageSize)
+	if !ok {
+		return 0, false
+	}
+	if limit == 0 || limit > personPageSize {
+		limit = personPageSize
+	}
+	return limit, true
+}
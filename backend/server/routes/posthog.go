@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"analytics/config"
+	"analytics/domain/apikeys"
+	"analytics/domain/events"
+	"analytics/domain/events/posthog"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"time"
+)
+
+// PostHogPaths are the endpoints the PostHog SDKs send to. Point the SDK's
+// api_host to the /api path of this server to use them.
+var PostHogPaths = []string{"/capture", "/batch", "/e", "/track", "/engage"}
+
+func SetupPostHogRoutes(mux chi.Router) {
+	mux.Group(func(mux chi.Router) {
+		mux.Use(sv_mw.DecompressionMiddleware)
+		for _, path := range PostHogPaths {
+			mux.Post(path, PostHogCapture)
+			mux.Post(path+"/", PostHogCapture)
+		}
+		mux.Post("/decide", PostHogDecide)
+		mux.Post("/decide/", PostHogDecide)
+	})
+}
+
+func PostHogCapture(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	payload, ok := readPostHogPayload(w, r)
+	if !ok {
+		return
+	}
+	projectId, apikey, ok := authorizePostHogRequest(w, r, payload)
+	if !ok {
+		return
+	}
+
+	receivedAt := time.Now()
+	clock, ok := newEventClock(w, r, projectId, payload.SentAt, receivedAt)
+	if !ok {
+		return
+	}
+
+	accepted, response := translatePostHogEvents(payload, clock)
+	planned, ok := checkTrackingPlan(w, r, projectId, clock.receivedAt, accepted, &response)
+	if !ok {
+		return
+	}
+	if len(planned) > 0 {
+		admitted, ok := admitEvents(w, r, projectId, apikey, len(planned))
+		if !ok {
+			return
+		}
+		stampClient(r, planned)
+		if !processPlannedEvents(w, projectId, planned) {
+			admitted.refund()
+			return
+		}
+	}
+
+	setQueueHeaders(w, projectId)
+	if response.Accepted == 0 && response.Rejected > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	util.WriteJSON(w, map[string]int{"status": 1})
+}
+
+// PostHogDecide answers the SDK's configuration request. Feature flags are not
+// supported, so the SDK is told that none are enabled.
+func PostHogDecide(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	payload, ok := readPostHogPayload(w, r)
+	if !ok {
+		return
+	}
+	if _, _, ok := authorizePostHogRequest(w, r, payload); !ok {
+		return
+	}
+	util.WriteJSON(w, posthog.NewDecideResponse())
+}
+
+func readPostHogPayload(w http.ResponseWriter, r *http.Request) (*posthog.CapturePayload, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondError(w, sv_mw.BodyErrorStatus(err), err.Error())
+		return nil, false
+	}
+	payload, err := posthog.ParseCapturePayload(body)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return payload, true
+}
+
+// authorizePostHogRequest resolves the project from the api key, which the
+// SDKs send in the body instead of a header. It returns the project and the
+// key.
+func authorizePostHogRequest(w http.ResponseWriter, r *http.Request, payload *posthog.CapturePayload) (string, string, bool) {
+	apikey := payload.ApiKey
+	if apikey == "" {
+		apikey = r.Header.Get("X-API-KEY")
+	}
+	if apikey == "" {
+		respondError(w, http.StatusUnauthorized, "api_key not found")
+		return "", "", false
+	}
+	projectId, err := apikeys.ValidateAPIKey(sv_mw.GetAppDB(r), apikey)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, "Invalid ApiKey")
+		return "", "", false
+	}
+	if !allowIngestionOrigin(w, r, projectId) {
+		respondError(w, http.StatusForbidden, "Origin is not allowed for this project")
+		return "", "", false
+	}
+	return projectId, apikey, true
+}
+
+// translatePostHogEvents corrects the timestamps the SDKs set by the clock of
+// the client. Events with an offset instead are timed by the server already.
+func translatePostHogEvents(payload *posthog.CapturePayload, clock eventClock) ([]*events.EventInput, events.IngestionResponse) {
+	return collectEvents(len(payload.Events), func(i int) (*events.EventInput, *events.ValidationError) {
+		event, validationErr := posthog.ToEventInput(payload.Events[i], clock.receivedAt)
+		if validationErr != nil {
+			return nil, validationErr
+		}
+		if validationErr := clock.adjust(event, payload.Events[i].Timestamp != ""); validationErr != nil {
+			return nil, validationErr
+		}
+		return event, events.CheckPropertiesLength(event, config.Config.Ingestion.MaxPropertiesBytes)
+	})
+}
@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"analytics/domain/privacy"
+	"analytics/log"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+func SetupPrivacyRoutes(mux chi.Router) {
+	mux.Get("/property-policies", listPropertyPolicies)
+	mux.Post("/property-policies", createPropertyPolicy)
+	mux.Get("/property-policies/{id}", getPropertyPolicy)
+	mux.Put("/property-policies/{id}", updatePropertyPolicy)
+	mux.Delete("/property-policies/{id}", deletePropertyPolicy)
+}
+
+func parsePropertyPolicyId(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "Invalid policy id")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondPropertyPolicyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		util.WriteError(w, http.StatusNotFound, "Policy not found")
+		return
+	}
+	log.Error("Error while handling property policy: %v", err)
+	util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+}
+
+func decodePropertyPolicy(w http.ResponseWriter, r *http.Request) (privacy.PolicyInput, bool) {
+	var input privacy.PolicyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return input, false
+	}
+	if err := input.Validate(); err != nil {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return input, false
+	}
+	return input, true
+}
+
+func listPropertyPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := privacy.ListPolicies(sv_mw.GetProjectDB(r, w))
+	if err != nil {
+		respondPropertyPolicyError(w, err)
+		return
+	}
+	util.WriteJSON(w, policies)
+}
+
+func getPropertyPolicy(w http.ResponseWriter, r *http.Request) {
+	id, ok := parsePropertyPolicyId(w, r)
+	if !ok {
+		return
+	}
+	policy, err := privacy.GetPolicy(sv_mw.GetProjectDB(r, w), id)
+	if err != nil {
+		respondPropertyPolicyError(w, err)
+		return
+	}
+	util.WriteJSON(w, policy)
+}
+
+func createPropertyPolicy(w http.ResponseWriter, r *http.Request) {
+	input, ok := decodePropertyPolicy(w, r)
+	if !ok {
+		return
+	}
+	policy, err := privacy.CreatePolicy(sv_mw.GetProjectDB(r, w), input)
+	if err != nil {
+		respondPropertyPolicyError(w, err)
+		return
+	}
+	util.WriteJSON(w, policy)
+}
+
+func updatePropertyPolicy(w http.ResponseWriter, r *http.Request) {
+	id, ok := parsePropertyPolicyId(w, r)
+	if !ok {
+		return
+	}
+	input, ok := decodePropertyPolicy(w, r)
+	if !ok {
+		return
+	}
+	policy, err := privacy.UpdatePolicy(sv_mw.GetProjectDB(r, w), id, input)
+	if err != nil {
+		respondPropertyPolicyError(w, err)
+		return
+	}
+	util.WriteJSON(w, policy)
+}
+
+func deletePropertyPolicy(w http.ResponseWriter, r *http.Request) {
+	id, ok := parsePropertyPolicyId(w, r)
+	if !ok {
+		return
+	}
+	if err := privacy.DeletePolicy(sv_mw.GetProjectDB(r, w), id); err != nil {
+		respondPropertyPolicyError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
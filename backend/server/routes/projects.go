@@ -2,7 +2,10 @@ package routes
 
 import (
 	"analytics/database/appdb"
+	"analytics/domain/events"
+	"analytics/domain/events/processor"
 	projects2 "analytics/domain/projects"
+	"analytics/domain/useragent"
 	"analytics/log"
 	sv_mw "analytics/server/middlewares"
 	"encoding/json"
@@ -10,6 +13,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type projectData struct {
@@ -121,11 +125,49 @@ func UpdateProjectSettings(w http.ResponseWriter, r *http.Request) {
 			}
 			update.Value = formatted
 		}
+		if update.Key == projects2.QueueCapacity && update.Value != "" {
+			if capacity, err := strconv.Atoi(update.Value); err != nil || capacity <= 0 {
+				http.Error(w, "queue capacity must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+		if (update.Key == projects2.RateLimit || update.Key == projects2.MonthlyQuota) && update.Value != "" {
+			if limit, err := strconv.Atoi(update.Value); err != nil || limit < 0 {
+				http.Error(w, fmt.Sprintf("%s must be a non-negative integer", update.Key), http.StatusBadRequest)
+				return
+			}
+		}
+		if update.Key == projects2.TimestampPolicy && update.Value != "" {
+			if _, ok := events.ParseTimestampPolicy(update.Value); !ok {
+				http.Error(w, "timestamp policy must be one of off, clamp or reject", http.StatusBadRequest)
+				return
+			}
+		}
+		if (update.Key == projects2.TimestampMaxAge || update.Key == projects2.TimestampMaxFuture) && update.Value != "" {
+			if duration, err := time.ParseDuration(update.Value); err != nil || duration < 0 {
+				http.Error(w, fmt.Sprintf("%s must be a duration like 24h", update.Key), http.StatusBadRequest)
+				return
+			}
+		}
+		if update.Key == projects2.PropertyHashSecret {
+			http.Error(w, "the property hash secret cannot be changed", http.StatusBadRequest)
+			return
+		}
+		if update.Key == projects2.BotFilter {
+			if _, ok := useragent.ParseBotFilterMode(update.Value); !ok {
+				http.Error(w, "bot filter must be one of off, flag or drop", http.StatusBadRequest)
+				return
+			}
+		}
 		if err := projects2.UpdateSetting(db, update.Key, update.Value); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if update.Key == projects2.QueueCapacity {
+			processor.UpdateQueueCapacity(sv_mw.GetProjectID(r))
+		}
 	}
+	forgetIngestionSettings(sv_mw.GetProjectID(r))
 	ListProjects(w, r)
 }
 
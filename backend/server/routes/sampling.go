@@ -0,0 +1,72 @@
+package routes
+
+import (
+	"analytics/domain/sampling"
+	"analytics/log"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+func SetupSamplingRoutes(mux chi.Router) {
+	mux.Get("/sampling-rules", listSamplingRules)
+	mux.Post("/sampling-rules", saveSamplingRule)
+	mux.Delete("/sampling-rules/{id}", deleteSamplingRule)
+}
+
+func listSamplingRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := sampling.ListRules(sv_mw.GetProjectDB(r, w))
+	if err != nil {
+		log.Error("Error while listing sampling rules: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, rules)
+}
+
+// saveSamplingRule creates the rule for the event type of the request, or
+// replaces the rate of the existing one.
+func saveSamplingRule(w http.ResponseWriter, r *http.Request) {
+	var input sampling.SamplingRule
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := input.Validate(); err != nil {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rule, err := sampling.SaveRule(sv_mw.GetProjectDB(r, w), sampling.SamplingRule{
+		EventType: input.EventType,
+		Rate:      input.Rate,
+	})
+	if err != nil {
+		log.Error("Error while saving sampling rule: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, rule)
+}
+
+func deleteSamplingRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "Invalid rule id")
+		return
+	}
+	if err := sampling.DeleteRule(sv_mw.GetProjectDB(r, w), uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			util.WriteError(w, http.StatusNotFound, "Rule not found")
+			return
+		}
+		log.Error("Error while deleting sampling rule: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,136 @@
+package routes
+
+import (
+	"analytics/config"
+	"analytics/domain/apikeys"
+	"analytics/domain/events"
+	"analytics/domain/events/segment"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"time"
+)
+
+// SegmentPaths are the endpoints of the Segment HTTP Tracking API. Point the
+// Segment libraries' host to the /api path of this server to use them.
+var SegmentPaths = []string{
+	"/v1/track", "/v1/identify", "/v1/page", "/v1/screen", "/v1/alias", "/v1/group",
+	"/v1/batch", "/v1/import",
+}
+
+func SetupSegmentRoutes(mux chi.Router) {
+	mux.Group(func(mux chi.Router) {
+		mux.Use(sv_mw.DecompressionMiddleware)
+		mux.Post("/v1/track", segmentCall(segment.TypeTrack))
+		mux.Post("/v1/identify", segmentCall(segment.TypeIdentify))
+		mux.Post("/v1/page", segmentCall(segment.TypePage))
+		mux.Post("/v1/screen", segmentCall(segment.TypeScreen))
+		mux.Post("/v1/alias", segmentCall(segment.TypeAlias))
+		mux.Post("/v1/group", segmentCall(segment.TypeGroup))
+		mux.Post("/v1/batch", SegmentBatch)
+		mux.Post("/v1/import", SegmentBatch)
+	})
+}
+
+func segmentCall(messageType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var message segment.Message
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			util.WriteError(w, sv_mw.BodyErrorStatus(err), err.Error())
+			return
+		}
+		message.Type = messageType
+		ingestSegmentMessages(w, r, segment.BatchPayload{
+			Batch:    []segment.Message{message},
+			WriteKey: message.WriteKey,
+			SentAt:   message.SentAt,
+		})
+	}
+}
+
+func SegmentBatch(w http.ResponseWriter, r *http.Request) {
+	var payload segment.BatchPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		util.WriteError(w, sv_mw.BodyErrorStatus(err), err.Error())
+		return
+	}
+	ingestSegmentMessages(w, r, payload)
+}
+
+func ingestSegmentMessages(w http.ResponseWriter, r *http.Request, payload segment.BatchPayload) {
+	w.Header().Set("Content-Type", "application/json")
+	projectId, writeKey, ok := authorizeSegmentRequest(w, r, payload)
+	if !ok {
+		return
+	}
+
+	clock, ok := newEventClock(w, r, projectId, payload.SentAt, time.Now())
+	if !ok {
+		return
+	}
+
+	accepted, response := translateSegmentMessages(payload, clock)
+	planned, ok := checkTrackingPlan(w, r, projectId, clock.receivedAt, accepted, &response)
+	if !ok {
+		return
+	}
+	if len(planned) > 0 {
+		admitted, ok := admitEvents(w, r, projectId, writeKey, len(planned))
+		if !ok {
+			return
+		}
+		stampClient(r, planned)
+		if !processPlannedEvents(w, projectId, planned) {
+			admitted.refund()
+			return
+		}
+	}
+
+	setQueueHeaders(w, projectId)
+	if response.Accepted == 0 && response.Rejected > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	util.WriteJSON(w, segment.Response{Success: true})
+}
+
+// authorizeSegmentRequest resolves the project from the write key. The Segment
+// libraries send it as the Basic auth user name, some also in the body. It
+// returns the project and the write key.
+func authorizeSegmentRequest(w http.ResponseWriter, r *http.Request, payload segment.BatchPayload) (string, string, bool) {
+	writeKey, _, _ := r.BasicAuth()
+	if writeKey == "" {
+		writeKey = payload.WriteKey
+	}
+	if writeKey == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Segment write key"`)
+		util.WriteError(w, http.StatusUnauthorized, "write key not found")
+		return "", "", false
+	}
+	projectId, err := apikeys.ValidateAPIKey(sv_mw.GetAppDB(r), writeKey)
+	if err != nil {
+		util.WriteError(w, http.StatusUnauthorized, "Invalid write key")
+		return "", "", false
+	}
+	if !allowIngestionOrigin(w, r, projectId) {
+		util.WriteError(w, http.StatusForbidden, "Origin is not allowed for this project")
+		return "", "", false
+	}
+	return projectId, writeKey, true
+}
+
+func translateSegmentMessages(payload segment.BatchPayload, clock eventClock) ([]*events.EventInput, events.IngestionResponse) {
+	return collectEvents(len(payload.Batch), func(i int) (*events.EventInput, *events.ValidationError) {
+		event, validationErr := segment.ToEventInput(payload.Batch[i], payload.Context)
+		if validationErr != nil {
+			return nil, validationErr
+		}
+		if validationErr := clock.adjust(event, true); validationErr != nil {
+			return nil, validationErr
+		}
+		return event, events.CheckPropertiesLength(event, config.Config.Ingestion.MaxPropertiesBytes)
+	})
+}
@@ -0,0 +1,173 @@
+package routes
+
+import (
+	"analytics/domain/events"
+	"analytics/domain/events/processor"
+	"analytics/domain/trackingplan"
+	"analytics/domain/transformations"
+	"analytics/log"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func SetupTrackingPlanRoutes(mux chi.Router) {
+	mux.Get("/tracking-plan", getTrackingPlan)
+	mux.Put("/tracking-plan", saveTrackingPlan)
+	mux.Delete("/tracking-plan", deleteTrackingPlan)
+	mux.Get("/tracking-plan/violations", trackingPlanViolations)
+}
+
+func getTrackingPlan(w http.ResponseWriter, r *http.Request) {
+	plan, err := trackingplan.GetPlan(sv_mw.GetProjectDB(r, w))
+	if err != nil {
+		log.Error("Error while loading tracking plan: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if plan == nil {
+		util.WriteError(w, http.StatusNotFound, "Project has no tracking plan")
+		return
+	}
+	util.WriteJSON(w, plan)
+}
+
+// saveTrackingPlan replaces the tracking plan. Schemas with keywords that
+// tracking plans do not support are refused rather than ignored.
+func saveTrackingPlan(w http.ResponseWriter, r *http.Request) {
+	var input trackingplan.TrackingPlan
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := input.Validate(); err != nil {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	plan, err := trackingplan.SavePlan(sv_mw.GetProjectDB(r, w), input)
+	if err != nil {
+		log.Error("Error while saving tracking plan: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	forgetIngestionSettings(sv_mw.GetProjectID(r))
+	util.WriteJSON(w, plan)
+}
+
+func deleteTrackingPlan(w http.ResponseWriter, r *http.Request) {
+	if err := trackingplan.DeletePlan(sv_mw.GetProjectDB(r, w)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			util.WriteError(w, http.StatusNotFound, "Project has no tracking plan")
+			return
+		}
+		log.Error("Error while deleting tracking plan: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	forgetIngestionSettings(sv_mw.GetProjectID(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// trackingPlanViolations reports the violations of the last 30 days, or the
+// number of days given by ?days=, by event type and by the SDK and app
+// version that sent them.
+func trackingPlanViolations(w http.ResponseWriter, r *http.Request) {
+	days, ok := queryInt(w, r, "days", 30)
+	if !ok {
+		return
+	}
+	if days == 0 {
+		util.WriteError(w, http.StatusBadRequest, "days must be a positive integer")
+		return
+	}
+	since := time.Now().AddDate(0, 0, -days+1)
+	report, err := trackingplan.QueryReport(sv_mw.GetProjectDB(r, w), sv_mw.GetProjectID(r), since)
+	if err != nil {
+		log.Error("Error while querying tracking plan violations: %v", err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, report)
+}
+
+// checkTrackingPlan refuses the accepted events of a request that violate a
+// tracking plan in reject mode and updates the response for them. Events are
+// checked after the transformation rules, like the processor checks the
+// plans of the other modes. It returns the events to ingest. If the plan
+// cannot be read, it responds to the request and returns false.
+func checkTrackingPlan(w http.ResponseWriter, r *http.Request, projectId string, now time.Time, accepted []*events.EventInput, response *events.IngestionResponse) ([]*events.EventInput, bool) {
+	cached, err := cachedIngestionSettings(r, projectId, now)
+	if err != nil {
+		log.Error("Project %s: Error while reading tracking plan: %v", projectId, err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return nil, false
+	}
+	if cached.plan.Mode() != trackingplan.Reject {
+		return accepted, true
+	}
+
+	planned := make([]*events.EventInput, 0, len(accepted))
+	next := 0
+	for i := range response.Results {
+		result := &response.Results[i]
+		if !result.Accepted {
+			continue
+		}
+		event := accepted[next]
+		next++
+		transformed, dropped := transformedCopy(cached.rules, event)
+		if dropped {
+			planned = append(planned, event)
+			continue
+		}
+		violations := cached.plan.Check(transformed)
+		if len(violations) == 0 {
+			planned = append(planned, event)
+			continue
+		}
+		trackingplan.Record(projectId, now, transformed, violations)
+		result.Accepted = false
+		result.Id = nil
+		result.Reason = events.TrackingPlanViolation
+		result.Error = strings.Join(trackingplan.Messages(violations), "; ")
+		response.Accepted--
+		response.Rejected++
+	}
+	return planned, true
+}
+
+// transformedCopy applies the transformation rules to a copy of the event,
+// leaving the event to the processor. It reports whether a rule dropped it.
+func transformedCopy(rules []transformations.TransformationRule, event *events.EventInput) (*events.EventInput, bool) {
+	if len(rules) == 0 {
+		return event, false
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return event, false
+	}
+	var copied events.EventInput
+	if err := json.Unmarshal(encoded, &copied); err != nil {
+		return event, false
+	}
+	_, dropped := transformations.Apply(rules, &copied)
+	return &copied, dropped
+}
+
+// processPlannedEvents queues the events of a request. If that fails, it
+// responds to the request and returns false.
+func processPlannedEvents(w http.ResponseWriter, projectId string, planned []*events.EventInput) bool {
+	if err := processor.ProcessEvents(projectId, planned); err != nil {
+		respondIngestionError(w, projectId, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+package routes
+
+import (
+	"analytics/domain/events"
+	"analytics/domain/transformations"
+	"analytics/log"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+func SetupTransformationRoutes(mux chi.Router) {
+	mux.Get("/transformations", listTransformationRules)
+	mux.Post("/transformations", createTransformationRule)
+	mux.Post("/transformations/dry-run", dryRunTransformationRules)
+	mux.Get("/transformations/{id}", getTransformationRule)
+	mux.Put("/transformations/{id}", updateTransformationRule)
+	mux.Delete("/transformations/{id}", deleteTransformationRule)
+}
+
+func parseTransformationRuleId(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		util.WriteError(w, http.StatusBadRequest, "Invalid rule id")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondTransformationRuleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		util.WriteError(w, http.StatusNotFound, "Rule not found")
+		return
+	}
+	log.Error("Error while handling transformation rule: %v", err)
+	util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+}
+
+func listTransformationRules(w http.ResponseWriter, r *http.Request) {
+	db := sv_mw.GetProjectDB(r, w)
+	rules, err := transformations.ListRules(db)
+	if err != nil {
+		respondTransformationRuleError(w, err)
+		return
+	}
+	util.WriteJSON(w, rules)
+}
+
+func getTransformationRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseTransformationRuleId(w, r)
+	if !ok {
+		return
+	}
+	rule, err := transformations.GetRule(sv_mw.GetProjectDB(r, w), id)
+	if err != nil {
+		respondTransformationRuleError(w, err)
+		return
+	}
+	util.WriteJSON(w, rule)
+}
+
+func decodeTransformationRule(w http.ResponseWriter, r *http.Request) (transformations.RuleInput, bool) {
+	var input transformations.RuleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return input, false
+	}
+	if err := input.Validate(); err != nil {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return input, false
+	}
+	return input, true
+}
+
+func createTransformationRule(w http.ResponseWriter, r *http.Request) {
+	input, ok := decodeTransformationRule(w, r)
+	if !ok {
+		return
+	}
+	rule, err := transformations.CreateRule(sv_mw.GetProjectDB(r, w), input)
+	if err != nil {
+		respondTransformationRuleError(w, err)
+		return
+	}
+	forgetIngestionSettings(sv_mw.GetProjectID(r))
+	util.WriteJSON(w, rule)
+}
+
+func updateTransformationRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseTransformationRuleId(w, r)
+	if !ok {
+		return
+	}
+	input, ok := decodeTransformationRule(w, r)
+	if !ok {
+		return
+	}
+	rule, err := transformations.UpdateRule(sv_mw.GetProjectDB(r, w), id, input)
+	if err != nil {
+		respondTransformationRuleError(w, err)
+		return
+	}
+	forgetIngestionSettings(sv_mw.GetProjectID(r))
+	util.WriteJSON(w, rule)
+}
+
+func deleteTransformationRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseTransformationRuleId(w, r)
+	if !ok {
+		return
+	}
+	if err := transformations.DeleteRule(sv_mw.GetProjectDB(r, w), id); err != nil {
+		respondTransformationRuleError(w, err)
+		return
+	}
+	forgetIngestionSettings(sv_mw.GetProjectID(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type dryRunRequest struct {
+	Event json.RawMessage `json:"event"`
+	// Rules are tested instead of the stored rules if present.
+	Rules []transformations.RuleInput `json:"rules"`
+}
+
+type dryRunRule struct {
+	Id   uint   `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type dryRunResponse struct {
+	Before  *events.EventInput `json:"before"`
+	After   *events.EventInput `json:"after"`
+	Dropped bool               `json:"dropped"`
+	Applied []dryRunRule       `json:"applied"`
+}
+
+// dryRunTransformationRules shows how the rules change a sample event
+// without storing anything.
+func dryRunTransformationRules(w http.ResponseWriter, r *http.Request) {
+	var request dryRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		util.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	before, validationErr := events.DecodeEventInput(request.Event, 0)
+	if validationErr != nil {
+		util.WriteError(w, http.StatusBadRequest, validationErr.Message)
+		return
+	}
+	after, validationErr := events.DecodeEventInput(request.Event, 0)
+	if validationErr != nil {
+		util.WriteError(w, http.StatusBadRequest, validationErr.Message)
+		return
+	}
+	after.Uuid = before.Uuid
+
+	var rules []transformations.TransformationRule
+	if request.Rules != nil {
+		for _, input := range request.Rules {
+			if err := input.Validate(); err != nil {
+				util.WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			rules = append(rules, transformations.TransformationRule{RuleInput: input})
+		}
+	} else {
+		stored, err := transformations.ListRules(sv_mw.GetProjectDB(r, w))
+		if err != nil {
+			respondTransformationRuleError(w, err)
+			return
+		}
+		rules = stored
+	}
+
+	matched, dropped := transformations.Apply(rules, after)
+	response := dryRunResponse{
+		Before:  before,
+		After:   after,
+		Dropped: dropped,
+		Applied: make([]dryRunRule, 0, len(matched)),
+	}
+	if dropped {
+		response.After = nil
+	}
+	for _, index := range matched {
+		response.Applied = append(response.Applied, dryRunRule{
+			Id:   rules[index].ID,
+			Name: rules[index].Name,
+		})
+	}
+	util.WriteJSON(w, response)
+}
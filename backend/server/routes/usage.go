@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"analytics/domain/usage"
+	"analytics/log"
+	sv_mw "analytics/server/middlewares"
+	"analytics/util"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func SetupUsageRoutes(mux chi.Router) {
+	mux.Get("/usage", ProjectUsage)
+}
+
+// ProjectUsage reports the limits of the project, the events it sent this
+// month and per day within the last 30 days, or the number of days given by
+// ?days=.
+func ProjectUsage(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			util.WriteError(w, http.StatusBadRequest, "days must be a positive integer")
+			return
+		}
+		days = parsed
+	}
+	projectId := sv_mw.GetProjectID(r)
+	appDb := sv_mw.GetAppDB(r)
+	limits, err := queryProjectLimits(projectId, sv_mw.GetProjectDB(r, w))
+	if err != nil {
+		log.Error("Project %s: Error while reading limits: %v", projectId, err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	now := time.Now()
+	monthToDate, err := usage.MonthToDate(appDb, projectId, now)
+	if err != nil {
+		log.Error("Project %s: Error while reading usage: %v", projectId, err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	daily, err := usage.QueryDailyUsage(appDb, projectId, now.AddDate(0, 0, -days+1))
+	if err != nil {
+		log.Error("Project %s: Error while reading usage: %v", projectId, err)
+		util.WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	util.WriteJSON(w, struct {
+		ProjectLimits
+		MonthToDate int64              `json:"monthToDate"`
+		Days        []usage.DailyUsage `json:"days"`
+	}{
+		ProjectLimits: limits,
+		MonthToDate:   monthToDate,
+		Days:          daily,
+	})
+}
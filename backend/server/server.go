@@ -8,7 +8,9 @@ import (
 	"analytics/log"
 	svmw "analytics/server/middlewares"
 	"analytics/server/routes"
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"github.com/aarondl/authboss/v3"
 	"github.com/aarondl/authboss/v3/remember"
@@ -27,7 +29,9 @@ var publicFiles embed.FS
 
 var ab *authboss.Authboss
 
-func Start(appDb *gorm.DB, projectDbs *appdb.ProjectDBLookup) {
+// Start serves HTTP until ctx is cancelled. It then stops accepting requests
+// and waits for in-flight requests until the configured shutdown timeout.
+func Start(ctx context.Context, appDb *gorm.DB, projectDbs *appdb.ProjectDBLookup) {
 	var err error
 	ab, err = auth.SetupAuthboss(appDb)
 	if err != nil {
@@ -44,9 +48,19 @@ func Start(appDb *gorm.DB, projectDbs *appdb.ProjectDBLookup) {
 		return false, nil
 	})
 
-	log.Info("Starting server on port %d", config.Config.Port)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal(err.Error(), err)
+	go func() {
+		log.Info("Starting server on port %d", config.Config.Port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err.Error(), err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Info("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Error("Error while shutting down server: %v", err)
 	}
 }
 
@@ -63,7 +77,6 @@ func setupMux(dbs *appdb.ProjectDBLookup, appdb *gorm.DB) *chi.Mux {
 func serveFrontend(mux *chi.Mux) {
 	frontendDir, err := fs.Sub(publicFiles, "public/frontend")
 	if err != nil {
-		panic(err)
 		log.Fatal(err.Error(), err)
 	}
 
@@ -106,7 +119,9 @@ func buildRouter(projectDbs *appdb.ProjectDBLookup) *chi.Mux {
 	mux.Get("/auth/me", routes.CurrentUser)
 
 	mux.Get("/setup", routes.SetupStatus)
-	mux.Post("/event", routes.AppendEvent)
+	mux.With(svmw.DecompressionMiddleware).Post("/event", routes.AppendEvent)
+	routes.SetupPostHogRoutes(mux)
+	routes.SetupSegmentRoutes(mux)
 
 	mux.Group(func(mux chi.Router) {
 		mux.Use(authboss.Middleware2(ab, authboss.RequireNone, authboss.RespondUnauthorized))
@@ -126,14 +141,24 @@ func buildRouter(projectDbs *appdb.ProjectDBLookup) *chi.Mux {
 			routes.SetupInsightRoutes(mux)
 			routes.SetupDashoardRoutes(mux)
 			routes.SetupFixupRoute(mux)
+			routes.SetupPersonRoutes(mux)
 			routes.SetupProjectSpecificRoutes(mux)
 			routes.SetupAPIKeysRoutes(mux)
+			routes.SetupTransformationRoutes(mux)
+			routes.SetupPrivacyRoutes(mux)
+			routes.SetupSamplingRoutes(mux)
+			routes.SetupUsageRoutes(mux)
+			routes.SetupImportRoutes(mux)
+			routes.SetupDeadLetterRoutes(mux)
+			routes.SetupTrackingPlanRoutes(mux)
+			routes.SetupGroupRoutes(mux)
 		})
 		//mux.Group(func(mux chi.Router) {
 		//	mux.Use(svmw.NewWebSocketMiddleware().Middleware)
 		//	mux.Get("/realtime", routes.RealtimeWebSocketEndpoint)
 		//})
 		mux.Group(func(mux chi.Router) {
+			mux.Use(svmw.DecompressionMiddleware)
 			mux.Post("/event", routes.AppendEvent)
 		})
 	})
@@ -20,3 +20,22 @@ export interface Aggregation {
     distinct?: boolean
 }
 
+
+// Builds the SQL for an aggregation over fieldExpr. Non-distinct counts, sums
+// and averages are weighted by sample_weight, so sampled event types report
+// estimates of their unsampled values.
+export const aggregationExpression = (agg: Aggregation, fieldExpr: string): string => {
+    if (agg.distinct) {
+        return `${agg.function}(distinct ${fieldExpr})`
+    }
+    switch (agg.function) {
+        case 'COUNT':
+            return `SUM(CASE WHEN ${fieldExpr} IS NOT NULL THEN sample_weight ELSE 0 END)`
+        case 'SUM':
+            return `SUM(${fieldExpr} * sample_weight)`
+        case 'AVG':
+            return `SUM(${fieldExpr} * sample_weight) / SUM(CASE WHEN ${fieldExpr} IS NOT NULL THEN sample_weight END)`
+        default:
+            return `${agg.function}(${fieldExpr})`
+    }
+}
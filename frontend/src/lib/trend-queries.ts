@@ -1,5 +1,5 @@
 import {Field, FieldFilter} from "@/model/filters"
-import {Aggregation} from "@lib/aggregations.ts";
+import {Aggregation, aggregationExpression} from "@lib/aggregations.ts";
 import {getFieldExpression} from "@lib/field.ts";
 import {QueryParamValue} from "@lib/queries.ts";
 
@@ -53,8 +53,7 @@ export function buildQuery(query: Query): {
         for (const agg of query.aggregations) {
             const castType = agg.function === 'COUNT' ? undefined : 'FLOAT'
             const fieldExpr = getFieldExpression(agg.field, castType)
-            const optionalDistinct = agg.distinct ? 'distinct ' : ''
-            const aggExpr = `${agg.function}(${optionalDistinct}${fieldExpr})${agg.alias ? ` AS ${agg.alias}` : ''}`
+            const aggExpr = `${aggregationExpression(agg, fieldExpr)}${agg.alias ? ` AS ${agg.alias}` : ''}`
             selectParts.push(aggExpr)
         }
     }
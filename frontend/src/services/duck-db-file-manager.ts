@@ -32,10 +32,12 @@ export const createDuckDbFileManager = (
 
         loadMetadata: async () => {
             const files = await FileCatalogApi.getFileChecksums(projectId, dbManager);
+            // Files that were loaded before are loaded again when late events
+            // regenerated them under a new checksum.
             const shouldLoadFile = (f: FileMetadata) => {
                 if (f.autoload) return true;
-                const cache = queryClient.getQueryData<FileMetadata>(FILE_KEY(projectId, f));
-                return cache && cache.checksum === f.checksum;
+                const cached = queryClient.getQueriesData<FileMetadata>({queryKey: ['file', projectId, f.name]});
+                return cached.some(([, cache]) => cache !== undefined);
             };
             const filteredFiles = files.filter(shouldLoadFile);
             set({filesToLoad: filteredFiles});
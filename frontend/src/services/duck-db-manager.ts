@@ -77,7 +77,12 @@ export class DuckDbManager {
     person_id
     text,
     properties
-    json
+    json,
+    -- Events of sampled event types carry the inverse of the sample rate,
+    -- aggregations multiply by it to estimate the unsampled totals.
+    sample_weight
+    double
+    generated always as (coalesce(try_cast(json_extract_string(properties, '$."$sample_weight"') as double), 1)) virtual
 );
         `)
     }